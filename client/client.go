@@ -0,0 +1,76 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// OriginMode is how the server detects the origin slug from the URL
+type OriginMode int
+
+const (
+	OriginInHost      OriginMode = iota // The origin slug is in the host of the base URL
+	OriginInPath                        // /<origin slug>/c!/<params>/<image path>
+	OriginInQuery                       // ?origin=<origin slug>
+	OriginInSignature                   // ?sig=<origin slug>-<version>.<signature>, the key is required
+)
+
+// Client builds the image URLs of an origin.
+// The URLs are signed if the key is given, with the version of the key
+// so that the server accepts them during the key rotation.
+type Client struct {
+	BaseURL    string // Scheme and host like "https://img.example.com"
+	OriginSlug string
+	OriginMode OriginMode
+	Key        string // URL signature key of the origin
+	KeyVersion int    // Version of the key, starts from 1
+}
+
+// URL returns the /c!/ URL of the image which is transformed by the params.
+// The server rejects the URL of the empty params, NoConvert is required to serve the original image.
+// The image path like "photos/cat.jpg" is escaped by each segment.
+func (c *Client) URL(params *Params, imagePath string) string {
+	segments := strings.Split(strings.TrimPrefix(imagePath, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+
+	path := "/c!/" + params.String() + "/" + strings.Join(segments, "/")
+	if c.OriginMode == OriginInPath {
+		path = "/" + c.OriginSlug + path
+	}
+
+	query := url.Values{}
+	if c.OriginMode == OriginInQuery {
+		query.Set("origin", c.OriginSlug)
+	}
+	if c.Key != "" {
+		slug := ""
+		if c.OriginMode == OriginInSignature {
+			slug = c.OriginSlug
+		}
+		query.Set("sig", Sign(c.KeyVersion, path, c.Key, slug))
+	}
+
+	u := strings.TrimSuffix(c.BaseURL, "/") + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// Sign returns the URL signature of the escaped path like "/c!/w=300/photos/cat.jpg":
+// "<version>.<base64url of HMAC-SHA256>", or "<origin slug>-<version>.<base64url of HMAC-SHA256>".
+func Sign(version int, path, key, originSlug string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(path))
+	sig := strconv.Itoa(version) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	if originSlug != "" {
+		sig = originSlug + "-" + sig
+	}
+	return sig
+}
@@ -0,0 +1,47 @@
+package client
+
+import "testing"
+
+func TestClientURL(t *testing.T) {
+	params := NewParams().Size(300, 200).Mode(ModeFit)
+	cases := []struct {
+		client   Client
+		expected string
+	}{
+		{
+			Client{BaseURL: "https://jdv9ab8v.example.com/"},
+			"https://jdv9ab8v.example.com/c!/w=300,h=200,m=fit/photos/my%20cat.jpg",
+		},
+		{
+			Client{BaseURL: "https://example.com", OriginSlug: "jdv9ab8v", OriginMode: OriginInQuery},
+			"https://example.com/c!/w=300,h=200,m=fit/photos/my%20cat.jpg?origin=jdv9ab8v",
+		},
+		{
+			Client{BaseURL: "https://example.com", OriginSlug: "jdv9ab8v", OriginMode: OriginInPath, Key: "secrettest", KeyVersion: 2},
+			"https://example.com/jdv9ab8v/c!/w=300,h=200,m=fit/photos/my%20cat.jpg?sig=2.GH5G3bOGVARU7TOahd8Z-lYZ7voP1q2wtrhzJAMmGb8",
+		},
+	}
+
+	for _, c := range cases {
+		if actual := c.client.URL(params, "/photos/my cat.jpg"); actual != c.expected {
+			t.Errorf("Invalid URL: expected %s, but actual %s", c.expected, actual)
+		}
+	}
+}
+
+func TestSign(t *testing.T) {
+	cases := []struct {
+		version    int
+		originSlug string
+		expected   string
+	}{
+		{1, "", "1.W709VPB3K7FfGcENgqiD9GlwerC7tYOg7t3BiAV5kBE"},
+		{3, "jdv9ab8v", "jdv9ab8v-3.W709VPB3K7FfGcENgqiD9GlwerC7tYOg7t3BiAV5kBE"},
+	}
+
+	for _, c := range cases {
+		if actual := Sign(c.version, "/c!/w=300/testdata/large.jpg", "secrettest", c.originSlug); actual != c.expected {
+			t.Errorf("Invalid signature: expected %s, but actual %s", c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Package client builds and signs the image URLs of thumbnary.
+package client
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Mode is the resize mode of the image
+type Mode string
+
+const (
+	ModeScale Mode = "scale"
+	ModeCrop  Mode = "crop" // Default
+	ModeFit   Mode = "fit"
+	ModePad   Mode = "pad"
+)
+
+// Gravity is the position of the crop area or the overlay
+type Gravity int
+
+const (
+	GravityTopLeft      Gravity = 1
+	GravityTopCenter    Gravity = 2
+	GravityTopRight     Gravity = 3
+	GravityMiddleLeft   Gravity = 4
+	GravityMiddleCenter Gravity = 5 // Default
+	GravityMiddleRight  Gravity = 6
+	GravityBottomLeft   Gravity = 7
+	GravityBottomCenter Gravity = 8
+	GravityBottomRight  Gravity = 9
+	GravitySmart        Gravity = 20
+)
+
+// Metadata is the metadata mode of the output image
+type Metadata string
+
+const (
+	MetadataStrip     Metadata = "strip"
+	MetadataKeep      Metadata = "keep"
+	MetadataCopyright Metadata = "copyright"
+)
+
+// Format is the output format, the image formats or the data computed from the image
+type Format string
+
+const (
+	FormatAuto      Format = "auto" // Negotiated by Accept header
+	FormatJPEG      Format = "jpeg"
+	FormatPNG       Format = "png"
+	FormatWebP      Format = "webp"
+	FormatGIF       Format = "gif"
+	FormatTIFF      Format = "tiff"
+	FormatJSON      Format = "json"
+	FormatBlurHash  Format = "blurhash"
+	FormatThumbHash Format = "thumbhash"
+	FormatDataURI   Format = "datauri"
+	FormatStats     Format = "stats"
+)
+
+// The quality which libvips encodes with when it is not given
+const defaultQuality = 80
+
+// The data outputs have their own default quality
+var dataFormats = map[Format]bool{
+	FormatJSON:      true,
+	FormatBlurHash:  true,
+	FormatThumbHash: true,
+	FormatDataURI:   true,
+	FormatStats:     true,
+}
+
+// Params builds the params of the image URL like "w=300,h=200".
+// The params are written in the canonical form of the server, that is in the fixed order
+// and without the params which have the default value. The negative numbers are ignored.
+type Params struct {
+	noConvert      bool
+	presets        []string
+	width          int
+	height         int
+	upscale        bool
+	mode           Mode
+	gravity        Gravity
+	background     string
+	overlayURL     string
+	overlayX       int
+	overlayY       int
+	overlayGravity Gravity
+	overlayOpacity float32
+	monochrome     bool
+	noAnimation    bool
+	metadata       Metadata
+	spriteColumns  int
+	spriteGap      int
+	paletteSize    int
+	json           bool
+	format         Format
+	quality        int
+	custom         []string
+}
+
+// NewParams returns the empty params. Either the size or NoConvert must be given to build the image URL.
+func NewParams() *Params {
+	return &Params{}
+}
+
+// NoConvert serves the original image as it is, the other params are ignored.
+func (p *Params) NoConvert() *Params {
+	p.noConvert = true
+	return p
+}
+
+// Preset adds the preset of the origin. The explicit params override the params of the preset.
+func (p *Params) Preset(name string) *Params {
+	p.presets = append(p.presets, name)
+	return p
+}
+
+func (p *Params) Width(width int) *Params {
+	p.width = width
+	return p
+}
+
+func (p *Params) Height(height int) *Params {
+	p.height = height
+	return p
+}
+
+func (p *Params) Size(width, height int) *Params {
+	p.width, p.height = width, height
+	return p
+}
+
+// Upscale allows the image to be enlarged
+func (p *Params) Upscale() *Params {
+	p.upscale = true
+	return p
+}
+
+func (p *Params) Mode(mode Mode) *Params {
+	p.mode = mode
+	return p
+}
+
+func (p *Params) Gravity(gravity Gravity) *Params {
+	p.gravity = gravity
+	return p
+}
+
+// Background sets the hex color like "fff" or "ffffff" of the padding and the transparent area
+func (p *Params) Background(color string) *Params {
+	p.background = color
+	return p
+}
+
+// Overlay composes the image of the URL at the offset
+func (p *Params) Overlay(imageURL string, x, y int) *Params {
+	p.overlayURL, p.overlayX, p.overlayY = imageURL, x, y
+	return p
+}
+
+func (p *Params) OverlayGravity(gravity Gravity) *Params {
+	p.overlayGravity = gravity
+	return p
+}
+
+// OverlayOpacity sets the opacity of the overlay from 0 to 1
+func (p *Params) OverlayOpacity(opacity float32) *Params {
+	p.overlayOpacity = opacity
+	return p
+}
+
+func (p *Params) Monochrome() *Params {
+	p.monochrome = true
+	return p
+}
+
+// NoAnimation outputs the first frame of the animated image
+func (p *Params) NoAnimation() *Params {
+	p.noAnimation = true
+	return p
+}
+
+func (p *Params) Metadata(metadata Metadata) *Params {
+	p.metadata = metadata
+	return p
+}
+
+// Sprite sets the columns and the gap of the sprite sheet of /s!/ route
+func (p *Params) Sprite(columns, gap int) *Params {
+	p.spriteColumns, p.spriteGap = columns, gap
+	return p
+}
+
+// Palette sets the number of the dominant colors of the json output
+func (p *Params) Palette(size int) *Params {
+	p.paletteSize = size
+	return p
+}
+
+// JSON responds the JSON of the palette instead of the image
+func (p *Params) JSON() *Params {
+	p.json = true
+	return p
+}
+
+func (p *Params) Format(format Format) *Params {
+	p.format = format
+	return p
+}
+
+// Quality sets the quality from 1 to 100
+func (p *Params) Quality(quality int) *Params {
+	p.quality = quality
+	return p
+}
+
+// Param adds the param of the operation which is registered on the server like "sh=2".
+// The params are written after the other params in the order of the calls, so they must be added in the order of
+// the registration of the operations, with the value in the canonical form and without the default value.
+func (p *Params) Param(key, value string) *Params {
+	p.custom = append(p.custom, key+"="+value)
+	return p
+}
+
+// String returns the canonical params like "w=300,h=200", which is the same as the server serializes them.
+// It returns empty string if all of the params have the default value, or "none" by NoConvert.
+func (p *Params) String() string {
+	if p.noConvert {
+		return "none"
+	}
+
+	var params []string
+	add := func(key, value string) {
+		params = append(params, key+"="+value)
+	}
+	addInt := func(key string, value int) {
+		if value > 0 {
+			add(key, strconv.Itoa(value))
+		}
+	}
+	addBool := func(key string, value bool) {
+		if value {
+			add(key, "true")
+		}
+	}
+	addGravity := func(key string, value Gravity) {
+		if value == GravitySmart {
+			add(key, "smart")
+		} else if value != 0 && value != GravityMiddleCenter {
+			add(key, strconv.Itoa(int(value)))
+		}
+	}
+
+	for _, name := range p.presets {
+		params = append(params, "p="+name)
+	}
+	addInt("w", p.width)
+	addInt("h", p.height)
+	addBool("u", p.upscale)
+	if p.mode != "" && p.mode != ModeCrop {
+		add("m", string(p.mode))
+	}
+	addGravity("g", p.gravity)
+	if color := canonicalColor(p.background); color != "" && color != "000000" {
+		add("b", color)
+	}
+	if p.overlayURL != "" {
+		// The comma and equal sign are escaped not to be parsed as the params
+		add("l", strings.Replace(url.QueryEscape(p.overlayURL), "+", "%20", -1))
+		addInt("lx", p.overlayX)
+		addInt("ly", p.overlayY)
+		addGravity("lg", p.overlayGravity)
+		if p.overlayOpacity > 0 {
+			add("lo", strconv.FormatFloat(float64(p.overlayOpacity), 'f', -1, 32))
+		}
+	}
+	addBool("mono", p.monochrome)
+	if p.noAnimation {
+		add("anim", "false")
+	}
+	if p.metadata != "" {
+		add("meta", string(p.metadata))
+	}
+	addInt("cols", p.spriteColumns)
+	addInt("gap", p.spriteGap)
+	addInt("pal", p.paletteSize)
+	addBool("json", p.json)
+	if p.format != "" {
+		add("f", string(p.format))
+	}
+	if p.quality != defaultQuality || dataFormats[p.format] {
+		addInt("q", p.quality)
+	}
+	params = append(params, p.custom...)
+
+	return strings.Join(params, ",")
+}
+
+// canonicalColor returns the lower case 6 digits of the hex color like "fff", or empty string if it is invalid.
+func canonicalColor(color string) string {
+	var r, g, b uint8
+	switch len(color) {
+	case 3:
+		if n, _ := fmt.Sscanf(color, "%1x%1x%1x", &r, &g, &b); n != 3 {
+			return ""
+		}
+		r, g, b = r*17, g*17, b*17
+	case 6:
+		if n, _ := fmt.Sscanf(color, "%02x%02x%02x", &r, &g, &b); n != 3 {
+			return ""
+		}
+	default:
+		return ""
+	}
+	return fmt.Sprintf("%02x%02x%02x", r, g, b)
+}
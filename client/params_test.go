@@ -0,0 +1,39 @@
+package client
+
+import "testing"
+
+func TestParamsString(t *testing.T) {
+	cases := []struct {
+		params   *Params
+		expected string
+	}{
+		{NewParams(), ""},
+		{NewParams().NoConvert(), "none"},
+		{NewParams().Width(300).NoConvert(), "none"},
+		{NewParams().Quality(80), ""},
+		{NewParams().Mode(ModeCrop), ""},
+		{NewParams().Height(200).Width(300), "w=300,h=200"},
+		{NewParams().Size(300, 200).Mode(ModeCrop).Gravity(GravityMiddleCenter).Quality(80), "w=300,h=200"},
+		{NewParams().Width(300).Quality(75), "w=300,q=75"},
+		{NewParams().Width(300).Format(FormatDataURI).Quality(80), "w=300,f=datauri,q=80"},
+		{NewParams().Width(-300), ""},
+		{NewParams().Width(300).Mode(ModeFit).Gravity(GravitySmart).Upscale(), "w=300,u=true,m=fit,g=smart"},
+		{NewParams().Width(300).Background("FFF"), "w=300,b=ffffff"},
+		{NewParams().Width(300).Background("000"), "w=300"},
+		{NewParams().Width(300).Background("red"), "w=300"},
+		{NewParams().Width(300).Metadata(MetadataCopyright).NoAnimation(), "w=300,anim=false,meta=copyright"},
+		{
+			NewParams().Width(300).Overlay("https://example.com/logo, 1.png", 10, 0).OverlayGravity(GravityBottomRight).OverlayOpacity(0.5),
+			"w=300,l=https%3A%2F%2Fexample.com%2Flogo%2C%201.png,lx=10,lg=9,lo=0.5",
+		},
+		{NewParams().Width(100).Monochrome().Sprite(4, 2).Palette(8).JSON().Format(FormatWebP).Quality(60), "w=100,mono=true,cols=4,gap=2,pal=8,json=true,f=webp,q=60"},
+		{NewParams().Preset("card").Width(400), "p=card,w=400"},
+		{NewParams().Param("sh", "2").Width(300).Quality(60), "w=300,q=60,sh=2"},
+	}
+
+	for _, c := range cases {
+		if actual := c.params.String(); actual != c.expected {
+			t.Errorf("Invalid params: expected %s, but actual %s", c.expected, actual)
+		}
+	}
+}
@@ -14,7 +14,9 @@ import (
 	"time"
 
 	"github.com/spf13/viper"
+	"github.com/tsu1980/thumbnary"
 	"github.com/tsu1980/thumbnary/config"
+	"github.com/tsu1980/thumbnary/server"
 	bimg "gopkg.in/h2non/bimg.v1"
 )
 
@@ -26,6 +28,9 @@ var (
 	aHelpl      = flag.Bool("help", false, "Show help")
 )
 
+// sRGB profile installed by colord-data package
+const defaultOutputICC = "/usr/share/color/icc/colord/sRGB.icc"
+
 const usage = `thumbnary %s
 
 Usage:
@@ -41,7 +46,7 @@ Options:
 
 func main() {
 	flag.Usage = func() {
-		fmt.Fprint(os.Stderr, fmt.Sprintf(usage, Version))
+		fmt.Fprint(os.Stderr, fmt.Sprintf(usage, thumbnary.Version))
 	}
 	flag.Parse()
 
@@ -62,6 +67,13 @@ func main() {
 	viper.SetDefault("Server.OriginSlugDetectPathPattern", "")
 	viper.SetDefault("Server.MaxAllowedSize", 0)
 	viper.SetDefault("Server.MaxOutputMP", 0)
+	viper.SetDefault("Server.MaxPipelineSteps", server.DefaultMaxPipelineSteps)
+	viper.SetDefault("Server.MaxPipelineMP", server.DefaultMaxPipelineMP)
+	viper.SetDefault("Server.StrictParams", false)
+	viper.SetDefault("Server.CanonicalURLMode", "")
+	viper.SetDefault("Server.PublicBaseURL", "")
+	viper.SetDefault("Server.ImgixParams", false)
+	viper.SetDefault("Server.OutputICC", defaultOutputICC)
 	viper.SetDefault("Server.HTTPCacheTTL", -1)
 	viper.SetDefault("Server.ReadTimeout", 60)
 	viper.SetDefault("Server.WriteTimeout", 60)
@@ -78,6 +90,7 @@ func main() {
 	viper.SetDefault("Database.DBTlsClientCertPem", "")
 	viper.SetDefault("Database.DBTlsClientKeyPem", "")
 	viper.SetDefault("Database.OriginTableName", "origin")
+	viper.SetDefault("Database.PresetTableName", "origin_preset")
 
 	if *aConfigFile != "" {
 		viper.SetConfigFile(*aConfigFile)
@@ -98,7 +111,7 @@ func main() {
 
 	port := getPort(config.Server.Port)
 
-	opts := ServerOptions{
+	opts := server.ServerOptions{
 		Port:                        port,
 		Address:                     config.Server.Addr,
 		CORS:                        config.Server.Cors,
@@ -116,18 +129,28 @@ func main() {
 		DBTlsClientCertPem:          config.Database.DBTlsClientCertPem,
 		DBTlsClientKeyPem:           config.Database.DBTlsClientKeyPem,
 		OriginTableName:             config.Database.OriginTableName,
+		PresetTableName:             config.Database.PresetTableName,
 		APIKey:                      config.Server.Key,
 		Concurrency:                 config.Server.Concurrency,
 		Burst:                       config.Server.Burst,
 		CertFile:                    config.Server.CertFile,
 		KeyFile:                     config.Server.KeyFile,
 		Placeholder:                 config.Server.Placeholder,
+		OutputICC:                   config.Server.OutputICC,
 		HTTPCacheTTL:                config.Server.HTTPCacheTTL,
 		HTTPReadTimeout:             config.Server.ReadTimeout,
 		HTTPWriteTimeout:            config.Server.WriteTimeout,
 		Authorization:               config.Server.Authorization,
 		MaxAllowedSize:              config.Server.MaxAllowedSize,
 		MaxOutputMP:                 config.Server.MaxOutputMP,
+		MaxPipelineSteps:            config.Server.MaxPipelineSteps,
+		MaxPipelineMP:               config.Server.MaxPipelineMP,
+		StrictParams:                config.Server.StrictParams,
+		CanonicalURLMode:            config.Server.CanonicalURLMode,
+		PublicBaseURL:               config.Server.PublicBaseURL,
+		ImgixParams:                 config.Server.ImgixParams,
+		URLSignatureKey:             config.Server.URLSignatureKey,
+		URLSignatureSalt:            config.Server.URLSignatureSalt,
 	}
 
 	// Create a memory release goroutine
@@ -140,8 +163,38 @@ func main() {
 		checkHttpCacheTtl(config.Server.HTTPCacheTTL)
 	}
 
+	// Check output ICC profile, if present
+	if config.Server.OutputICC != "" {
+		if _, err := os.Stat(config.Server.OutputICC); err != nil {
+			if config.Server.OutputICC != defaultOutputICC {
+				exitWithError("cannot read output ICC profile: %s", err)
+			}
+			log.Printf("Default output ICC profile not found, colour profile conversion disabled (path=%s)", defaultOutputICC)
+			opts.OutputICC = ""
+		}
+	}
+
+	// Validate canonical URL mode, if present
+	switch config.Server.CanonicalURLMode {
+	case server.CanonicalURLModeNone, server.CanonicalURLModeRedirect, server.CanonicalURLModeLink:
+	default:
+		exitWithError("Canonical URL mode is not supported: %s", config.Server.CanonicalURLMode)
+	}
+
+	// Validate public base URL, if present
+	if config.Server.PublicBaseURL != "" {
+		if u, err := url.Parse(config.Server.PublicBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			exitWithError("Public base URL must be the absolute URL like https://img.example.com: %s", config.Server.PublicBaseURL)
+		}
+	}
+
+	// Validate imgproxy URL signature key and salt, if present
+	if _, err := server.CalcImgproxySignatureValue("", config.Server.URLSignatureKey, config.Server.URLSignatureSalt); err != nil {
+		exitWithError(err.Error())
+	}
+
 	// Parse origin slug detect methods
-	err = parseOriginSlugDetectMethods(&opts, config.Server.OriginSlugDetectMethods)
+	err = server.ParseOriginSlugDetectMethods(&opts, config.Server.OriginSlugDetectMethods)
 	if err != nil {
 		exitWithError(err.Error())
 	}
@@ -159,29 +212,14 @@ func main() {
 		}
 
 		opts.PlaceholderImage = buf
-	} else if config.Server.EnablePlaceholder {
-		// Expose default placeholder
-		opts.PlaceholderImage = placeholder
 	}
 
 	debug("thumbnary server listening on port :%d", opts.Port)
 
-	// Load image source providers
-	LoadSources(opts)
-
-	// Create and open origin repository
-	opts.OriginRepos, err = NewOriginRepository(OriginRepositoryTypeMySQL, opts)
-	if err != nil {
-		exitWithError("failed to create origin repository: %s", err.Error())
-	}
-
-	err = opts.OriginRepos.Open()
-	if err != nil {
-		exitWithError("failed to open origin repository: %s", err.Error())
-	}
-
 	// Start the server
-	Server(opts)
+	if err := server.Server(opts); err != nil {
+		exitWithError("cannot start the server: %s", err)
+	}
 }
 
 func getPort(port int) int {
@@ -200,7 +238,7 @@ func showUsage() {
 }
 
 func showVersion() {
-	fmt.Println(Version)
+	fmt.Println(thumbnary.Version)
 	os.Exit(0)
 }
 
@@ -229,38 +267,6 @@ func parseOrigins(origins string) []*url.URL {
 	return urls
 }
 
-func parseOriginSlugDetectMethods(o *ServerOptions, input string) error {
-	methods := make([]OriginSlugDetectMethod, 0, 5)
-	for _, val := range strings.Split(input, ",") {
-		val = strings.ToLower(strings.TrimSpace(val))
-		_, ok := originSlugDetectMethodMap[(OriginSlugDetectMethod)(val)]
-		if !ok {
-			return fmt.Errorf("Unknown origin slug detect method(%s)", val)
-		}
-		method := (OriginSlugDetectMethod)(val)
-
-		switch method {
-		case OriginSlugDetectMethod_Host:
-			if o.OriginSlugDetectHostPattern == "" {
-				return fmt.Errorf("Missing required params: origin slug detect host pattern")
-			}
-		case OriginSlugDetectMethod_Path:
-			if o.OriginSlugDetectPathPattern == "" {
-				return fmt.Errorf("Missing required params: origin slug detect path pattern")
-			}
-		}
-
-		methods = append(methods, method)
-	}
-
-	if len(methods) == 0 {
-		return fmt.Errorf("origin slug detect methods empty")
-	}
-
-	o.OriginSlugDetectMethods = methods
-	return nil
-}
-
 func memoryRelease(interval int) {
 	ticker := time.NewTicker(time.Duration(interval) * time.Second)
 	go func() {
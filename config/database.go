@@ -30,4 +30,7 @@ type DatabaseConfiguration struct {
 
 	// The database origin table name
 	OriginTableName string
+
+	// The database origin preset table name
+	PresetTableName string
 }
@@ -34,6 +34,31 @@ type ServerConfiguration struct {
 	// The maximum area of output image (in Megapixel)
 	MaxOutputMP int
 
+	// The maximum number of steps of an operation pipeline
+	MaxPipelineSteps int
+
+	// The maximum area of intermediate images of an operation pipeline (in Megapixel)
+	MaxPipelineMP int
+
+	// Reject unknown, malformed or out of range params by default
+	StrictParams bool
+
+	// Normalize the params of the image URL: "redirect" (301 redirect to the canonical URL),
+	// "link" (expose the canonical URL by Link header) or empty to disable
+	CanonicalURLMode string
+
+	// The public base URL of the server like "https://img.example.com", which the absolute URLs are built with.
+	// The URLs are relative to the host of the request if empty, since the scheme is unknown behind the TLS termination
+	PublicBaseURL string
+
+	// Read the imgix style params from the query string of the path without route marker
+	// like /path/to/image.jpg?w=300&fit=crop
+	ImgixParams bool
+
+	// Hex encoded key and salt of the imgproxy compatible URL signature
+	URLSignatureKey  string
+	URLSignatureSalt string
+
 	// Define API key for authorization
 	Key string
 
@@ -52,6 +77,10 @@ type ServerConfiguration struct {
 	// Recommended minimum image size is: 1200x1200
 	Placeholder string
 
+	// Absolute path to the ICC profile which embedded profiles are transformed to.
+	// Set empty to disable colour profile conversion
+	OutputICC string
+
 	// The TTL in seconds
 	HTTPCacheTTL int
 
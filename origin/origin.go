@@ -0,0 +1,77 @@
+package origin
+
+import "fmt"
+
+type OriginRepositoryType string
+type OriginSlug string
+
+// SourceType is the type of the image source which the images of the origin are fetched from.
+type SourceType int
+
+const SourceTypeHTTP SourceType = 0
+
+type Origin struct {
+	Slug                     OriginSlug
+	SourceType               SourceType
+	Scheme                   string
+	Host                     string
+	PathPrefix               string
+	URLSignatureEnabled      bool
+	URLSignatureKey          string
+	URLSignatureKey_Previous string
+	URLSignatureKey_Version  int
+	AllowExternalHTTPSource  bool
+	MaxAnimationFrames       int
+	MaxAnimationMP           int
+	OutputICC                string
+	MetadataMode             string
+	ExposeGPSMetadata        bool
+	PresetsOnly              bool
+	StrictParams             string            // "strict", "lenient" or empty for the server default
+	Presets                  map[string]string // Preset name to params (e.g. "card": "w=300,h=200,m=crop")
+}
+
+type OriginRepository interface {
+	Open() error
+	Close()
+	Get(originSlug OriginSlug) (*Origin, error)
+}
+
+// Options configures the connections and the tables of the origin repository.
+type Options struct {
+	RedisURL            string
+	RedisChannelPrefix  string
+	DBDriverName        string
+	DBDataSourceName    string
+	DBTlsKeyName        string
+	DBTlsServerHostName string
+	DBTlsServerCAPem    string
+	DBTlsClientCertPem  string
+	DBTlsClientKeyPem   string
+	OriginTableName     string
+	PresetTableName     string
+}
+
+func NewOriginRepository(ort OriginRepositoryType, o Options) (OriginRepository, error) {
+	switch ort {
+	case OriginRepositoryTypeMySQL:
+		return &MySQLOriginRepository{Options: o}, nil
+	default:
+		return nil, fmt.Errorf("Unknown repository type: (type=%s)", ort)
+	}
+}
+
+var changeFuncs []func(OriginSlug)
+
+// OnChange registers the function which is called when the origin is changed, so that
+// the data cached by the origin can be expired. The slug is empty if all of the origins are changed.
+// It must be called before the repository is opened.
+func OnChange(fn func(originSlug OriginSlug)) {
+	changeFuncs = append(changeFuncs, fn)
+}
+
+func notifyChange(originSlug OriginSlug) {
+	for _, fn := range changeFuncs {
+		fn(originSlug)
+	}
+}
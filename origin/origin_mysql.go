@@ -1,4 +1,4 @@
-package main
+package origin
 
 import (
 	"crypto/tls"
@@ -18,10 +18,10 @@ import (
 const OriginRepositoryTypeMySQL OriginRepositoryType = "mysql"
 
 type MySQLOriginRepository struct {
-	Options ServerOptions
+	Options Options
 }
 
-func NewMySQLOriginRepository(o ServerOptions) OriginRepository {
+func NewMySQLOriginRepository(o Options) OriginRepository {
 	return &MySQLOriginRepository{Options: o}
 }
 
@@ -46,7 +46,7 @@ var db *sql.DB
 var originCache *lru.ARCCache
 
 // OpenDB open database connection
-func OpenDB(o ServerOptions) error {
+func OpenDB(o Options) error {
 	var err = (error)(nil)
 
 	if o.DBTlsKeyName != "" && o.DBTlsServerHostName != "" && o.DBTlsServerCAPem != "" && o.DBTlsClientCertPem != "" && o.DBTlsClientKeyPem != "" {
@@ -101,7 +101,7 @@ func OpenDB(o ServerOptions) error {
 
 var bo *backoff.ExponentialBackOff
 
-func StartRedis(o ServerOptions) error {
+func StartRedis(o Options) error {
 	// Start a goroutine to receive notifications.
 	go func() {
 		operation := func() error {
@@ -120,7 +120,7 @@ func StartRedis(o ServerOptions) error {
 	return nil
 }
 
-func ListenNotifications(o ServerOptions) error {
+func ListenNotifications(o Options) error {
 	conn, err := redis.DialURL(o.RedisURL)
 	if err != nil {
 		log.Printf("failed to connect to redis (url=%s)\n", o.RedisURL)
@@ -168,10 +168,12 @@ func onMessage(msg redis.Message) {
 func onOriginUpdated(originSlug OriginSlug) {
 	log.Printf("Origin[%s] updated\n", originSlug)
 	originCache.Remove(originSlug)
+	notifyChange(originSlug)
 }
 
 func clearOriginsCacheAll() {
 	originCache.Purge()
+	notifyChange("")
 	log.Printf("Origins cache cleared")
 }
 
@@ -184,7 +186,7 @@ func (repo *MySQLOriginRepository) Get(originSlug OriginSlug) (*Origin, error) {
 	}
 
 	origin := &Origin{}
-	sql := fmt.Sprintf("SELECT Slug, SourceType, Scheme, Host, PathPrefix, URLSignatureEnabled, URLSignatureKey, URLSignatureKey_Previous, URLSignatureKey_Version, AllowExternalHTTPSource FROM %s WHERE Slug = ?",
+	sql := fmt.Sprintf("SELECT Slug, SourceType, Scheme, Host, PathPrefix, URLSignatureEnabled, URLSignatureKey, URLSignatureKey_Previous, URLSignatureKey_Version, AllowExternalHTTPSource, MaxAnimationFrames, MaxAnimationMP, OutputICC, MetadataMode, ExposeGPSMetadata, PresetsOnly, StrictParams FROM %s WHERE Slug = ?",
 		repo.Options.OriginTableName)
 	err := db.QueryRow(sql, (string)(originSlug)).Scan(
 		&origin.Slug,
@@ -197,11 +199,43 @@ func (repo *MySQLOriginRepository) Get(originSlug OriginSlug) (*Origin, error) {
 		&origin.URLSignatureKey_Previous,
 		&origin.URLSignatureKey_Version,
 		&origin.AllowExternalHTTPSource,
+		&origin.MaxAnimationFrames,
+		&origin.MaxAnimationMP,
+		&origin.OutputICC,
+		&origin.MetadataMode,
+		&origin.ExposeGPSMetadata,
+		&origin.PresetsOnly,
+		&origin.StrictParams,
 	)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot select origin slug: (originSlug=%s) (err=%v)", originSlug, err)
 	}
+
+	origin.Presets, err = repo.getPresets(originSlug)
+	if err != nil {
+		return nil, err
+	}
 	log.Printf("Origin[%s] fetched (origin=%+v)\n", originSlug, origin)
 	originCache.Add(originSlug, origin)
 	return origin, nil
 }
+
+// getPresets selects the presets of the origin, they are cached with the origin.
+func (repo *MySQLOriginRepository) getPresets(originSlug OriginSlug) (map[string]string, error) {
+	sql := fmt.Sprintf("SELECT Name, Params FROM %s WHERE OriginSlug = ?", repo.Options.PresetTableName)
+	rows, err := db.Query(sql, (string)(originSlug))
+	if err != nil {
+		return nil, fmt.Errorf("Cannot select origin presets: (originSlug=%s) (err=%v)", originSlug, err)
+	}
+	defer rows.Close()
+
+	presets := make(map[string]string)
+	for rows.Next() {
+		var name, params string
+		if err := rows.Scan(&name, &params); err != nil {
+			return nil, fmt.Errorf("Cannot scan origin preset: (originSlug=%s) (err=%v)", originSlug, err)
+		}
+		presets[name] = params
+	}
+	return presets, rows.Err()
+}
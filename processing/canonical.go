@@ -0,0 +1,98 @@
+package processing
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// CanonicalParams serializes the options back to the params string like "w=300,h=200".
+// The params are written by the operations in the order of the registration and the params which have
+// the default value are omitted, so the params which yield identical output have the same canonical form.
+func CanonicalParams(opts ImageOptions) string {
+	if opts.NoConvert {
+		return "none"
+	}
+
+	var params []string
+	for _, op := range operations {
+		params = append(params, op.Canonical(opts)...)
+	}
+	return strings.Join(params, ",")
+}
+
+// canonicalParam returns the param like "w=300" of the parsed value of the kind,
+// or empty string if the value is the default of the kind.
+func canonicalParam(key, kind string, value interface{}) string {
+	if reflect.DeepEqual(value, parseParam("", kind)) {
+		return ""
+	}
+	return key + "=" + canonicalValue(kind, value)
+}
+
+// nonEmptyParams returns the params without the empty ones, which have the default value.
+func nonEmptyParams(params ...string) []string {
+	var out []string
+	for _, param := range params {
+		if param != "" {
+			out = append(out, param)
+		}
+	}
+	return out
+}
+
+// canonicalValue serializes the parsed value of the param kind.
+func canonicalValue(kind string, value interface{}) string {
+	switch v := value.(type) {
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []uint8:
+		if kind == "hexcolor" && len(v) == 3 {
+			return fmt.Sprintf("%02x%02x%02x", v[0], v[1], v[2])
+		}
+	case Gravity9:
+		return canonicalName(gravity9Names, v)
+	case ResizeMode:
+		return canonicalName(resizeModeNames, v)
+	case MetadataMode:
+		return canonicalName(metadataModeNames, v)
+	}
+	return fmt.Sprint(value)
+}
+
+// canonicalName returns the shortest name of the value, the first one in alphabetical order on a tie.
+func canonicalName(names interface{}, value interface{}) string {
+	var name string
+	pick := func(n string) {
+		if name == "" || len(n) < len(name) || (len(n) == len(name) && n < name) {
+			name = n
+		}
+	}
+
+	switch m := names.(type) {
+	case map[string]ResizeMode:
+		for n, v := range m {
+			if v == value {
+				pick(n)
+			}
+		}
+	case map[string]Gravity9:
+		for n, v := range m {
+			if v == value {
+				pick(n)
+			}
+		}
+	case map[string]MetadataMode:
+		for n, v := range m {
+			if v == value {
+				pick(n)
+			}
+		}
+	}
+	return name
+}
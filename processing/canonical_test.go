@@ -0,0 +1,51 @@
+package processing
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCanonicalParams(t *testing.T) {
+	cases := []struct {
+		params   string
+		expected string
+	}{
+		{"w=300,h=200", "w=300,h=200"},
+		{"h=200,w=300", "w=300,h=200"},
+		{"w=300,h=200,q=80", "w=300,h=200"},
+		{"w=300,h=200,q=75", "w=300,h=200,q=75"},
+		{"w=300,f=datauri,q=80", "w=300,f=datauri,q=80"},
+		{"w=299.6,h=-200", "w=300,h=200"},
+		{"w=300,m=crop,g=5,anim=true,u=false", "w=300"},
+		{"w=300,m=FIT,g=smart", "w=300,m=fit,g=smart"},
+		{"w=300,b=fff", "w=300,b=ffffff"},
+		{"w=300,b=000", "w=300"},
+		{"w=300,meta=copyright-only,anim=false", "w=300,anim=false,meta=copyright"},
+		{"w=300,l=https%3A%2F%2Fexample.com%2Flogo.png,lo=0.5,lg=9", "w=300,l=https%3A%2F%2Fexample.com%2Flogo.png,lg=9,lo=0.5"},
+		{"f=json", "f=json"},
+		{"none", "none"},
+		{"", ""},
+	}
+
+	for _, c := range cases {
+		if actual := CanonicalParams(ReadParams(c.params, nil)); actual != c.expected {
+			t.Errorf("Invalid canonical params of %s: expected %s, but actual %s", c.params, c.expected, actual)
+		}
+	}
+}
+
+func TestCanonicalParamsRoundTrip(t *testing.T) {
+	params := []string{
+		"w=300,h=200,u=true,m=pad,g=smart,b=102030",
+		"w=64,l=logo.png,lx=10,ly=20,lg=3,lo=0.25",
+		"w=100,mono=true,anim=false,meta=keep,cols=4,gap=2,pal=8,json=true,f=webp,q=60",
+	}
+
+	for _, p := range params {
+		opts := ReadParams(p, nil)
+		if actual := ReadParams(CanonicalParams(opts), nil); !reflect.DeepEqual(actual, opts) {
+			t.Errorf("Canonical params of %s are not equivalent: expected %+v, but actual %+v", p, opts, actual)
+		}
+	}
+
+}
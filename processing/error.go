@@ -0,0 +1,66 @@
+package processing
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+const (
+	Unavailable uint8 = iota
+	BadRequest
+	NotAllowed
+	Unsupported
+	Unauthorized
+	InternalError
+	NotFound
+	NotImplemented
+	Forbidden
+)
+
+type Error struct {
+	Message string `json:"message,omitempty"`
+	Code    uint8  `json:"code"`
+}
+
+func (e Error) JSON() []byte {
+	buf, _ := json.Marshal(e)
+	return buf
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+func (e Error) HTTPCode() int {
+	if e.Code == BadRequest {
+		return http.StatusBadRequest
+	}
+	if e.Code == NotAllowed {
+		return http.StatusMethodNotAllowed
+	}
+	if e.Code == Unsupported {
+		return http.StatusUnsupportedMediaType
+	}
+	if e.Code == InternalError {
+		return http.StatusInternalServerError
+	}
+	if e.Code == Unauthorized {
+		return http.StatusUnauthorized
+	}
+	if e.Code == NotFound {
+		return http.StatusNotFound
+	}
+	if e.Code == NotImplemented {
+		return http.StatusNotImplemented
+	}
+	if e.Code == Forbidden {
+		return http.StatusForbidden
+	}
+	return http.StatusServiceUnavailable
+}
+
+func NewError(err string, code uint8) Error {
+	err = strings.Replace(err, "\n", "", -1)
+	return Error{err, code}
+}
@@ -0,0 +1,320 @@
+package processing
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+)
+
+const exifHeaderJPEG = "Exif\x00\x00"
+
+// EXIF tag IDs
+const (
+	EXIFTagMake             uint16 = 0x010F
+	EXIFTagModel            uint16 = 0x0110
+	EXIFTagOrientation      uint16 = 0x0112
+	EXIFTagSoftware         uint16 = 0x0131
+	EXIFTagDateTime         uint16 = 0x0132
+	EXIFTagArtist           uint16 = 0x013B
+	EXIFTagCopyright        uint16 = 0x8298
+	EXIFTagExposureTime     uint16 = 0x829A
+	EXIFTagFNumber          uint16 = 0x829D
+	EXIFTagExifIFD          uint16 = 0x8769
+	EXIFTagGPSIFD           uint16 = 0x8825
+	EXIFTagISOSpeed         uint16 = 0x8827
+	EXIFTagDateTimeOriginal uint16 = 0x9003
+	EXIFTagFocalLength      uint16 = 0x920A
+	EXIFTagLensModel        uint16 = 0xA434
+)
+
+// EXIF GPS tag IDs
+const (
+	EXIFTagGPSLatitudeRef  uint16 = 0x0001
+	EXIFTagGPSLatitude     uint16 = 0x0002
+	EXIFTagGPSLongitudeRef uint16 = 0x0003
+	EXIFTagGPSLongitude    uint16 = 0x0004
+	EXIFTagGPSAltitudeRef  uint16 = 0x0005
+	EXIFTagGPSAltitude     uint16 = 0x0006
+)
+
+// EXIF field types
+const (
+	exifTypeByte      uint16 = 1
+	exifTypeASCII     uint16 = 2
+	exifTypeShort     uint16 = 3
+	exifTypeLong      uint16 = 4
+	exifTypeRational  uint16 = 5
+	exifTypeUndefined uint16 = 7
+	exifTypeSLong     uint16 = 9
+	exifTypeSRational uint16 = 10
+)
+
+var exifTypeSize = map[uint16]int{
+	exifTypeByte:      1,
+	exifTypeASCII:     1,
+	exifTypeShort:     2,
+	exifTypeLong:      4,
+	exifTypeRational:  8,
+	exifTypeUndefined: 1,
+	exifTypeSLong:     4,
+	exifTypeSRational: 8,
+}
+
+var ErrInvalidEXIF = errors.New("Invalid EXIF data")
+
+// EXIFEntry represents a field of EXIF IFD with its raw value
+type EXIFEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Value []byte
+	order binary.ByteOrder
+}
+
+// EXIFIFD represents the fields of an IFD by tag ID
+type EXIFIFD map[uint16]EXIFEntry
+
+// EXIF represents the parsed EXIF (TIFF structure) data.
+// Fields of IFD0 and Exif sub IFD are merged into Tags, GPS sub IFD fields are stored in GPSTags.
+type EXIF struct {
+	ByteOrder binary.ByteOrder
+	Tags      EXIFIFD
+	GPSTags   EXIFIFD
+}
+
+// ExtractEXIF returns the raw EXIF (TIFF structure) data of JPEG, PNG or WebP image buffer.
+// It returns nil if the image has no EXIF data.
+func ExtractEXIF(buf []byte) []byte {
+	var exif []byte
+	switch {
+	case isJPEGBuffer(buf):
+		eachJPEGSegment(buf, func(marker byte, offset int, data []byte) bool {
+			if marker == jpegMarkerAPP1 && strings.HasPrefix(string(data), exifHeaderJPEG) {
+				exif = data[len(exifHeaderJPEG):]
+				return false
+			}
+			return true
+		})
+	case isPNGBuffer(buf):
+		eachPNGChunk(buf, func(kind string, offset int, data []byte) bool {
+			if kind == "eXIf" {
+				exif = data
+				return false
+			}
+			return kind != "IDAT"
+		})
+	case isWebPBuffer(buf):
+		eachWebPChunk(buf, func(kind string, data []byte) bool {
+			if kind == "EXIF" {
+				exif = data
+				// Some encoders keep JPEG APP1 header
+				if strings.HasPrefix(string(exif), exifHeaderJPEG) {
+					exif = exif[len(exifHeaderJPEG):]
+				}
+				return false
+			}
+			return true
+		})
+	}
+	return exif
+}
+
+// ParseEXIF parses the raw EXIF (TIFF structure) data.
+func ParseEXIF(tiff []byte) (*EXIF, error) {
+	order, ifdOffset, err := readTIFFHeader(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	exif := &EXIF{
+		ByteOrder: order,
+		Tags:      EXIFIFD{},
+		GPSTags:   EXIFIFD{},
+	}
+
+	err = readEXIFIFD(tiff, order, ifdOffset, exif.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := exif.Tags[EXIFTagExifIFD]; ok {
+		// Broken sub IFD is ignored
+		readEXIFIFD(tiff, order, e.Int(0), exif.Tags)
+	}
+	if e, ok := exif.Tags[EXIFTagGPSIFD]; ok {
+		readEXIFIFD(tiff, order, e.Int(0), exif.GPSTags)
+	}
+
+	return exif, nil
+}
+
+func readTIFFHeader(tiff []byte) (binary.ByteOrder, int, error) {
+	if len(tiff) < 8 {
+		return nil, 0, ErrInvalidEXIF
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, ErrInvalidEXIF
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return nil, 0, ErrInvalidEXIF
+	}
+
+	return order, int(order.Uint32(tiff[4:])), nil
+}
+
+func readEXIFIFD(tiff []byte, order binary.ByteOrder, offset int, ifd EXIFIFD) error {
+	if offset < 8 || offset+2 > len(tiff) {
+		return ErrInvalidEXIF
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(tiff) {
+			return ErrInvalidEXIF
+		}
+
+		entry := EXIFEntry{
+			Tag:   order.Uint16(tiff[pos:]),
+			Type:  order.Uint16(tiff[pos+2:]),
+			Count: order.Uint32(tiff[pos+4:]),
+			order: order,
+		}
+		typeSize, ok := exifTypeSize[entry.Type]
+		if !ok {
+			continue
+		}
+
+		size := typeSize * int(entry.Count)
+		if size <= 4 {
+			entry.Value = tiff[pos+8 : pos+8+size]
+		} else {
+			valueOffset := int(order.Uint32(tiff[pos+8:]))
+			if valueOffset < 0 || size < 0 || valueOffset+size > len(tiff) {
+				continue
+			}
+			entry.Value = tiff[valueOffset : valueOffset+size]
+		}
+		ifd[entry.Tag] = entry
+	}
+
+	return nil
+}
+
+// String returns the ASCII value of the field
+func (e EXIFEntry) String() string {
+	if e.Type != exifTypeASCII {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimRight(string(e.Value), "\x00"))
+}
+
+// Int returns the i-th integer value of the field
+func (e EXIFEntry) Int(i int) int {
+	size := exifTypeSize[e.Type]
+	if i < 0 || size == 0 || (i+1)*size > len(e.Value) {
+		return 0
+	}
+
+	switch e.Type {
+	case exifTypeByte, exifTypeUndefined:
+		return int(e.Value[i])
+	case exifTypeShort:
+		return int(e.order.Uint16(e.Value[i*2:]))
+	case exifTypeLong:
+		return int(e.order.Uint32(e.Value[i*4:]))
+	case exifTypeSLong:
+		return int(int32(e.order.Uint32(e.Value[i*4:])))
+	}
+	return 0
+}
+
+// Float returns the i-th rational value of the field
+func (e EXIFEntry) Float(i int) float64 {
+	if e.Type != exifTypeRational && e.Type != exifTypeSRational {
+		return float64(e.Int(i))
+	}
+	if i < 0 || (i+1)*8 > len(e.Value) {
+		return 0
+	}
+
+	var num, den float64
+	if e.Type == exifTypeRational {
+		num = float64(e.order.Uint32(e.Value[i*8:]))
+		den = float64(e.order.Uint32(e.Value[i*8+4:]))
+	} else {
+		num = float64(int32(e.order.Uint32(e.Value[i*8:])))
+		den = float64(int32(e.order.Uint32(e.Value[i*8+4:])))
+	}
+	if den == 0 {
+		return 0
+	}
+	return num / den
+}
+
+// BuildEXIF builds a raw EXIF (TIFF structure) data which has only IFD0 with the given ASCII fields.
+func BuildEXIF(fields map[uint16]string) []byte {
+	order := binary.BigEndian
+
+	tags := make([]uint16, 0, len(fields))
+	for tag := range fields {
+		tags = append(tags, tag)
+	}
+	// Entries must be sorted in ascending order by tag ID
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	ifdSize := 2 + len(tags)*12 + 4
+	tiff := make([]byte, 8+ifdSize)
+	copy(tiff, "MM")
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], 8)
+	order.PutUint16(tiff[8:], uint16(len(tags)))
+
+	for i, tag := range tags {
+		value := append([]byte(fields[tag]), 0)
+		pos := 10 + i*12
+		order.PutUint16(tiff[pos:], tag)
+		order.PutUint16(tiff[pos+2:], exifTypeASCII)
+		order.PutUint32(tiff[pos+4:], uint32(len(value)))
+		if len(value) <= 4 {
+			copy(tiff[pos+8:], value)
+		} else {
+			order.PutUint32(tiff[pos+8:], uint32(len(tiff)))
+			tiff = append(tiff, value...)
+			if len(tiff)%2 == 1 {
+				tiff = append(tiff, 0)
+			}
+		}
+	}
+
+	return tiff
+}
+
+// setEXIFOrientation overwrites the orientation field of IFD0 in place.
+func setEXIFOrientation(tiff []byte, orientation int) bool {
+	order, offset, err := readTIFFHeader(tiff)
+	if err != nil || offset+2 > len(tiff) {
+		return false
+	}
+
+	count := int(order.Uint16(tiff[offset:]))
+	for i := 0; i < count; i++ {
+		pos := offset + 2 + i*12
+		if pos+12 > len(tiff) {
+			return false
+		}
+		if order.Uint16(tiff[pos:]) == EXIFTagOrientation && order.Uint16(tiff[pos+2:]) == exifTypeShort {
+			order.PutUint16(tiff[pos+8:], uint16(orientation))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package processing
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// newTestEXIF builds a little endian EXIF data which has orientation, artist, copyright and GPS fields.
+func newTestEXIF(orientation int) []byte {
+	order := binary.LittleEndian
+	artist := "Taro Yamada\x00"
+	copyright := "(c) Example Inc.\x00"
+
+	// Header(8) + IFD0(2+4*12+4) + GPS IFD(2+2*12+4) + values
+	ifd0 := 8
+	gpsIFD := ifd0 + 2 + 4*12 + 4
+	values := gpsIFD + 2 + 2*12 + 4
+	tiff := make([]byte, values)
+	copy(tiff, "II")
+	order.PutUint16(tiff[2:], 42)
+	order.PutUint32(tiff[4:], uint32(ifd0))
+
+	entry := func(pos int, tag, kind uint16, count uint32, value []byte) {
+		order.PutUint16(tiff[pos:], tag)
+		order.PutUint16(tiff[pos+2:], kind)
+		order.PutUint32(tiff[pos+4:], count)
+		if len(value) <= 4 {
+			copy(tiff[pos+8:], value)
+		} else {
+			order.PutUint32(tiff[pos+8:], uint32(len(tiff)))
+			tiff = append(tiff, value...)
+		}
+	}
+	short := make([]byte, 2)
+	order.PutUint16(short, uint16(orientation))
+	long := make([]byte, 4)
+	order.PutUint32(long, uint32(gpsIFD))
+	latitude := make([]byte, 24)
+	for i, v := range []uint32{35, 1, 39, 1, 2934, 100} {
+		order.PutUint32(latitude[i*4:], v)
+	}
+
+	order.PutUint16(tiff[ifd0:], 4)
+	entry(ifd0+2, EXIFTagOrientation, exifTypeShort, 1, short)
+	entry(ifd0+2+12, EXIFTagArtist, exifTypeASCII, uint32(len(artist)), []byte(artist))
+	entry(ifd0+2+24, EXIFTagCopyright, exifTypeASCII, uint32(len(copyright)), []byte(copyright))
+	entry(ifd0+2+36, EXIFTagGPSIFD, exifTypeLong, 1, long)
+
+	order.PutUint16(tiff[gpsIFD:], 2)
+	entry(gpsIFD+2, 0x0001, exifTypeASCII, 2, []byte("N\x00"))
+	entry(gpsIFD+2+12, 0x0002, exifTypeRational, 3, latitude)
+
+	return tiff
+}
+
+func TestParseEXIF(t *testing.T) {
+	exif, err := ParseEXIF(newTestEXIF(6))
+	if err != nil {
+		t.Fatalf("Cannot parse EXIF: %s", err)
+	}
+
+	if v := exif.Tags[EXIFTagOrientation].Int(0); v != 6 {
+		t.Errorf("Invalid orientation: %d != 6", v)
+	}
+	if v := exif.Tags[EXIFTagArtist].String(); v != "Taro Yamada" {
+		t.Errorf("Invalid artist: %s", v)
+	}
+	if v := exif.Tags[EXIFTagCopyright].String(); v != "(c) Example Inc." {
+		t.Errorf("Invalid copyright: %s", v)
+	}
+	if v := exif.GPSTags[0x0001].String(); v != "N" {
+		t.Errorf("Invalid GPS latitude ref: %s", v)
+	}
+	if v := exif.GPSTags[0x0002].Float(2); math.Abs(v-29.34) > 0.0001 {
+		t.Errorf("Invalid GPS latitude seconds: %f", v)
+	}
+
+	if _, err := ParseEXIF([]byte("XX*\x00\x08\x00\x00\x00")); err == nil {
+		t.Error("Invalid EXIF should not be parsed")
+	}
+}
+
+func TestBuildEXIF(t *testing.T) {
+	raw := BuildEXIF(map[uint16]string{
+		EXIFTagCopyright: "(c) Example Inc.",
+		EXIFTagArtist:    "abc",
+	})
+
+	exif, err := ParseEXIF(raw)
+	if err != nil {
+		t.Fatalf("Cannot parse EXIF: %s", err)
+	}
+	if len(exif.Tags) != 2 || len(exif.GPSTags) != 0 {
+		t.Errorf("Invalid number of fields: %d, %d", len(exif.Tags), len(exif.GPSTags))
+	}
+	if v := exif.Tags[EXIFTagArtist].String(); v != "abc" {
+		t.Errorf("Invalid artist: %s", v)
+	}
+	if v := exif.Tags[EXIFTagCopyright].String(); v != "(c) Example Inc." {
+		t.Errorf("Invalid copyright: %s", v)
+	}
+}
+
+func TestSetEXIFOrientation(t *testing.T) {
+	raw := newTestEXIF(6)
+	if !setEXIFOrientation(raw, 1) {
+		t.Fatal("Orientation field not found")
+	}
+
+	exif, _ := ParseEXIF(raw)
+	if v := exif.Tags[EXIFTagOrientation].Int(0); v != 1 {
+		t.Errorf("Invalid orientation: %d != 1", v)
+	}
+}
@@ -0,0 +1,156 @@
+package processing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"unicode/utf16"
+)
+
+const iccMarkerJPEG = "ICC_PROFILE\x00"
+
+// ExtractICCProfile returns the embedded ICC profile of JPEG, PNG or WebP image buffer.
+// It returns nil if the image has no embedded profile.
+func ExtractICCProfile(buf []byte) []byte {
+	switch {
+	case isJPEGBuffer(buf):
+		return extractICCProfileJPEG(buf)
+	case isPNGBuffer(buf):
+		return extractICCProfilePNG(buf)
+	case isWebPBuffer(buf):
+		return extractICCProfileWebP(buf)
+	}
+	return nil
+}
+
+// The profile may be split into multiple APP2 segments, each one has its sequence number.
+func extractICCProfileJPEG(buf []byte) []byte {
+	chunks := make(map[int][]byte)
+	total := 0
+
+	eachJPEGSegment(buf, func(marker byte, offset int, data []byte) bool {
+		if marker == jpegMarkerAPP2 && len(data) > 14 && string(data[:12]) == iccMarkerJPEG {
+			chunks[int(data[12])] = data[14:]
+			total = int(data[13])
+		}
+		return true
+	})
+
+	if total == 0 || len(chunks) != total {
+		return nil
+	}
+
+	var profile []byte
+	for i := 1; i <= total; i++ {
+		chunk, ok := chunks[i]
+		if !ok {
+			return nil
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile
+}
+
+func extractICCProfilePNG(buf []byte) []byte {
+	var profile []byte
+	eachPNGChunk(buf, func(kind string, offset int, data []byte) bool {
+		if kind == "IDAT" {
+			return false
+		}
+		if kind != "iCCP" {
+			return true
+		}
+
+		// Profile name, null separator, compression method and zlib compressed profile
+		i := bytes.IndexByte(data, 0)
+		if i < 0 || i+2 > len(data) {
+			return false
+		}
+		r, err := zlib.NewReader(bytes.NewReader(data[i+2:]))
+		if err != nil {
+			return false
+		}
+		defer r.Close()
+		profile, _ = ioutil.ReadAll(r)
+		return false
+	})
+	return profile
+}
+
+func extractICCProfileWebP(buf []byte) []byte {
+	var profile []byte
+	eachWebPChunk(buf, func(kind string, data []byte) bool {
+		if kind == "ICCP" {
+			profile = data
+			return false
+		}
+		return true
+	})
+	return profile
+}
+
+// ICCProfileColorSpace returns the data colour space of the ICC profile (e.g. "RGB", "CMYK", "GRAY").
+func ICCProfileColorSpace(profile []byte) string {
+	if len(profile) < 128 {
+		return ""
+	}
+	return strings.TrimSpace(string(profile[16:20]))
+}
+
+// ICCProfileDescription returns the description of the ICC profile (e.g. "sRGB IEC61966-2.1").
+// Both of ICC v2 "desc" and ICC v4 "mluc" description types are supported.
+func ICCProfileDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return ""
+		}
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset < 0 || size < 12 || offset+size > len(profile) {
+			return ""
+		}
+		return parseICCText(profile[offset : offset+size])
+	}
+	return ""
+}
+
+func parseICCText(data []byte) string {
+	switch string(data[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(data[8:]))
+		if n <= 0 || 12+n > len(data) {
+			return ""
+		}
+		return strings.TrimRight(string(data[12:12+n]), "\x00")
+	case "mluc":
+		records := int(binary.BigEndian.Uint32(data[8:]))
+		if records < 1 || len(data) < 28 {
+			return ""
+		}
+		// Use the first record
+		length := int(binary.BigEndian.Uint32(data[20:]))
+		offset := int(binary.BigEndian.Uint32(data[24:]))
+		if offset+length > len(data) {
+			return ""
+		}
+		u := make([]uint16, length/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(data[offset+i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(u)), "\x00")
+	case "text":
+		return strings.TrimRight(string(data[8:]), "\x00")
+	}
+	return ""
+}
@@ -0,0 +1,147 @@
+package processing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+	"unicode/utf16"
+)
+
+func newTestICCProfile(colorSpace string, descTag []byte) []byte {
+	profile := make([]byte, 132+12)
+	copy(profile[16:20], colorSpace)
+	copy(profile[36:40], "acsp")
+	binary.BigEndian.PutUint32(profile[128:], 1)
+	copy(profile[132:136], "desc")
+	binary.BigEndian.PutUint32(profile[136:], uint32(len(profile)))
+	binary.BigEndian.PutUint32(profile[140:], uint32(len(descTag)))
+	profile = append(profile, descTag...)
+	binary.BigEndian.PutUint32(profile[0:], uint32(len(profile)))
+	return profile
+}
+
+func newTestDescTag(desc string) []byte {
+	tag := make([]byte, 12)
+	copy(tag, "desc")
+	binary.BigEndian.PutUint32(tag[8:], uint32(len(desc)+1))
+	tag = append(tag, desc...)
+	tag = append(tag, 0)
+	return append(tag, make([]byte, 67+12)...)
+}
+
+func newTestMlucTag(desc string) []byte {
+	u := utf16.Encode([]rune(desc))
+	tag := make([]byte, 28)
+	copy(tag, "mluc")
+	binary.BigEndian.PutUint32(tag[8:], 1)
+	binary.BigEndian.PutUint32(tag[12:], 12)
+	copy(tag[16:20], "enUS")
+	binary.BigEndian.PutUint32(tag[20:], uint32(len(u)*2))
+	binary.BigEndian.PutUint32(tag[24:], 28)
+	for _, c := range u {
+		tag = append(tag, byte(c>>8), byte(c))
+	}
+	return tag
+}
+
+func newTestJPEGWithICC(profile []byte, chunkSize int) []byte {
+	buf := []byte{0xFF, 0xD8}
+	total := (len(profile) + chunkSize - 1) / chunkSize
+	for i := 0; i < total; i++ {
+		end := (i + 1) * chunkSize
+		if end > len(profile) {
+			end = len(profile)
+		}
+		data := append([]byte(iccMarkerJPEG), byte(i+1), byte(total))
+		data = append(data, profile[i*chunkSize:end]...)
+		buf = append(buf, 0xFF, 0xE2, byte((len(data)+2)>>8), byte(len(data)+2))
+		buf = append(buf, data...)
+	}
+	return append(buf, 0xFF, 0xD9)
+}
+
+func newTestPNGWithICC(profile []byte) []byte {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(profile)
+	w.Close()
+
+	data := append([]byte("icc\x00\x00"), z.Bytes()...)
+	chunk := make([]byte, 8)
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], "iCCP")
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	buf := []byte("\x89PNG\r\n\x1a\n")
+	buf = append(buf, chunk...)
+	return append(buf, 0, 0, 0, 0, 'I', 'E', 'N', 'D', 0xAE, 0x42, 0x60, 0x82)
+}
+
+func newTestWebPWithICC(profile []byte) []byte {
+	chunk := make([]byte, 8)
+	copy(chunk, "ICCP")
+	binary.LittleEndian.PutUint32(chunk[4:], uint32(len(profile)))
+	chunk = append(chunk, profile...)
+	if len(profile)%2 == 1 {
+		chunk = append(chunk, 0)
+	}
+
+	buf := make([]byte, 12)
+	copy(buf, "RIFF")
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(chunk)+4))
+	copy(buf[8:], "WEBP")
+	return append(buf, chunk...)
+}
+
+func TestExtractICCProfile(t *testing.T) {
+	profile := newTestICCProfile("CMYK", newTestDescTag("U.S. Web Coated (SWOP) v2"))
+
+	cases := []struct {
+		name string
+		buf  []byte
+	}{
+		{"jpeg", newTestJPEGWithICC(profile, len(profile))},
+		{"jpeg(multiple segments)", newTestJPEGWithICC(profile, 50)},
+		{"png", newTestPNGWithICC(profile)},
+		{"webp", newTestWebPWithICC(profile)},
+	}
+
+	for _, test := range cases {
+		extracted := ExtractICCProfile(test.buf)
+		if !bytes.Equal(extracted, profile) {
+			t.Errorf("Invalid ICC profile extracted from %s: %d bytes", test.name, len(extracted))
+		}
+	}
+
+	buf, _ := ioutil.ReadFile("../testdata/test.png")
+	if ExtractICCProfile(buf) != nil {
+		t.Error("ICC profile should not be extracted")
+	}
+}
+
+func TestICCProfileDescription(t *testing.T) {
+	cases := []struct {
+		profile    []byte
+		colorSpace string
+		desc       string
+	}{
+		{newTestICCProfile("RGB ", newTestDescTag("sRGB IEC61966-2.1")), "RGB", "sRGB IEC61966-2.1"},
+		{newTestICCProfile("CMYK", newTestMlucTag("Japan Color 2001 Coated")), "CMYK", "Japan Color 2001 Coated"},
+		{[]byte("foo"), "", ""},
+	}
+
+	for _, test := range cases {
+		if cs := ICCProfileColorSpace(test.profile); cs != test.colorSpace {
+			t.Errorf("Invalid ICC profile color space: %s != %s", cs, test.colorSpace)
+		}
+		if desc := ICCProfileDescription(test.profile); desc != test.desc {
+			t.Errorf("Invalid ICC profile description: %s != %s", desc, test.desc)
+		}
+	}
+}
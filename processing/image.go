@@ -1,8 +1,9 @@
-package main
+package processing
 
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 
 	"gopkg.in/h2non/bimg.v1"
 )
@@ -26,10 +27,21 @@ type ImageInfoSource struct {
 	Space       string `json:"space"`
 	Alpha       bool   `json:"hasAlpha"`
 	Profile     bool   `json:"hasProfile"`
+	ProfileName string `json:"profileName"`
 	Channels    int    `json:"channels"`
 	Orientation int    `json:"orientation"`
 }
 
+// DataOutputFuncs maps the output formats which respond the data computed from the image
+// instead of the image itself.
+var DataOutputFuncs = map[string]func([]byte, ImageOptions) (Image, error){
+	"json":      JSONImage,
+	"blurhash":  BlurHashImage,
+	"thumbhash": ThumbHashImage,
+	"datauri":   DataURIImage,
+	"stats":     StatsImage,
+}
+
 func InfoImage(buf []byte, o ImageOptions) (Image, error) {
 	// We're not handling an image here, but we reused the struct.
 	// An interface will be definitively better here.
@@ -40,6 +52,8 @@ func InfoImage(buf []byte, o ImageOptions) (Image, error) {
 		return image, NewError("Cannot retrieve image metadata: %s"+err.Error(), BadRequest)
 	}
 
+	profile := ExtractICCProfile(buf)
+
 	info := ImageInfo{
 		Version: 1,
 		Source: ImageInfoSource{
@@ -49,6 +63,7 @@ func InfoImage(buf []byte, o ImageOptions) (Image, error) {
 			Space:       meta.Space,
 			Alpha:       meta.Alpha,
 			Profile:     meta.Profile,
+			ProfileName: ICCProfileDescription(profile),
 			Channels:    meta.Channels,
 			Orientation: meta.Orientation,
 		},
@@ -66,15 +81,31 @@ func ConvertImage(buf []byte, o ImageOptions) (Image, error) {
 		return Image{Body: buf, Mime: mime}, nil
 	}
 
+	src := buf
+	if len(o.Clip) == 4 || o.Rotate != 0 {
+		var err error
+		buf, err = clipImage(buf, o)
+		if err != nil {
+			return Image{}, err
+		}
+	}
+
 	opts := BimgOptions(o)
 
 	imageTypeDest := opts.Type
 	if imageTypeDest == 0 {
-		imageTypeDest = bimg.DetermineImageType(buf)
+		// Keep the source type, the clipped intermediate image is PNG
+		imageTypeDest = bimg.DetermineImageType(src)
+		opts.Type = imageTypeDest
 	}
 
+	// Animated GIF and WebP are resized frame by frame, since libvips loads the first frame only.
+	// The other output formats have the first frame.
+	animated := !o.NoAnimation && len(o.Clip) == 0 && o.Rotate == 0 &&
+		(imageTypeDest == bimg.GIF || imageTypeDest == bimg.WEBP) && IsAnimatedImage(buf)
+
 	// If output image format is unsupported, fallback to JPEG
-	if bimg.IsTypeSupportedSave(imageTypeDest) == false {
+	if !animated && bimg.IsTypeSupportedSave(imageTypeDest) == false {
 		opts.Type = bimg.JPEG
 	}
 
@@ -119,7 +150,50 @@ func ConvertImage(buf []byte, o ImageOptions) (Image, error) {
 		}
 	}
 
-	return Process(buf, opts)
+	if animated {
+		return ProcessAnimation(buf, opts, o)
+	}
+
+	image, err := Process(buf, opts)
+	if err != nil {
+		return image, err
+	}
+	if image.Body, err = applyImageOperations(image.Body, o); err != nil {
+		return Image{}, err
+	}
+
+	return applyMetadataMode(src, image, o), nil
+}
+
+// clipImage extracts the clip area of the image as a lossless intermediate image,
+// since libvips extracts the area after resize in a single pass.
+// EXIF orientation is applied here, because libvips ignores it when the rotation is given.
+func clipImage(buf []byte, o ImageOptions) ([]byte, error) {
+	opts := bimg.Options{
+		Type:          bimg.PNG,
+		StripMetadata: true,
+	}
+	if !o.Monochrome {
+		// The embedded profile is lost by stripping the metadata
+		opts.OutputICC = o.OutputICC
+	}
+
+	if len(o.Clip) == 4 {
+		x1, y1, x2, y2 := o.Clip[0], o.Clip[1], o.Clip[2], o.Clip[3]
+		if x1 < 0 || y1 < 0 || x2 <= x1 || y2 <= y1 {
+			return nil, NewError(fmt.Sprintf("Invalid clip area: %d,%d,%d,%d", x1, y1, x2, y2), BadRequest)
+		}
+		opts.Left = x1
+		opts.Top = y1
+		opts.AreaWidth = x2 - x1
+		opts.AreaHeight = y2 - y1
+	}
+
+	out, err := Process(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
 }
 
 func Process(buf []byte, opts bimg.Options) (out Image, err error) {
@@ -0,0 +1,293 @@
+package processing
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"sort"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// IsAnimatedImage returns true if the given buffer is a GIF image which has two or more frames, or an animated WebP image.
+// The GIF blocks and the WebP chunks are scanned without decoding the frames, so it is cheap enough to call per request.
+func IsAnimatedImage(buf []byte) bool {
+	return countGIFFrames(buf) > 1 || isAnimatedWebP(buf)
+}
+
+// countGIFFrames counts the image descriptor blocks of a GIF buffer.
+// It returns 0 if the buffer is not a GIF image or is malformed.
+func countGIFFrames(buf []byte) int {
+	if len(buf) < 13 || (string(buf[:6]) != "GIF87a" && string(buf[:6]) != "GIF89a") {
+		return 0
+	}
+
+	pos := 13
+	if buf[10]&0x80 != 0 {
+		// Skip global color table
+		pos += 3 * (1 << (uint(buf[10]&0x07) + 1))
+	}
+
+	frames := 0
+	for pos < len(buf) {
+		switch buf[pos] {
+		case 0x21: // Extension
+			pos = skipGIFSubBlocks(buf, pos+2)
+		case 0x2C: // Image descriptor
+			if pos+10 > len(buf) {
+				return frames
+			}
+			flags := buf[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				// Skip local color table
+				pos += 3 * (1 << (uint(flags&0x07) + 1))
+			}
+			// Skip LZW minimum code size and image data
+			pos = skipGIFSubBlocks(buf, pos+1)
+			frames++
+		case 0x3B: // Trailer
+			return frames
+		default:
+			return frames
+		}
+	}
+	return frames
+}
+
+func skipGIFSubBlocks(buf []byte, pos int) int {
+	for pos < len(buf) {
+		size := int(buf[pos])
+		pos++
+		if size == 0 {
+			break
+		}
+		pos += size
+	}
+	return pos
+}
+
+// validateAnimation checks the limits by the number of frames and the logical screen size,
+// so that the frames are never decoded if the animation is too large.
+func validateAnimation(frames int, config image.Config, o ImageOptions) error {
+	if o.MaxAnimationFrames > 0 && frames > o.MaxAnimationFrames {
+		return fmt.Errorf("The number of animation frames(%d) is exceed maximum frames(%d)", frames, o.MaxAnimationFrames)
+	}
+
+	totalPixels := frames * config.Width * config.Height
+	if o.MaxAnimationMP > 0 && totalPixels > (o.MaxAnimationMP*1000000) {
+		return fmt.Errorf("The total animation area(%dx%dx%d) is exceed maximum area(%dMP)", config.Width, config.Height, frames, o.MaxAnimationMP)
+	}
+	return nil
+}
+
+// animationFrameFunc is called with the full picture of each frame on the canvas and its delay in milliseconds.
+type animationFrameFunc func(canvas image.Image, delay int) error
+
+// animationEncoder encodes the frames of the animation in the output format.
+// The loop count is of GIF, 0 loops forever and -1 plays once.
+type animationEncoder interface {
+	AddFrame(frame image.Image, delay int) error
+	Encode(loopCount int) (Image, error)
+}
+
+// animationInfo returns the number of frames and the canvas size of the animated GIF or WebP without decoding the frames.
+func animationInfo(buf []byte) (int, image.Config, error) {
+	if isWebPBuffer(buf) {
+		frames, config := webpAnimationInfo(buf)
+		return frames, config, nil
+	}
+	config, err := gif.DecodeConfig(bytes.NewReader(buf))
+	return countGIFFrames(buf), config, err
+}
+
+// ProcessAnimation resizes every frame of an animated GIF or WebP and re-encodes them as an animated GIF or WebP.
+// Frame delays and loop count are carried over from the source image.
+func ProcessAnimation(buf []byte, opts bimg.Options, o ImageOptions) (Image, error) {
+	frames, config, err := animationInfo(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	err = validateAnimation(frames, config, o)
+	if err != nil {
+		return Image{}, NewError(err.Error(), BadRequest)
+	}
+
+	// Each frame is resized as a standalone PNG image by libvips
+	frameOpts := opts
+	frameOpts.Type = bimg.PNG
+
+	var encoder animationEncoder = &gifAnimationEncoder{}
+	if opts.Type == bimg.WEBP {
+		encoder = &webpAnimationEncoder{quality: opts.Quality}
+	}
+	decode := decodeGIFAnimation
+	if isWebPBuffer(buf) {
+		decode = decodeWebPAnimation
+	}
+
+	loopCount, err := decode(buf, func(canvas image.Image, delay int) error {
+		resized, err := resizeAnimationFrame(canvas, frameOpts, o)
+		if err != nil {
+			return err
+		}
+		return encoder.AddFrame(resized, delay)
+	})
+	if err != nil {
+		return Image{}, err
+	}
+
+	return encoder.Encode(loopCount)
+}
+
+// decodeGIFAnimation composes each frame of the animated GIF onto the canvas by the disposal of the frames.
+func decodeGIFAnimation(buf []byte, fn animationFrameFunc) (int, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(buf))
+	if err != nil {
+		return 0, err
+	}
+
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	for i, frame := range g.Image {
+		// Compose the frame onto the canvas to get the full picture of this frame
+		var previous *image.RGBA
+		if i < len(g.Disposal) && g.Disposal[i] == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		// GIF delay is in 1/100 seconds
+		if err := fn(canvas, g.Delay[i]*10); err != nil {
+			return 0, err
+		}
+
+		if i < len(g.Disposal) {
+			switch g.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.Transparent, image.ZP, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+	return g.LoopCount, nil
+}
+
+type gifAnimationEncoder struct {
+	gif gif.GIF
+}
+
+func (e *gifAnimationEncoder) AddFrame(frame image.Image, delay int) error {
+	e.gif.Image = append(e.gif.Image, palettedFrame(frame))
+	e.gif.Delay = append(e.gif.Delay, delay/10)
+	// Every output frame is a full frame, so it must not be drawn over the previous one
+	e.gif.Disposal = append(e.gif.Disposal, gif.DisposalBackground)
+	return nil
+}
+
+func (e *gifAnimationEncoder) Encode(loopCount int) (Image, error) {
+	e.gif.LoopCount = loopCount
+
+	var b bytes.Buffer
+	err := gif.EncodeAll(&b, &e.gif)
+	if err != nil {
+		return Image{}, err
+	}
+
+	return Image{Body: b.Bytes(), Mime: GetImageMimeType(bimg.GIF)}, nil
+}
+
+// resizeAnimationFrame resizes the frame as PNG image, and transforms it by the image operations.
+func resizeAnimationFrame(frame image.Image, opts bimg.Options, o ImageOptions) (image.Image, error) {
+	var b bytes.Buffer
+	err := png.Encode(&b, frame)
+	if err != nil {
+		return nil, err
+	}
+
+	resized, err := Process(b.Bytes(), opts)
+	if err != nil {
+		return nil, err
+	}
+	if resized.Body, err = applyImageOperations(resized.Body, o); err != nil {
+		return nil, err
+	}
+
+	return png.Decode(bytes.NewReader(resized.Body))
+}
+
+// palettedFrame converts the frame to the paletted image by the palette of its own colours.
+func palettedFrame(img image.Image) *image.Paletted {
+	// No dithering to avoid flickering between frames
+	dst := image.NewPaletted(img.Bounds(), framePalette(img))
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	return dst
+}
+
+// framePaletteSamples is the maximum number of the pixels which the palette of the frame is built from
+const framePaletteSamples = 16384
+
+// framePalette builds the palette of the colours of the composed frame, since the frame has the colours
+// which the previous frames left on the canvas as well as the colours of the local palette.
+// More than 256 colours are reduced by median cut, and the transparent colour is added if needed.
+func framePalette(img image.Image) color.Palette {
+	bounds := img.Bounds()
+	step := 1 + bounds.Dx()*bounds.Dy()/framePaletteSamples
+	colors := make(map[[3]uint8]bool)
+	pixels := make(colorBox, 0, framePaletteSamples+1)
+	transparent := false
+	for i := 0; i < bounds.Dx()*bounds.Dy(); i++ {
+		x, y := bounds.Min.X+i%bounds.Dx(), bounds.Min.Y+i/bounds.Dx()
+		r, g, b, a := pixelRGBA(img, x, y)
+		if a < 128 {
+			transparent = true
+			continue
+		}
+		// The colours are not collected beyond the palette size, which are reduced by median cut anyway
+		if len(colors) <= 256 {
+			colors[[3]uint8{r, g, b}] = true
+		}
+		if i%step == 0 {
+			pixels = append(pixels, [3]uint8{r, g, b})
+		}
+	}
+
+	n := 256
+	if transparent {
+		n--
+	}
+	palette := make(color.Palette, 0, n+1)
+	if len(colors) <= n {
+		exact := make(colorBox, 0, len(colors))
+		for c := range colors {
+			exact = append(exact, c)
+		}
+		// The colours are sorted to keep the output stable
+		sort.Slice(exact, func(i, j int) bool {
+			for c := 0; c < 3; c++ {
+				if exact[i][c] != exact[j][c] {
+					return exact[i][c] < exact[j][c]
+				}
+			}
+			return false
+		})
+		for _, c := range exact {
+			palette = append(palette, color.RGBA{c[0], c[1], c[2], 0xFF})
+		}
+	} else {
+		for _, box := range medianCut(pixels, n) {
+			c := box.mean()
+			palette = append(palette, color.RGBA{c[0], c[1], c[2], 0xFF})
+		}
+	}
+	if transparent || len(palette) == 0 {
+		palette = append(palette, color.Transparent)
+	}
+	return palette
+}
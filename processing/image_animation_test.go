@@ -0,0 +1,264 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"io/ioutil"
+	"testing"
+)
+
+func TestIsAnimatedImage(t *testing.T) {
+	files := []struct {
+		name     string
+		expected bool
+	}{
+		{"animated.gif", true},
+		{"large.jpg", false},
+		{"test.png", false},
+		{"1024bytes", false},
+	}
+
+	for _, file := range files {
+		buf, _ := ioutil.ReadAll(readFile(file.name))
+		if IsAnimatedImage(buf) != file.expected {
+			t.Errorf("Invalid animated image detection: %s != %t", file.name, file.expected)
+		}
+	}
+}
+
+func TestImageAnimation(t *testing.T) {
+	opts := ImageOptions{
+		Width:      60,
+		Height:     40,
+		ResizeMode: ResizeModeCrop,
+	}
+	buf, _ := ioutil.ReadAll(readFile("animated.gif"))
+
+	img, err := ConvertImage(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "image/gif" {
+		t.Errorf("Invalid image MIME type: %s", img.Mime)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(img.Body))
+	if err != nil {
+		t.Fatalf("Cannot decode output image: %s", err)
+	}
+	if len(g.Image) != 3 {
+		t.Fatalf("Invalid number of frames: %d != 3", len(g.Image))
+	}
+	for i, frame := range g.Image {
+		if frame.Bounds().Dx() != 60 || frame.Bounds().Dy() != 40 {
+			t.Errorf("Invalid frame size: expected 60x40, but actual %dx%d", frame.Bounds().Dx(), frame.Bounds().Dy())
+		}
+		if g.Delay[i] != (i+1)*10 {
+			t.Errorf("Invalid frame delay: %d != %d", g.Delay[i], (i+1)*10)
+		}
+	}
+	if g.LoopCount != 0 {
+		t.Errorf("Invalid loop count: %d != 0", g.LoopCount)
+	}
+}
+
+func TestImageAnimationDisabled(t *testing.T) {
+	opts := ImageOptions{
+		Width:       60,
+		Height:      40,
+		ResizeMode:  ResizeModeCrop,
+		NoAnimation: true,
+	}
+	buf, _ := ioutil.ReadAll(readFile("animated.gif"))
+
+	img, err := ConvertImage(buf, opts)
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if IsAnimatedImage(img.Body) {
+		t.Error("Output image should not be animated")
+	}
+	if err = assertSize(img.Body, 60, 40); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestImageAnimationLimit(t *testing.T) {
+	cases := []struct {
+		description string
+		imgOpts     ImageOptions
+		valid       bool
+	}{
+		{
+			description: "Max frames is 3, should be valid",
+			imgOpts:     ImageOptions{Width: 60, MaxAnimationFrames: 3},
+			valid:       true,
+		},
+		{
+			description: "Max frames is 2, should not be valid",
+			imgOpts:     ImageOptions{Width: 60, MaxAnimationFrames: 2},
+			valid:       false,
+		},
+		{
+			description: "Max total area is 1MP, should be valid",
+			imgOpts:     ImageOptions{Width: 60, MaxAnimationMP: 1},
+			valid:       true,
+		},
+	}
+	buf, _ := ioutil.ReadAll(readFile("animated.gif"))
+
+	for _, tc := range cases {
+		_, err := ConvertImage(buf, tc.imgOpts)
+		if (err == nil) != tc.valid {
+			t.Errorf("Test %#v failed: %v\n", tc.description, err)
+		}
+	}
+}
+
+func newTestLargeScreenGIF(width, height, frames int) []byte {
+	g := &gif.GIF{Config: image.Config{Width: width, Height: height, ColorModel: color.Palette(palette.Plan9)}}
+	for i := 0; i < frames; i++ {
+		g.Image = append(g.Image, image.NewPaletted(image.Rect(0, 0, 1, 1), palette.Plan9))
+		g.Delay = append(g.Delay, 10)
+	}
+	var b bytes.Buffer
+	gif.EncodeAll(&b, g)
+	return b.Bytes()
+}
+
+func TestImageAnimationAreaLimit(t *testing.T) {
+	// The logical screen is 2000x2000, so the total area is 8MP
+	buf := newTestLargeScreenGIF(2000, 2000, 2)
+
+	cases := []struct {
+		description string
+		imgOpts     ImageOptions
+		valid       bool
+	}{
+		{
+			description: "Max total area is 8MP, should be valid",
+			imgOpts:     ImageOptions{Width: 60, MaxAnimationMP: 8},
+			valid:       true,
+		},
+		{
+			description: "Max total area is 7MP, should not be valid",
+			imgOpts:     ImageOptions{Width: 60, MaxAnimationMP: 7},
+			valid:       false,
+		},
+		{
+			description: "Max total area is 1MP, should not be valid",
+			imgOpts:     ImageOptions{Width: 60, MaxAnimationMP: 1},
+			valid:       false,
+		},
+	}
+
+	for _, tc := range cases {
+		err := validateAnimation(countGIFFrames(buf), image.Config{Width: 2000, Height: 2000}, tc.imgOpts)
+		if (err == nil) != tc.valid {
+			t.Errorf("Test %#v failed: %v\n", tc.description, err)
+		}
+		if tc.valid {
+			continue
+		}
+		// The animation is rejected before the frames are decoded
+		_, err = ConvertImage(buf, tc.imgOpts)
+		if e, ok := err.(Error); !ok || e.HTTPCode() != 400 {
+			t.Errorf("Test %#v failed: expected bad request, but %v\n", tc.description, err)
+		}
+	}
+}
+
+func newTestAnimatedWebP() []byte {
+	header := make([]byte, 10)
+	header[0] = webpFlagAnimation
+	buf := append([]byte{}, "RIFF\x00\x00\x00\x00WEBP"...)
+	buf = appendWebPChunk(buf, "VP8X", header)
+	buf = appendWebPChunk(buf, "ANIM", make([]byte, 6))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(len(buf)-8))
+	return buf
+}
+
+func TestIsAnimatedWebP(t *testing.T) {
+	still, _ := ioutil.ReadAll(readFile("test.webp"))
+	if isAnimatedWebP(still) {
+		t.Error("Still WebP should not be animated")
+	}
+	if !isAnimatedWebP(newTestAnimatedWebP()) {
+		t.Error("Animated WebP should be detected")
+	}
+}
+
+func TestImageAnimatedWebP(t *testing.T) {
+	gifBuf, _ := ioutil.ReadAll(readFile("animated.gif"))
+	frames := countGIFFrames(gifBuf)
+
+	webp, err := ConvertImage(gifBuf, ImageOptions{Width: 60, OutputFormat: "webp"})
+	if err != nil {
+		t.Fatalf("Cannot convert the animated GIF to WebP: %s", err)
+	}
+	if !isAnimatedWebP(webp.Body) {
+		t.Fatal("The output should be an animated WebP")
+	}
+	if n, config := webpAnimationInfo(webp.Body); n != frames || config.Width != 60 {
+		t.Errorf("Invalid animated WebP: %d frames of %dx%d", n, config.Width, config.Height)
+	}
+
+	// The frames of the animated WebP are kept in GIF
+	out, err := ConvertImage(webp.Body, ImageOptions{Width: 30, OutputFormat: "gif"})
+	if err != nil {
+		t.Fatalf("Cannot convert the animated WebP to GIF: %s", err)
+	}
+	if n := countGIFFrames(out.Body); n != frames {
+		t.Errorf("Invalid number of the frames: %d != %d", n, frames)
+	}
+
+	// The first frame is processed with anim=false
+	out, err = ConvertImage(gifBuf, ImageOptions{Width: 60, OutputFormat: "webp", NoAnimation: true})
+	if err != nil {
+		t.Fatalf("Cannot convert the first frame: %s", err)
+	}
+	if isAnimatedWebP(out.Body) {
+		t.Error("The output should not be animated with anim=false")
+	}
+}
+
+func TestFramePalette(t *testing.T) {
+	// The colours which the previous frames left on the canvas are kept as they are
+	img := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 10; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 10), 0x80, uint8(y * 20), 0xFF})
+		}
+	}
+	img.Set(0, 0, color.Transparent)
+
+	p := palettedFrame(img)
+	for x := 0; x < 20; x++ {
+		for y := 0; y < 10; y++ {
+			if x == 0 && y == 0 {
+				continue
+			}
+			if p.At(x, y) != img.At(x, y) {
+				t.Fatalf("Invalid colour at %d,%d: %v != %v", x, y, p.At(x, y), img.At(x, y))
+			}
+		}
+	}
+	if _, _, _, a := p.At(0, 0).RGBA(); a != 0 {
+		t.Error("The transparent pixel should be kept")
+	}
+
+	// More than 256 colours are reduced
+	img = image.NewRGBA(image.Rect(0, 0, 100, 100))
+	for x := 0; x < 100; x++ {
+		for y := 0; y < 100; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * 2), uint8(y * 2), 0x40, 0xFF})
+		}
+	}
+	if n := len(framePalette(img)); n > 256 || n < 128 {
+		t.Errorf("Invalid palette size: %d", n)
+	}
+}
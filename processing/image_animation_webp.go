@@ -0,0 +1,259 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// Flags of ANMF chunk of animated WebP
+const (
+	webpFrameDispose = 0x01 // The frame area is cleared to the background after the frame is shown
+	webpFrameNoBlend = 0x02 // The frame is drawn over the canvas without alpha blending
+)
+
+// isAnimatedWebP returns true if the given buffer is a WebP image which has the animation flag in VP8X chunk.
+func isAnimatedWebP(buf []byte) bool {
+	if !isWebPBuffer(buf) {
+		return false
+	}
+
+	animated := false
+	eachWebPChunk(buf, func(kind string, data []byte) bool {
+		animated = kind == "ANIM" || (kind == "VP8X" && len(data) > 0 && data[0]&webpFlagAnimation != 0)
+		return !animated && kind != "VP8 " && kind != "VP8L"
+	})
+	return animated
+}
+
+// webpAnimationInfo returns the number of ANMF chunks and the canvas size of VP8X chunk.
+func webpAnimationInfo(buf []byte) (int, image.Config) {
+	var config image.Config
+	frames := 0
+	eachWebPChunk(buf, func(kind string, data []byte) bool {
+		switch kind {
+		case "VP8X":
+			if len(data) >= 10 {
+				config.Width = int(getUint24LE(data[4:])) + 1
+				config.Height = int(getUint24LE(data[7:])) + 1
+			}
+		case "ANMF":
+			frames++
+		}
+		return true
+	})
+	return frames, config
+}
+
+// decodeWebPAnimation composes each frame of the animated WebP onto the canvas by the blending and disposal of the frames.
+// The frames are decoded by libvips one by one, since libvips loads the first frame only.
+func decodeWebPAnimation(buf []byte, fn animationFrameFunc) (int, error) {
+	var canvas *image.RGBA
+	loopCount := 0
+	var err error
+	eachWebPChunk(buf, func(kind string, data []byte) bool {
+		switch kind {
+		case "VP8X":
+			if len(data) >= 10 {
+				width, height := int(getUint24LE(data[4:]))+1, int(getUint24LE(data[7:]))+1
+				canvas = image.NewRGBA(image.Rect(0, 0, width, height))
+			}
+		case "ANIM":
+			if len(data) >= 6 {
+				loopCount = webpToGIFLoopCount(int(binary.LittleEndian.Uint16(data[4:])))
+			}
+		case "ANMF":
+			if canvas == nil || len(data) < 16 {
+				err = fmt.Errorf("Invalid animated WebP")
+				return false
+			}
+			x, y := int(getUint24LE(data[0:]))*2, int(getUint24LE(data[3:]))*2
+			width, height := int(getUint24LE(data[6:]))+1, int(getUint24LE(data[9:]))+1
+			delay, flags := int(getUint24LE(data[12:])), data[15]
+
+			var frame image.Image
+			frame, err = decodeWebPFrame(data[16:], width, height)
+			if err != nil {
+				return false
+			}
+			rect := image.Rect(x, y, x+width, y+height)
+			op := draw.Over
+			if flags&webpFrameNoBlend != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, rect, frame, frame.Bounds().Min, op)
+
+			if err = fn(canvas, delay); err != nil {
+				return false
+			}
+			if flags&webpFrameDispose != 0 {
+				draw.Draw(canvas, rect, image.Transparent, image.ZP, draw.Src)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if canvas == nil {
+		return 0, fmt.Errorf("Invalid animated WebP")
+	}
+	return loopCount, nil
+}
+
+// decodeWebPFrame decodes the image data of ANMF chunk, which is ALPH and VP8 chunks or VP8L chunk,
+// as a standalone WebP image.
+func decodeWebPFrame(data []byte, width, height int) (image.Image, error) {
+	frame := append([]byte("RIFF\x00\x00\x00\x00WEBP"), data...)
+	alpha := false
+	eachWebPChunk(frame, func(kind string, chunk []byte) bool {
+		alpha = kind == "ALPH"
+		return !alpha
+	})
+	if alpha {
+		// ALPH chunk requires VP8X chunk
+		header := make([]byte, 10)
+		header[0] = webpFlagAlpha
+		putUint24LE(header[4:], uint32(width-1))
+		putUint24LE(header[7:], uint32(height-1))
+		frame = appendWebPChunk([]byte("RIFF\x00\x00\x00\x00WEBP"), "VP8X", header)
+		frame = append(frame, data...)
+	}
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(frame)-8))
+
+	out, err := Process(frame, bimg.Options{Type: bimg.PNG})
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(out.Body))
+}
+
+// webpAnimationEncoder encodes each frame as WebP by libvips, and puts the image data into ANMF chunk.
+type webpAnimationEncoder struct {
+	quality int
+	width   int
+	height  int
+	alpha   bool
+	frames  []byte
+}
+
+func (e *webpAnimationEncoder) AddFrame(frame image.Image, delay int) error {
+	var b bytes.Buffer
+	err := png.Encode(&b, frame)
+	if err != nil {
+		return err
+	}
+
+	out, err := Process(b.Bytes(), bimg.Options{Type: bimg.WEBP, Quality: e.quality, StripMetadata: true})
+	if err != nil {
+		return err
+	}
+	data, alpha := webpImageData(out.Body)
+	if data == nil {
+		return fmt.Errorf("Cannot encode the animation frame as WebP")
+	}
+
+	size := frame.Bounds().Size()
+	e.frames = appendWebPFrame(e.frames, data, size.X, size.Y, delay)
+	if size.X > e.width {
+		e.width = size.X
+	}
+	if size.Y > e.height {
+		e.height = size.Y
+	}
+	e.alpha = e.alpha || alpha
+	return nil
+}
+
+func (e *webpAnimationEncoder) Encode(loopCount int) (Image, error) {
+	return Image{Body: encodeWebPAnimation(e.frames, e.width, e.height, e.alpha, loopCount), Mime: GetImageMimeType(bimg.WEBP)}, nil
+}
+
+// webpImageData returns ALPH and VP8 chunks or VP8L chunk of the still WebP image, and whether it has alpha.
+func webpImageData(buf []byte) ([]byte, bool) {
+	var data []byte
+	alpha, found := false, false
+	eachWebPChunk(buf, func(kind string, chunk []byte) bool {
+		switch kind {
+		case "ALPH":
+			alpha = true
+		case "VP8 ":
+			found = true
+		case "VP8L":
+			// Signature, 14 bits width - 1, 14 bits height - 1 and alpha flag
+			found = true
+			alpha = alpha || (len(chunk) >= 5 && binary.LittleEndian.Uint32(chunk[1:])>>28&1 == 1)
+		default:
+			return true
+		}
+		data = appendWebPChunk(data, kind, chunk)
+		return true
+	})
+	if !found {
+		return nil, false
+	}
+	return data, alpha
+}
+
+// appendWebPFrame appends ANMF chunk of the frame which covers the whole canvas from the top left.
+func appendWebPFrame(buf []byte, data []byte, width, height, delay int) []byte {
+	header := make([]byte, 16)
+	putUint24LE(header[6:], uint32(width-1))
+	putUint24LE(header[9:], uint32(height-1))
+	putUint24LE(header[12:], uint32(delay))
+	// Every frame is a full frame, so it must not be blended with the previous one
+	header[15] = webpFrameNoBlend
+	return appendWebPChunk(buf, "ANMF", append(header, data...))
+}
+
+// encodeWebPAnimation returns the animated WebP image of ANMF chunks.
+func encodeWebPAnimation(frames []byte, width, height int, alpha bool, loopCount int) []byte {
+	header := make([]byte, 10)
+	header[0] = webpFlagAnimation
+	if alpha {
+		header[0] |= webpFlagAlpha
+	}
+	putUint24LE(header[4:], uint32(width-1))
+	putUint24LE(header[7:], uint32(height-1))
+
+	// Background colour and loop count
+	anim := make([]byte, 6)
+	binary.LittleEndian.PutUint16(anim[4:], uint16(gifToWebPLoopCount(loopCount)))
+
+	out := append([]byte{}, "RIFF\x00\x00\x00\x00WEBP"...)
+	out = appendWebPChunk(out, "VP8X", header)
+	out = appendWebPChunk(out, "ANIM", anim)
+	out = append(out, frames...)
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// gifToWebPLoopCount converts the loop count of GIF, in which -1 plays once and n repeats n times more,
+// to the loop count of WebP, which is the number of times to play.
+// Both of them loop forever by 0.
+func gifToWebPLoopCount(loopCount int) int {
+	switch {
+	case loopCount == 0:
+		return 0
+	case loopCount < 0:
+		return 1
+	default:
+		return loopCount + 1
+	}
+}
+
+func webpToGIFLoopCount(loopCount int) int {
+	switch loopCount {
+	case 0:
+		return 0
+	case 1:
+		return -1
+	default:
+		return loopCount - 1
+	}
+}
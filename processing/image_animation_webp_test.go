@@ -0,0 +1,49 @@
+package processing
+
+import (
+	"testing"
+)
+
+func TestWebPAnimationContainer(t *testing.T) {
+	// VP8L signature and 14 bits width - 1, 14 bits height - 1 and alpha flag
+	frame := appendWebPChunk(nil, "VP8L", []byte{0x2F, 0x09, 0x40, 0x02, 0x10})
+	data, alpha := webpImageData(append([]byte("RIFF\x00\x00\x00\x00WEBP"), frame...))
+	if data == nil || !alpha {
+		t.Fatalf("Invalid image data of the frame: %v, %t", data, alpha)
+	}
+
+	var frames []byte
+	for i := 0; i < 3; i++ {
+		frames = appendWebPFrame(frames, data, 10, 10, 100)
+	}
+	buf := encodeWebPAnimation(frames, 10, 10, alpha, 0)
+
+	if !isAnimatedWebP(buf) || !IsAnimatedImage(buf) {
+		t.Fatal("The animation should be detected")
+	}
+	n, config := webpAnimationInfo(buf)
+	if n != 3 || config.Width != 10 || config.Height != 10 {
+		t.Errorf("Invalid animation info: %d frames of %dx%d", n, config.Width, config.Height)
+	}
+}
+
+func TestWebPLoopCount(t *testing.T) {
+	cases := []struct {
+		gif  int
+		webp int
+	}{
+		{0, 0},
+		{-1, 1},
+		{1, 2},
+		{4, 5},
+	}
+
+	for _, tc := range cases {
+		if n := gifToWebPLoopCount(tc.gif); n != tc.webp {
+			t.Errorf("Invalid WebP loop count of %d: %d != %d", tc.gif, n, tc.webp)
+		}
+		if n := webpToGIFLoopCount(tc.webp); n != tc.gif {
+			t.Errorf("Invalid GIF loop count of %d: %d != %d", tc.webp, n, tc.gif)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// ImageDetail represents the full metadata of the source image
+type ImageDetail struct {
+	Version     int                 `json:"version"`
+	Mime        string              `json:"mime"`
+	FileSize    int                 `json:"fileSize"`
+	Width       int                 `json:"width"`
+	Height      int                 `json:"height"`
+	Type        string              `json:"type"`
+	Space       string              `json:"space"`
+	Alpha       bool                `json:"hasAlpha"`
+	Channels    int                 `json:"channels"`
+	Orientation int                 `json:"orientation"`
+	Pages       int                 `json:"pages"`
+	Frames      int                 `json:"frames"`
+	Profile     *ImageDetailProfile `json:"profile,omitempty"`
+	EXIF        *ImageDetailEXIF    `json:"exif,omitempty"`
+	GPS         *ImageDetailGPS     `json:"gps,omitempty"`
+}
+
+type ImageDetailProfile struct {
+	Name       string `json:"name"`
+	ColorSpace string `json:"colorSpace"`
+	Size       int    `json:"size"`
+}
+
+type ImageDetailEXIF struct {
+	Make             string  `json:"make,omitempty"`
+	Model            string  `json:"model,omitempty"`
+	LensModel        string  `json:"lensModel,omitempty"`
+	Software         string  `json:"software,omitempty"`
+	DateTime         string  `json:"dateTime,omitempty"`
+	DateTimeOriginal string  `json:"dateTimeOriginal,omitempty"`
+	ExposureTime     string  `json:"exposureTime,omitempty"`
+	FNumber          float64 `json:"fNumber,omitempty"`
+	ISOSpeed         int     `json:"isoSpeed,omitempty"`
+	FocalLength      float64 `json:"focalLength,omitempty"`
+	Artist           string  `json:"artist,omitempty"`
+	Copyright        string  `json:"copyright,omitempty"`
+}
+
+type ImageDetailGPS struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	Altitude  float64 `json:"altitude"`
+}
+
+// DetailImage returns the full metadata of the image as JSON.
+// GPS location is included only when exposeGPS is true.
+func DetailImage(buf []byte, mimeType string, exposeGPS bool) (Image, error) {
+	image := Image{Mime: "application/json"}
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return image, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	detail := ImageDetail{
+		Version:     1,
+		Mime:        mimeType,
+		FileSize:    len(buf),
+		Width:       meta.Size.Width,
+		Height:      meta.Size.Height,
+		Type:        meta.Type,
+		Space:       meta.Space,
+		Alpha:       meta.Alpha,
+		Channels:    meta.Channels,
+		Orientation: meta.Orientation,
+		Pages:       countImagePages(buf),
+		Frames:      1,
+	}
+
+	// Orientation 5 to 8 rotate the image by 90 or 270 degrees
+	if meta.Orientation >= 5 && meta.Orientation <= 8 {
+		detail.Width, detail.Height = detail.Height, detail.Width
+	}
+
+	if frames, _, _ := animationInfo(buf); frames > 1 {
+		detail.Frames = frames
+	}
+
+	if profile := ExtractICCProfile(buf); profile != nil {
+		detail.Profile = &ImageDetailProfile{
+			Name:       ICCProfileDescription(profile),
+			ColorSpace: ICCProfileColorSpace(profile),
+			Size:       len(profile),
+		}
+	}
+
+	if raw := ExtractEXIF(buf); raw != nil {
+		if exif, err := ParseEXIF(raw); err == nil {
+			detail.EXIF = newImageDetailEXIF(exif)
+			if exposeGPS {
+				detail.GPS = newImageDetailGPS(exif)
+			}
+		}
+	}
+
+	body, _ := json.Marshal(detail)
+	image.Body = body
+
+	return image, nil
+}
+
+func newImageDetailEXIF(exif *EXIF) *ImageDetailEXIF {
+	d := &ImageDetailEXIF{
+		Make:             exif.Tags[EXIFTagMake].String(),
+		Model:            exif.Tags[EXIFTagModel].String(),
+		LensModel:        exif.Tags[EXIFTagLensModel].String(),
+		Software:         exif.Tags[EXIFTagSoftware].String(),
+		DateTime:         exif.Tags[EXIFTagDateTime].String(),
+		DateTimeOriginal: exif.Tags[EXIFTagDateTimeOriginal].String(),
+		FNumber:          ToFixed(exif.Tags[EXIFTagFNumber].Float(0), 2),
+		ISOSpeed:         exif.Tags[EXIFTagISOSpeed].Int(0),
+		FocalLength:      ToFixed(exif.Tags[EXIFTagFocalLength].Float(0), 2),
+		Artist:           exif.Tags[EXIFTagArtist].String(),
+		Copyright:        exif.Tags[EXIFTagCopyright].String(),
+	}
+
+	if t := exif.Tags[EXIFTagExposureTime].Float(0); t > 0 {
+		if t < 1 {
+			d.ExposureTime = fmt.Sprintf("1/%d", Round(1/t))
+		} else {
+			d.ExposureTime = fmt.Sprintf("%g", ToFixed(t, 2))
+		}
+	}
+
+	return d
+}
+
+func newImageDetailGPS(exif *EXIF) *ImageDetailGPS {
+	lat, latOK := exif.GPSTags[EXIFTagGPSLatitude]
+	lon, lonOK := exif.GPSTags[EXIFTagGPSLongitude]
+	if !latOK && !lonOK {
+		return nil
+	}
+
+	gps := &ImageDetailGPS{
+		Latitude:  ToFixed(gpsDegrees(lat), 6),
+		Longitude: ToFixed(gpsDegrees(lon), 6),
+		Altitude:  ToFixed(exif.GPSTags[EXIFTagGPSAltitude].Float(0), 2),
+	}
+	if exif.GPSTags[EXIFTagGPSLatitudeRef].String() == "S" {
+		gps.Latitude = -gps.Latitude
+	}
+	if exif.GPSTags[EXIFTagGPSLongitudeRef].String() == "W" {
+		gps.Longitude = -gps.Longitude
+	}
+	if exif.GPSTags[EXIFTagGPSAltitudeRef].Int(0) == 1 {
+		gps.Altitude = -gps.Altitude
+	}
+	return gps
+}
+
+// gpsDegrees converts degrees, minutes and seconds to decimal degrees
+func gpsDegrees(e EXIFEntry) float64 {
+	return math.Abs(e.Float(0) + e.Float(1)/60 + e.Float(2)/3600)
+}
+
+var pdfPagePattern = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
+// countImagePages returns the number of pages of multi-page TIFF or PDF documents, or 1 for other images.
+func countImagePages(buf []byte) int {
+	switch bimg.DetermineImageType(buf) {
+	case bimg.TIFF:
+		return countTIFFPages(buf)
+	case bimg.PDF:
+		if n := len(pdfPagePattern.FindAllIndex(buf, -1)); n > 0 {
+			return n
+		}
+	}
+	return 1
+}
+
+func countTIFFPages(buf []byte) int {
+	order, offset, err := readTIFFHeader(buf)
+	if err != nil {
+		return 1
+	}
+
+	pages := 0
+	// Guard against circular IFD chain
+	for offset >= 8 && offset+2 <= len(buf) && pages < 10000 {
+		count := int(order.Uint16(buf[offset:]))
+		next := offset + 2 + count*12
+		if next+4 > len(buf) {
+			break
+		}
+		pages++
+		offset = int(order.Uint32(buf[next:]))
+	}
+
+	if pages == 0 {
+		return 1
+	}
+	return pages
+}
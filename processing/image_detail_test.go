@@ -0,0 +1,67 @@
+package processing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDetailImage(t *testing.T) {
+	buf := newTestJPEGWithEXIF(newTestEXIF(1))
+
+	cases := []struct {
+		exposeGPS bool
+		gps       bool
+	}{
+		{false, false},
+		{true, true},
+	}
+
+	for _, c := range cases {
+		image, err := DetailImage(buf, "image/jpeg", c.exposeGPS)
+		if err != nil {
+			t.Fatalf("Cannot detail the image: %s", err)
+		}
+		if image.Mime != "application/json" {
+			t.Errorf("Invalid MIME type: %s", image.Mime)
+		}
+
+		var detail ImageDetail
+		if err := json.Unmarshal(image.Body, &detail); err != nil {
+			t.Fatalf("Invalid JSON: %s", err)
+		}
+		if detail.Mime != "image/jpeg" || detail.FileSize != len(buf) {
+			t.Errorf("Invalid detail: %+v", detail)
+		}
+		if detail.Width != 1920 || detail.Height != 1080 {
+			t.Errorf("Invalid dimensions: %dx%d", detail.Width, detail.Height)
+		}
+		if detail.Pages != 1 || detail.Frames != 1 {
+			t.Errorf("Invalid pages or frames: %d, %d", detail.Pages, detail.Frames)
+		}
+		if detail.EXIF == nil || detail.EXIF.Artist != "Taro Yamada" || detail.EXIF.Copyright != "(c) Example Inc." {
+			t.Errorf("Invalid EXIF: %+v", detail.EXIF)
+		}
+		if (detail.GPS != nil) != c.gps {
+			t.Fatalf("Invalid GPS exposure: %+v", detail.GPS)
+		}
+		if c.gps && detail.GPS.Latitude != 35.65815 {
+			t.Errorf("Invalid latitude: %f", detail.GPS.Latitude)
+		}
+	}
+}
+
+func TestDetailImageAnimation(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("animated.gif"))
+
+	image, err := DetailImage(buf, "image/gif", false)
+	if err != nil {
+		t.Fatalf("Cannot detail the image: %s", err)
+	}
+
+	var detail ImageDetail
+	json.Unmarshal(image.Body, &detail)
+	if detail.Frames != 3 {
+		t.Errorf("Invalid number of frames: %d", detail.Frames)
+	}
+}
@@ -0,0 +1,169 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"sort"
+)
+
+const (
+	paletteSampleSize  = 64
+	paletteDefaultSize = 5
+	paletteMaxSize     = 16
+)
+
+// ImageJSON represents the output of f=json
+type ImageJSON struct {
+	Version int            `json:"version"`
+	Width   int            `json:"width"`
+	Height  int            `json:"height"`
+	Palette []PaletteColor `json:"palette"`
+}
+
+// PaletteColor represents a dominant colour and the proportion of the pixels close to it
+type PaletteColor struct {
+	Color      string  `json:"color"`
+	Proportion float64 `json:"proportion"`
+}
+
+// JSONImage returns the size and the dominant colours of the transformed image as JSON.
+func JSONImage(buf []byte, o ImageOptions) (Image, error) {
+	buf, err := transformSampleSource(buf, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	width, height, err := OrientedImageSize(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	sample, err := SampleImage(buf, paletteSampleSize, paletteSampleSize)
+	if err != nil {
+		return Image{}, err
+	}
+
+	n := o.PaletteSize
+	if n == 0 {
+		n = paletteDefaultSize
+	} else if n > paletteMaxSize {
+		n = paletteMaxSize
+	}
+
+	info := ImageJSON{
+		Version: 1,
+		Width:   width,
+		Height:  height,
+		Palette: DominantColors(sample, n),
+	}
+
+	body, _ := json.Marshal(info)
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+type colorBox [][3]uint8
+
+// channelRange returns the channel which has the widest range of values and its range
+func (b colorBox) channelRange() (int, int) {
+	channel, width := 0, 0
+	for c := 0; c < 3; c++ {
+		min, max := 255, 0
+		for _, p := range b {
+			v := int(p[c])
+			if v < min {
+				min = v
+			}
+			if v > max {
+				max = v
+			}
+		}
+		if max-min > width {
+			channel, width = c, max-min
+		}
+	}
+	return channel, width
+}
+
+func (b colorBox) mean() [3]uint8 {
+	var sum [3]int
+	for _, p := range b {
+		sum[0] += int(p[0])
+		sum[1] += int(p[1])
+		sum[2] += int(p[2])
+	}
+	n := len(b)
+	return [3]uint8{uint8((sum[0] + n/2) / n), uint8((sum[1] + n/2) / n), uint8((sum[2] + n/2) / n)}
+}
+
+// medianCut splits the pixels into the boxes at most n by median cut.
+func medianCut(pixels colorBox, n int) []colorBox {
+	boxes := []colorBox{pixels}
+	for len(boxes) < n {
+		// Split the box which has the most pixels weighted by its colour range
+		target, score := -1, 0
+		for i, box := range boxes {
+			if _, width := box.channelRange(); width*len(box) > score {
+				target, score = i, width*len(box)
+			}
+		}
+		if target < 0 {
+			break
+		}
+
+		box := boxes[target]
+		channel, _ := box.channelRange()
+		sort.Slice(box, func(i, j int) bool { return box[i][channel] < box[j][channel] })
+		// Pixels of the same value are kept in the same box
+		median := sort.Search(len(box), func(i int) bool { return box[i][channel] >= box[len(box)/2][channel] })
+		if median == 0 {
+			median = sort.Search(len(box), func(i int) bool { return box[i][channel] > box[0][channel] })
+		}
+		boxes[target] = box[:median]
+		boxes = append(boxes, box[median:])
+	}
+	return boxes
+}
+
+// DominantColors returns up to n dominant colours of the image by median cut, sorted by proportion.
+// Mostly transparent pixels are ignored.
+func DominantColors(img image.Image, n int) []PaletteColor {
+	bounds := img.Bounds()
+	pixels := make(colorBox, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := pixelRGBA(img, x, y)
+			if a < 128 {
+				continue
+			}
+			pixels = append(pixels, [3]uint8{r, g, b})
+		}
+	}
+
+	palette := []PaletteColor{}
+	if len(pixels) == 0 || n < 1 {
+		return palette
+	}
+
+	boxes := medianCut(pixels, n)
+
+	// Merge the boxes which have the same mean colour
+	counts := make(map[[3]uint8]int)
+	for _, box := range boxes {
+		counts[box.mean()] += len(box)
+	}
+	for c, count := range counts {
+		palette = append(palette, PaletteColor{
+			Color:      fmt.Sprintf("#%02x%02x%02x", c[0], c[1], c[2]),
+			Proportion: ToFixed(float64(count)/float64(len(pixels)), 4),
+		})
+	}
+	sort.Slice(palette, func(i, j int) bool {
+		if palette[i].Proportion != palette[j].Proportion {
+			return palette[i].Proportion > palette[j].Proportion
+		}
+		return palette[i].Color < palette[j].Color
+	})
+
+	return palette
+}
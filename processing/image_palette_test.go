@@ -0,0 +1,73 @@
+package processing
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDominantColors(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 40, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 40; x++ {
+			switch {
+			case y < 30:
+				img.Set(x, y, color.NRGBA{255, 0, 0, 255})
+			case x < 20:
+				img.Set(x, y, color.NRGBA{0, 0, 255, 255})
+			default:
+				// Transparent pixels are ignored
+				img.Set(x, y, color.NRGBA{0, 255, 0, 0})
+			}
+		}
+	}
+
+	palette := DominantColors(img, 2)
+	expected := []PaletteColor{
+		{"#ff0000", 0.8571},
+		{"#0000ff", 0.1429},
+	}
+	if len(palette) != len(expected) {
+		t.Fatalf("Invalid palette: %+v", palette)
+	}
+	for i, c := range expected {
+		if palette[i] != c {
+			t.Errorf("Invalid palette color: expected %+v, but actual %+v", c, palette[i])
+		}
+	}
+
+	// Solid image has only one colour
+	solid := image.NewGray(image.Rect(0, 0, 10, 10))
+	for i := range solid.Pix {
+		solid.Pix[i] = 255
+	}
+	palette = DominantColors(solid, 5)
+	if len(palette) != 1 || palette[0].Color != "#ffffff" || palette[0].Proportion != 1 {
+		t.Errorf("Invalid palette of solid image: %+v", palette)
+	}
+}
+
+func TestJSONImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := JSONImage(buf, ImageOptions{Width: 300, Height: 200, ResizeMode: ResizeModeCrop, PaletteSize: 3})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "application/json" {
+		t.Errorf("Invalid MIME type: %s", img.Mime)
+	}
+
+	var info ImageJSON
+	if err := json.Unmarshal(img.Body, &info); err != nil {
+		t.Fatalf("Invalid JSON: %s", err)
+	}
+	if info.Width != 300 || info.Height != 200 {
+		t.Errorf("Invalid image size: %dx%d", info.Width, info.Height)
+	}
+	if len(info.Palette) == 0 || len(info.Palette) > 3 {
+		t.Errorf("Invalid palette: %+v", info.Palette)
+	}
+}
@@ -0,0 +1,174 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const perceptualHashSampleSize = 64
+
+// ImagePerceptualHash represents the perceptual hashes of the image as 64 bit hex strings
+type ImagePerceptualHash struct {
+	PHash string `json:"phash"`
+	DHash string `json:"dhash"`
+}
+
+// ImagePerceptualHashComparison represents the hashes of two images and their Hamming distances
+type ImagePerceptualHashComparison struct {
+	Source   ImagePerceptualHash `json:"source"`
+	Target   ImagePerceptualHash `json:"target"`
+	Distance struct {
+		PHash int `json:"phash"`
+		DHash int `json:"dhash"`
+	} `json:"distance"`
+}
+
+// PerceptualHashImage returns the pHash and dHash of the image as JSON.
+func PerceptualHashImage(buf []byte) (Image, error) {
+	phash, dhash, err := perceptualHashes(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	body, _ := json.Marshal(ImagePerceptualHash{formatHash(phash), formatHash(dhash)})
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+// ComparePerceptualHashImage returns the hashes of the two images and their Hamming distances as JSON.
+func ComparePerceptualHashImage(source, target []byte) (Image, error) {
+	sourcePHash, sourceDHash, err := perceptualHashes(source)
+	if err != nil {
+		return Image{}, err
+	}
+	targetPHash, targetDHash, err := perceptualHashes(target)
+	if err != nil {
+		return Image{}, err
+	}
+
+	result := ImagePerceptualHashComparison{
+		Source: ImagePerceptualHash{formatHash(sourcePHash), formatHash(sourceDHash)},
+		Target: ImagePerceptualHash{formatHash(targetPHash), formatHash(targetDHash)},
+	}
+	result.Distance.PHash = HammingDistance(sourcePHash, targetPHash)
+	result.Distance.DHash = HammingDistance(sourceDHash, targetDHash)
+
+	body, _ := json.Marshal(result)
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+func perceptualHashes(buf []byte) (uint64, uint64, error) {
+	sample, err := SampleImage(buf, perceptualHashSampleSize, perceptualHashSampleSize)
+	if err != nil {
+		return 0, 0, err
+	}
+	return PHash(sample), DHash(sample), nil
+}
+
+// HammingDistance returns the number of the different bits of the two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+func formatHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// DHash returns the difference hash: each bit represents whether the luminance
+// increases between horizontally adjacent cells of 9x8 grid.
+func DHash(img image.Image) uint64 {
+	grid := luminanceGrid(img, 9, 8)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if grid[y][x] < grid[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// PHash returns the DCT based perceptual hash: each bit represents whether
+// the lowest 8x8 frequencies of 32x32 grid are above their median.
+func PHash(img image.Image) uint64 {
+	const size = 32
+	grid := luminanceGrid(img, size, size)
+
+	// 2D DCT-II, only the lowest 8x8 frequencies are needed
+	cos := make([][]float64, 8)
+	for u := range cos {
+		cos[u] = make([]float64, size)
+		for x := 0; x < size; x++ {
+			cos[u][x] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * size))
+		}
+	}
+	coefs := make([]float64, 0, 64)
+	for v := 0; v < 8; v++ {
+		for u := 0; u < 8; u++ {
+			sum := 0.0
+			for y := 0; y < size; y++ {
+				for x := 0; x < size; x++ {
+					sum += grid[y][x] * cos[u][x] * cos[v][y]
+				}
+			}
+			coefs = append(coefs, sum)
+		}
+	}
+
+	// The DC coefficient is excluded from the median since it's far from the others
+	sorted := append([]float64{}, coefs[1:]...)
+	sort.Float64s(sorted)
+	median := (sorted[len(sorted)/2-1] + sorted[len(sorted)/2]) / 2
+
+	var hash uint64
+	for _, c := range coefs {
+		hash <<= 1
+		if c > median {
+			hash |= 1
+		}
+	}
+	return hash
+}
+
+// luminanceGrid returns the average luminance of each cell of the image divided into w x h grid.
+func luminanceGrid(img image.Image, w, h int) [][]float64 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// cellRange returns the pixel range of the cell, at least 1 pixel even if the image is smaller than the grid
+	cellRange := func(i, cells, pixels int) (int, int) {
+		start := i * pixels / cells
+		end := (i + 1) * pixels / cells
+		if start >= pixels {
+			start = pixels - 1
+		}
+		if end <= start {
+			end = start + 1
+		}
+		return start, end
+	}
+
+	grid := make([][]float64, h)
+	for gy := range grid {
+		grid[gy] = make([]float64, w)
+		y0, y1 := cellRange(gy, h, height)
+		for gx := range grid[gy] {
+			x0, x1 := cellRange(gx, w, width)
+			sum := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					r, g, b, _ := pixelRGBA(img, bounds.Min.X+x, bounds.Min.Y+y)
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+				}
+			}
+			grid[gy][gx] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	return grid
+}
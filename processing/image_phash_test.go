@@ -0,0 +1,99 @@
+package processing
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"testing"
+)
+
+func newTestGradientImage(width, height int, reverse bool) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := uint8(x * 255 / (width - 1))
+			if reverse {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{v})
+		}
+	}
+	return img
+}
+
+func TestDHash(t *testing.T) {
+	if hash := DHash(newTestGradientImage(64, 48, false)); hash != 0xFFFFFFFFFFFFFFFF {
+		t.Errorf("Invalid dHash of increasing gradient: %016x", hash)
+	}
+	if hash := DHash(newTestGradientImage(64, 48, true)); hash != 0 {
+		t.Errorf("Invalid dHash of decreasing gradient: %016x", hash)
+	}
+}
+
+func newTestWaveImage(width, height int, phase float64) image.Image {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			v := math.Sin(float64(x)/float64(width)*3*math.Pi+phase) * math.Cos(float64(y)/float64(height)*2*math.Pi)
+			img.SetGray(x, y, color.Gray{uint8(128 + 127*v)})
+		}
+	}
+	return img
+}
+
+func TestPHash(t *testing.T) {
+	a := PHash(newTestWaveImage(64, 48, 0))
+	b := PHash(newTestWaveImage(32, 24, 0))
+	c := PHash(newTestWaveImage(64, 48, math.Pi))
+
+	if d := HammingDistance(a, b); d > 4 {
+		t.Errorf("Similar images must have close pHash: distance %d", d)
+	}
+	if d := HammingDistance(a, c); d < 8 {
+		t.Errorf("Different images must have distant pHash: distance %d", d)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	cases := []struct {
+		a, b     uint64
+		expected int
+	}{
+		{0, 0, 0},
+		{0xFF, 0, 8},
+		{0xF0F0, 0x0FF0, 8},
+		{0xFFFFFFFFFFFFFFFF, 0, 64},
+	}
+
+	for _, c := range cases {
+		if d := HammingDistance(c.a, c.b); d != c.expected {
+			t.Errorf("Invalid Hamming distance of %x and %x: expected %d, but actual %d", c.a, c.b, c.expected, d)
+		}
+	}
+}
+
+func TestComparePerceptualHashImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+	resized, err := ConvertImage(buf, ImageOptions{Width: 480, Height: 270, ResizeMode: ResizeModeFit})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+
+	img, err := ComparePerceptualHashImage(buf, resized.Body)
+	if err != nil {
+		t.Fatalf("Cannot compare images: %s", err)
+	}
+
+	var result ImagePerceptualHashComparison
+	if err := json.Unmarshal(img.Body, &result); err != nil {
+		t.Fatalf("Invalid JSON: %s", err)
+	}
+	if len(result.Source.PHash) != 16 || len(result.Target.DHash) != 16 {
+		t.Errorf("Invalid hashes: %+v", result)
+	}
+	if result.Distance.PHash > 10 || result.Distance.DHash > 10 {
+		t.Errorf("Resized image must be similar: %+v", result.Distance)
+	}
+}
@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	blurHashSampleSize  = 32
+	thumbHashSampleSize = 100
+
+	dataURIDefaultSize    = 32
+	dataURIMaxSize        = 128
+	dataURIDefaultQuality = 40
+	dataURIBlurSigma      = 1.0
+)
+
+// ImagePlaceholder represents the JSON output of the placeholder hash with the image size
+type ImagePlaceholder struct {
+	Hash   string `json:"hash"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// BlurHashImage returns the BlurHash of the transformed image as text or JSON.
+func BlurHashImage(buf []byte, o ImageOptions) (Image, error) {
+	return placeholderHashImage(buf, o, blurHashSampleSize, func(img image.Image) string {
+		// 4x3 components for landscape, 3x4 for portrait
+		bounds := img.Bounds()
+		if bounds.Dx() >= bounds.Dy() {
+			return EncodeBlurHash(img, 4, 3)
+		}
+		return EncodeBlurHash(img, 3, 4)
+	})
+}
+
+// ThumbHashImage returns the base64 encoded ThumbHash of the transformed image as text or JSON.
+func ThumbHashImage(buf []byte, o ImageOptions) (Image, error) {
+	return placeholderHashImage(buf, o, thumbHashSampleSize, func(img image.Image) string {
+		return base64.StdEncoding.EncodeToString(EncodeThumbHash(img))
+	})
+}
+
+func placeholderHashImage(buf []byte, o ImageOptions, sampleSize int, encode func(image.Image) string) (Image, error) {
+	buf, err := transformSampleSource(buf, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	sample, err := SampleImage(buf, sampleSize, sampleSize)
+	if err != nil {
+		return Image{}, err
+	}
+	hash := encode(sample)
+
+	if !o.JSONResponse {
+		return Image{Body: []byte(hash), Mime: "text/plain; charset=utf-8"}, nil
+	}
+
+	width, height, err := OrientedImageSize(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	body, _ := json.Marshal(ImagePlaceholder{Hash: hash, Width: width, Height: height})
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+// DataURIImage returns the base64 data URI of a reduced and blurred copy of the image as text,
+// so that it can be inlined in HTML as a low quality image placeholder.
+// The size is configurable by w and h params (up to 128px), the quality by q param.
+func DataURIImage(buf []byte, o ImageOptions) (Image, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return Image{}, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	if o.Width == 0 && o.Height == 0 {
+		o.Width = dataURIDefaultSize
+		o.Height = dataURIDefaultSize
+		o.ResizeMode = ResizeModeFit
+	}
+	if o.Width > dataURIMaxSize || o.Height > dataURIMaxSize {
+		return Image{}, NewError(fmt.Sprintf("The data URI image size(%dx%d) is exceed maximum size(%dx%d)", o.Width, o.Height, dataURIMaxSize, dataURIMaxSize), BadRequest)
+	}
+	if o.Quality == 0 {
+		o.Quality = dataURIDefaultQuality
+	}
+
+	// JPEG is the smallest, but PNG is required to keep the transparency
+	o.OutputFormat = "jpeg"
+	if meta.Alpha {
+		o.OutputFormat = "png"
+	}
+	o.BlurSigma = dataURIBlurSigma
+	o.NoAnimation = true
+	o.MetadataMode = MetadataModeStrip
+
+	out, err := ConvertImage(buf, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	uri := "data:" + out.Mime + ";base64," + base64.StdEncoding.EncodeToString(out.Body)
+	return Image{Body: []byte(uri), Mime: "text/plain; charset=utf-8"}, nil
+}
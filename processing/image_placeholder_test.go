@@ -0,0 +1,137 @@
+package processing
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func newTestSolidImage(width, height int, c color.Color) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestEncodeBlurHash(t *testing.T) {
+	hash := EncodeBlurHash(newTestSolidImage(32, 24, color.Black), 4, 3)
+	if expected := "L00000" + strings.Repeat("fQ", 11); hash != expected {
+		t.Errorf("Invalid BlurHash: expected %s, but actual %s", expected, hash)
+	}
+
+	// The average colour is encoded in 3rd to 6th characters
+	hash = EncodeBlurHash(newTestSolidImage(24, 32, color.NRGBA{128, 128, 128, 255}), 3, 4)
+	if len(hash) != 28 || hash[0] != 'T' || hash[2:6] != "Eyb[" {
+		t.Errorf("Invalid BlurHash: %s", hash)
+	}
+}
+
+func TestEncodeThumbHash(t *testing.T) {
+	cases := []struct {
+		width    int
+		height   int
+		color    color.Color
+		size     int
+		hasAlpha bool
+	}{
+		{32, 16, color.NRGBA{200, 100, 50, 255}, 19, false},
+		{16, 32, color.NRGBA{200, 100, 50, 255}, 19, false},
+		{32, 32, color.NRGBA{200, 100, 50, 0}, 25, true},
+	}
+
+	for _, c := range cases {
+		hash := EncodeThumbHash(newTestSolidImage(c.width, c.height, c.color))
+		if len(hash) != c.size {
+			t.Errorf("Invalid ThumbHash size of %dx%d: expected %d, but actual %d", c.width, c.height, c.size, len(hash))
+		}
+		if (hash[2]&0x80 != 0) != c.hasAlpha {
+			t.Errorf("Invalid ThumbHash alpha flag: %v", hash)
+		}
+		if (hash[4]&0x80 != 0) != (c.width > c.height) {
+			t.Errorf("Invalid ThumbHash landscape flag: %v", hash)
+		}
+	}
+}
+
+func TestBlurHashImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := BlurHashImage(buf, ImageOptions{})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if !strings.HasPrefix(img.Mime, "text/plain") || len(img.Body) != 28 {
+		t.Errorf("Invalid BlurHash response: %s %s", img.Mime, img.Body)
+	}
+
+	img, err = BlurHashImage(buf, ImageOptions{JSONResponse: true})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	var placeholder ImagePlaceholder
+	if err := json.Unmarshal(img.Body, &placeholder); err != nil {
+		t.Fatalf("Invalid JSON: %s", err)
+	}
+	if placeholder.Width != 1920 || placeholder.Height != 1080 || len(placeholder.Hash) != 28 {
+		t.Errorf("Invalid BlurHash JSON: %+v", placeholder)
+	}
+}
+
+func TestThumbHashImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := ThumbHashImage(buf, ImageOptions{Width: 200, Height: 200, ResizeMode: ResizeModeCrop})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	hash, err := base64.StdEncoding.DecodeString(string(img.Body))
+	if err != nil || len(hash) < 5 {
+		t.Errorf("Invalid ThumbHash response: %s", img.Body)
+	}
+}
+
+func TestDataURIImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	cases := []struct {
+		opts   ImageOptions
+		width  int
+		height int
+	}{
+		{ImageOptions{}, 32, 18},
+		{ImageOptions{Width: 64, Height: 64, ResizeMode: ResizeModeCrop, Quality: 20}, 64, 64},
+	}
+
+	for _, c := range cases {
+		img, err := DataURIImage(buf, c.opts)
+		if err != nil {
+			t.Fatalf("Cannot process image: %s", err)
+		}
+		if !strings.HasPrefix(img.Mime, "text/plain") {
+			t.Errorf("Invalid MIME type: %s", img.Mime)
+		}
+
+		const prefix = "data:image/jpeg;base64,"
+		if !strings.HasPrefix(string(img.Body), prefix) {
+			t.Fatalf("Invalid data URI: %s", img.Body)
+		}
+		data, err := base64.StdEncoding.DecodeString(string(img.Body[len(prefix):]))
+		if err != nil {
+			t.Fatalf("Invalid base64 data: %s", err)
+		}
+		if err := assertSize(data, c.width, c.height); err != nil {
+			t.Error(err)
+		}
+	}
+
+	if _, err := DataURIImage(buf, ImageOptions{Width: 300}); err == nil {
+		t.Error("Large data URI image must be rejected")
+	}
+}
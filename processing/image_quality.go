@@ -0,0 +1,179 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/png"
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// PSNR of the identical images is reported as this value instead of infinity
+const maxPSNR = 100
+
+// ImageQuality represents the quality loss and the byte savings of the converted image
+type ImageQuality struct {
+	Version      int     `json:"version"`
+	Mime         string  `json:"mime"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+	SSIM         float64 `json:"ssim"`
+	PSNR         float64 `json:"psnr"`
+	SourceSize   int     `json:"sourceSize"`
+	OutputSize   int     `json:"outputSize"`
+	SavedBytes   int     `json:"savedBytes"`
+	SavingsRatio float64 `json:"savingsRatio"`
+}
+
+// QualityImage converts the image by ConvertImage and returns SSIM/PSNR of the output
+// against the lossless conversion with the same geometry, and the byte savings as JSON.
+// Overlay image and animation are not taken into account.
+func QualityImage(buf []byte, o ImageOptions) (Image, error) {
+	o.NoAnimation = true
+	o.OverlayURL = ""
+
+	out, err := ConvertImage(buf, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	// The reference has the same geometry as the output without the lossy compression
+	ref := o
+	ref.OutputFormat = "png"
+	ref.Quality = 0
+	reference, err := ConvertImage(buf, ref)
+	if err != nil {
+		return Image{}, err
+	}
+
+	outImg, err := decodeAsPNG(out.Body)
+	if err != nil {
+		return Image{}, err
+	}
+	refImg, err := decodeAsPNG(reference.Body)
+	if err != nil {
+		return Image{}, err
+	}
+	if outImg.Bounds().Size() != refImg.Bounds().Size() {
+		return Image{}, NewError("Cannot compare the images of different sizes", BadRequest)
+	}
+
+	quality := ImageQuality{
+		Version:    1,
+		Mime:       out.Mime,
+		Width:      outImg.Bounds().Dx(),
+		Height:     outImg.Bounds().Dy(),
+		SSIM:       ToFixed(SSIM(refImg, outImg), 6),
+		PSNR:       ToFixed(PSNR(refImg, outImg), 4),
+		SourceSize: len(buf),
+		OutputSize: len(out.Body),
+		SavedBytes: len(buf) - len(out.Body),
+	}
+	if len(buf) > 0 {
+		quality.SavingsRatio = ToFixed(float64(quality.SavedBytes)/float64(len(buf)), 4)
+	}
+
+	body, _ := json.Marshal(quality)
+	return Image{Body: body, Mime: "application/json"}, nil
+}
+
+// decodeAsPNG decodes the image of any format supported by libvips through PNG
+func decodeAsPNG(buf []byte) (image.Image, error) {
+	if bimg.DetermineImageType(buf) != bimg.PNG {
+		out, err := Process(buf, bimg.Options{Type: bimg.PNG, NoAutoRotate: true})
+		if err != nil {
+			return nil, err
+		}
+		buf = out.Body
+	}
+	return png.Decode(bytes.NewReader(buf))
+}
+
+// PSNR returns the peak signal-to-noise ratio in dB of RGB channels of the two images of the same size.
+func PSNR(a, b image.Image) float64 {
+	bounds := a.Bounds()
+	var sum float64
+	for y := 0; y < bounds.Dy(); y++ {
+		for x := 0; x < bounds.Dx(); x++ {
+			ar, ag, ab, _ := pixelRGBA(a, bounds.Min.X+x, bounds.Min.Y+y)
+			br, bg, bb, _ := pixelRGBA(b, b.Bounds().Min.X+x, b.Bounds().Min.Y+y)
+			dr, dg, db := float64(ar)-float64(br), float64(ag)-float64(bg), float64(ab)-float64(bb)
+			sum += dr*dr + dg*dg + db*db
+		}
+	}
+
+	mse := sum / float64(bounds.Dx()*bounds.Dy()*3)
+	if mse == 0 {
+		return maxPSNR
+	}
+	return math.Min(maxPSNR, 10*math.Log10(255*255/mse))
+}
+
+// SSIM returns the mean structural similarity index of the luminance of the two images of the same size,
+// computed on 8x8 windows with stride 4.
+func SSIM(a, b image.Image) float64 {
+	const (
+		window = 8
+		stride = 4
+		c1     = (0.01 * 255) * (0.01 * 255)
+		c2     = (0.03 * 255) * (0.03 * 255)
+	)
+
+	la, lb := luminancePlane(a), luminancePlane(b)
+	width, height := a.Bounds().Dx(), a.Bounds().Dy()
+
+	// Small images are compared as a single window
+	ww, wh := window, window
+	if width < ww {
+		ww = width
+	}
+	if height < wh {
+		wh = height
+	}
+
+	var total float64
+	windows := 0
+	for y := 0; y+wh <= height; y += stride {
+		for x := 0; x+ww <= width; x += stride {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for j := y; j < y+wh; j++ {
+				for i := x; i < x+ww; i++ {
+					va, vb := la[j*width+i], lb[j*width+i]
+					sumA += va
+					sumB += vb
+					sumAA += va * va
+					sumBB += vb * vb
+					sumAB += va * vb
+				}
+			}
+
+			n := float64(ww * wh)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) / ((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+
+	if windows == 0 {
+		return 1
+	}
+	return total / float64(windows)
+}
+
+func luminancePlane(img image.Image) []float64 {
+	bounds := img.Bounds()
+	plane := make([]float64, 0, bounds.Dx()*bounds.Dy())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := pixelRGBA(img, x, y)
+			plane = append(plane, 0.299*float64(r)+0.587*float64(g)+0.114*float64(b))
+		}
+	}
+	return plane
+}
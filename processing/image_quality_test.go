@@ -0,0 +1,66 @@
+package processing
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSSIMAndPSNR(t *testing.T) {
+	a := newTestWaveImage(64, 48, 0)
+
+	if v := SSIM(a, a); v < 0.9999 {
+		t.Errorf("SSIM of identical images must be 1: %f", v)
+	}
+	if v := PSNR(a, a); v != maxPSNR {
+		t.Errorf("PSNR of identical images must be %d: %f", maxPSNR, v)
+	}
+
+	// Add the noise to every other pixel
+	noisy := image.NewGray(a.Bounds())
+	for y := 0; y < 48; y++ {
+		for x := 0; x < 64; x++ {
+			v := a.(*image.Gray).GrayAt(x, y).Y
+			if (x+y)%2 == 0 && v < 235 {
+				v += 20
+			}
+			noisy.SetGray(x, y, color.Gray{v})
+		}
+	}
+
+	ssim, psnr := SSIM(a, noisy), PSNR(a, noisy)
+	if ssim >= 0.99 || ssim <= 0 {
+		t.Errorf("Invalid SSIM of noisy image: %f", ssim)
+	}
+	if psnr >= 30 || psnr <= 10 {
+		t.Errorf("Invalid PSNR of noisy image: %f", psnr)
+	}
+}
+
+func TestQualityImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := QualityImage(buf, ImageOptions{Width: 300, Height: 200, ResizeMode: ResizeModeCrop, Quality: 50})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if img.Mime != "application/json" {
+		t.Errorf("Invalid MIME type: %s", img.Mime)
+	}
+
+	var quality ImageQuality
+	if err := json.Unmarshal(img.Body, &quality); err != nil {
+		t.Fatalf("Invalid JSON: %s", err)
+	}
+	if quality.Width != 300 || quality.Height != 200 || quality.Mime != "image/jpeg" {
+		t.Errorf("Invalid output: %+v", quality)
+	}
+	if quality.SSIM <= 0.5 || quality.SSIM > 1 || quality.PSNR <= 20 {
+		t.Errorf("Invalid quality: %+v", quality)
+	}
+	if quality.SourceSize != len(buf) || quality.SavedBytes != quality.SourceSize-quality.OutputSize || quality.SavingsRatio <= 0 {
+		t.Errorf("Invalid byte savings: %+v", quality)
+	}
+}
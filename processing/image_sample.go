@@ -0,0 +1,80 @@
+package processing
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// SampleImage returns a downsampled copy of the image which fits in width x height,
+// decoded for the pixel analysis which is not provided by libvips.
+func SampleImage(buf []byte, width, height int) (image.Image, error) {
+	inWidth, inHeight, err := OrientedImageSize(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	// libvips forces the exact size when both of width and height are given,
+	// so the size which keeps the aspect ratio is calculated here.
+	scale := math.Min(1, math.Min(float64(width)/float64(inWidth), float64(height)/float64(inHeight)))
+	opts := bimg.Options{
+		Width:         int(math.Max(1, math.Floor(float64(inWidth)*scale+0.5))),
+		Height:        int(math.Max(1, math.Floor(float64(inHeight)*scale+0.5))),
+		Type:          bimg.PNG,
+		StripMetadata: true,
+	}
+
+	out, err := Process(buf, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return png.Decode(bytes.NewReader(out.Body))
+}
+
+// transformSampleSource applies the resize params and the image operations to the image,
+// so that the analysis reflects the transformed image.
+func transformSampleSource(buf []byte, o ImageOptions) ([]byte, error) {
+	if o.Width == 0 && o.Height == 0 {
+		return applyImageOperations(buf, o)
+	}
+
+	o.OutputFormat = "png"
+	o.NoAnimation = true
+	out, err := ConvertImage(buf, o)
+	if err != nil {
+		return nil, err
+	}
+	return out.Body, nil
+}
+
+// OrientedImageSize returns the size of the image after EXIF orientation is applied.
+func OrientedImageSize(buf []byte) (int, int, error) {
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		return 0, 0, NewError("Cannot retrieve image metadata: "+err.Error(), BadRequest)
+	}
+
+	// Orientation 5 to 8 rotate the image by 90 or 270 degrees
+	if meta.Orientation >= 5 && meta.Orientation <= 8 {
+		return meta.Size.Height, meta.Size.Width, nil
+	}
+	return meta.Size.Width, meta.Size.Height, nil
+}
+
+// pixelRGBA returns the non-premultiplied 8 bit colour of the pixel.
+func pixelRGBA(img image.Image, x, y int) (r, g, b, a uint8) {
+	r32, g32, b32, a32 := img.At(x, y).RGBA()
+	if a32 == 0 {
+		return 0, 0, 0, 0
+	}
+	if a32 < 0xFFFF {
+		r32 = r32 * 0xFFFF / a32
+		g32 = g32 * 0xFFFF / a32
+		b32 = b32 * 0xFFFF / a32
+	}
+	return uint8(r32 >> 8), uint8(g32 >> 8), uint8(b32 >> 8), uint8(a32 >> 8)
+}
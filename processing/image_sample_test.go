@@ -0,0 +1,31 @@
+package processing
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSampleImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	cases := []struct {
+		width, height  int
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{32, 32, 32, 18},
+		{64, 18, 32, 18},
+		{4000, 4000, 1920, 1080},
+	}
+
+	for _, c := range cases {
+		img, err := SampleImage(buf, c.width, c.height)
+		if err != nil {
+			t.Fatalf("Cannot sample the image: %s", err)
+		}
+		// The sample keeps the aspect ratio of the source, and is never enlarged
+		if size := img.Bounds().Size(); size.X != c.expectedWidth || size.Y != c.expectedHeight {
+			t.Errorf("Invalid sample size of %dx%d: expected %dx%d, but actual %dx%d", c.width, c.height, c.expectedWidth, c.expectedHeight, size.X, size.Y)
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	spriteMaxTiles = 100
+	// The maximum number of the tiles which are fetched or resized at once
+	SpriteConcurrency = 8
+)
+
+// SpriteMap represents the position of each tile in the sprite sheet
+type SpriteMap struct {
+	Version int          `json:"version"`
+	Width   int          `json:"width"`
+	Height  int          `json:"height"`
+	Tiles   []SpriteTile `json:"tiles"`
+}
+
+type SpriteTile struct {
+	Path   string `json:"path"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// ValidateSpriteOptions checks the grid spec of the sprite sheet before the source images are fetched.
+// The area of the sprite sheet is limited by maxOutputMP unless it is 0.
+func ValidateSpriteOptions(o ImageOptions, tiles int, maxOutputMP int) error {
+	if o.Width == 0 || o.Height == 0 {
+		return fmt.Errorf("Missing required params: height, width")
+	}
+	if tiles > spriteMaxTiles {
+		return fmt.Errorf("The number of sprite tiles(%d) is exceed maximum tiles(%d)", tiles, spriteMaxTiles)
+	}
+
+	width, height := spriteSheetSize(o, tiles)
+	if maxOutputMP > 0 && width*height > maxOutputMP*1000000 {
+		return fmt.Errorf("The output image area(%dx%d) is exceed maximum area(%dMP)", width, height, maxOutputMP)
+	}
+	return nil
+}
+
+// spriteColumns returns the number of columns, which is the smallest square grid by default
+func spriteColumns(o ImageOptions, tiles int) int {
+	if o.SpriteColumns > 0 {
+		return o.SpriteColumns
+	}
+	return int(math.Ceil(math.Sqrt(float64(tiles))))
+}
+
+func spriteSheetSize(o ImageOptions, tiles int) (int, int) {
+	cols := spriteColumns(o, tiles)
+	rows := (tiles + cols - 1) / cols
+	if tiles < cols {
+		cols = tiles
+	}
+	return cols*o.Width + (cols-1)*o.SpriteGap, rows*o.Height + (rows-1)*o.SpriteGap
+}
+
+// SpriteImage resizes each image into w x h cell of the grid and composites them into a sprite sheet.
+// It returns the sheet image and the position of each tile.
+func SpriteImage(bufs [][]byte, paths []string, o ImageOptions) (Image, SpriteMap, error) {
+	tileOpts := o
+	tileOpts.OutputFormat = "png"
+	tileOpts.NoAnimation = true
+	tileOpts.Background = nil
+
+	// Resize the tiles concurrently
+	tiles := make([]image.Image, len(bufs))
+	errs := make([]error, len(bufs))
+	ForEachConcurrently(len(bufs), SpriteConcurrency, func(i int) {
+		out, err := ConvertImage(bufs[i], tileOpts)
+		if err == nil {
+			tiles[i], err = png.Decode(bytes.NewReader(out.Body))
+		}
+		errs[i] = err
+	})
+	for i, err := range errs {
+		if err != nil {
+			return Image{}, SpriteMap{}, fmt.Errorf("Cannot process the tile %s: %s", paths[i], err)
+		}
+	}
+
+	width, height := spriteSheetSize(o, len(tiles))
+	sheet := image.NewNRGBA(image.Rect(0, 0, width, height))
+	if len(o.Background) != 0 {
+		bg := color.NRGBA{o.Background[0], o.Background[1], o.Background[2], 255}
+		draw.Draw(sheet, sheet.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+
+	sprite := SpriteMap{Version: 1, Width: width, Height: height, Tiles: make([]SpriteTile, len(tiles))}
+	cols := spriteColumns(o, len(tiles))
+	for i, tile := range tiles {
+		size := tile.Bounds().Size()
+		// Larger tile is cropped to the cell from its centre
+		src := tile.Bounds().Min
+		if size.X > o.Width {
+			src.X += (size.X - o.Width) / 2
+			size.X = o.Width
+		}
+		if size.Y > o.Height {
+			src.Y += (size.Y - o.Height) / 2
+			size.Y = o.Height
+		}
+
+		// Smaller tile (e.g. fit mode) is centred in the cell
+		x := (i%cols)*(o.Width+o.SpriteGap) + (o.Width-size.X)/2
+		y := (i/cols)*(o.Height+o.SpriteGap) + (o.Height-size.Y)/2
+		rect := image.Rect(x, y, x+size.X, y+size.Y)
+		draw.Draw(sheet, rect, tile, src, draw.Over)
+
+		sprite.Tiles[i] = SpriteTile{Path: paths[i], X: x, Y: y, Width: size.X, Height: size.Y}
+	}
+
+	var buf bytes.Buffer
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(&buf, sheet); err != nil {
+		return Image{}, SpriteMap{}, err
+	}
+
+	// Encode the sheet by libvips in the requested format, PNG by default to keep the transparency
+	opts := bimg.Options{
+		Type:          ImageType(o.OutputFormat),
+		Quality:       o.Quality,
+		StripMetadata: true,
+	}
+	if opts.Type == bimg.UNKNOWN {
+		opts.Type = bimg.PNG
+	}
+	out, err := Process(buf.Bytes(), opts)
+	if err != nil {
+		return Image{}, SpriteMap{}, err
+	}
+
+	return out, sprite, nil
+}
+
+// JSON returns the sprite map as JSON
+func (s SpriteMap) JSON() []byte {
+	buf, _ := json.Marshal(s)
+	return buf
+}
+
+// ForEachConcurrently calls fn for each index from 0 to n-1 by the workers at most,
+// and waits for all of them.
+func ForEachConcurrently(n, workers int, fn func(i int)) {
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
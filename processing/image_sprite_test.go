@@ -0,0 +1,101 @@
+package processing
+
+import (
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+func TestSpriteImage(t *testing.T) {
+	paths := []string{"large.jpg", "thumbnary.jpg", "animated.gif"}
+	bufs := make([][]byte, len(paths))
+	for i, p := range paths {
+		bufs[i], _ = ioutil.ReadAll(readFile(p))
+	}
+
+	opts := ImageOptions{Width: 50, Height: 40, ResizeMode: ResizeModeCrop, SpriteColumns: 2, SpriteGap: 2}
+	img, sprite, err := SpriteImage(bufs, paths, opts)
+	if err != nil {
+		t.Fatalf("Cannot process sprite: %s", err)
+	}
+	if img.Mime != "image/png" {
+		t.Errorf("Invalid MIME type: %s", img.Mime)
+	}
+	if err := assertSize(img.Body, 102, 82); err != nil {
+		t.Error(err)
+	}
+	if sprite.Width != 102 || sprite.Height != 82 || len(sprite.Tiles) != 3 {
+		t.Fatalf("Invalid sprite map: %+v", sprite)
+	}
+
+	expected := []SpriteTile{
+		{"large.jpg", 0, 0, 50, 40},
+		{"thumbnary.jpg", 52, 0, 50, 40},
+		{"animated.gif", 0, 42, 50, 40},
+	}
+	for i, tile := range expected {
+		if sprite.Tiles[i] != tile {
+			t.Errorf("Invalid tile: expected %+v, but actual %+v", tile, sprite.Tiles[i])
+		}
+	}
+
+	opts.OutputFormat = "jpeg"
+	img, _, err = SpriteImage(bufs, paths, opts)
+	if err != nil || bimg.DetermineImageTypeName(img.Body) != "jpeg" {
+		t.Errorf("Invalid sprite image type: %s", err)
+	}
+}
+
+func TestValidateSpriteOptions(t *testing.T) {
+	cases := []struct {
+		opts  ImageOptions
+		tiles int
+		valid bool
+	}{
+		{ImageOptions{Width: 100, Height: 100}, 4, true},
+		{ImageOptions{Width: 100}, 4, false},
+		{ImageOptions{Width: 100, Height: 100}, spriteMaxTiles + 1, false},
+		{ImageOptions{Width: 1000, Height: 1000, SpriteColumns: 3}, 9, false},
+	}
+
+	for _, c := range cases {
+		err := ValidateSpriteOptions(c.opts, c.tiles, 4)
+		if (err == nil) != c.valid {
+			t.Errorf("Invalid validation result of %+v: %v", c.opts, err)
+		}
+	}
+}
+
+func TestForEachConcurrently(t *testing.T) {
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	done := make([]bool, 20)
+
+	ForEachConcurrently(len(done), 4, func(i int) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+		done[i] = true
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	if maxRunning > 4 {
+		t.Errorf("Too many workers: %d", maxRunning)
+	}
+	for i, d := range done {
+		if !d {
+			t.Errorf("The index %d is not processed", i)
+		}
+	}
+}
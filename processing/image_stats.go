@@ -0,0 +1,76 @@
+package processing
+
+import "encoding/json"
+
+const (
+	// Channels whose standard deviations are all below this are regarded as a solid colour
+	solidStdDevThreshold = 4.0
+	// Solid images whose means are all within this distance from black or white are regarded as blank
+	blankMeanThreshold = 8.0
+)
+
+var statsChannelNames = map[int][]string{
+	1: {"gray"},
+	2: {"gray", "alpha"},
+	3: {"red", "green", "blue"},
+	4: {"red", "green", "blue", "alpha"},
+}
+
+// ImageStats represents the output of f=stats
+type ImageStats struct {
+	Version  int            `json:"version"`
+	Channels []ChannelStats `json:"channels"`
+	Solid    bool           `json:"solid"`
+	Blank    bool           `json:"blank"`
+}
+
+// ChannelStats represents the statistics of a channel of 8 bit sRGB image
+type ChannelStats struct {
+	Name      string  `json:"name"`
+	Min       float64 `json:"min"`
+	Max       float64 `json:"max"`
+	Mean      float64 `json:"mean"`
+	StdDev    float64 `json:"stddev"`
+	Histogram []int   `json:"histogram"`
+}
+
+// StatsImage returns the per channel statistics and histograms of the transformed image as JSON,
+// with the flags whether the image is mostly a solid colour, or blank (all white or all black).
+func StatsImage(buf []byte, o ImageOptions) (Image, error) {
+	buf, err := transformSampleSource(buf, o)
+	if err != nil {
+		return Image{}, err
+	}
+
+	channels, err := vipsImageStats(buf)
+	if err != nil {
+		return Image{}, NewError("Cannot retrieve image statistics: "+err.Error(), BadRequest)
+	}
+
+	names := statsChannelNames[len(channels)]
+	stats := ImageStats{Version: 1, Channels: channels, Solid: len(channels) > 0}
+	black, white, transparent := true, true, false
+	for i := range channels {
+		c := &channels[i]
+		if i < len(names) {
+			c.Name = names[i]
+		}
+		c.Mean = ToFixed(c.Mean, 4)
+		c.StdDev = ToFixed(c.StdDev, 4)
+
+		if c.StdDev >= solidStdDevThreshold {
+			stats.Solid = false
+		}
+		if c.Name == "alpha" {
+			// Fully transparent image is blank regardless of its colour
+			transparent = c.Max == 0
+			continue
+		}
+		black = black && c.Mean <= blankMeanThreshold
+		white = white && c.Mean >= 255-blankMeanThreshold
+	}
+	stats.Blank = transparent || (stats.Solid && (black || white))
+
+	body, _ := json.Marshal(stats)
+	return Image{Body: body, Mime: "application/json"}, nil
+}
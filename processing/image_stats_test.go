@@ -0,0 +1,61 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func encodeTestPNG(img image.Image) []byte {
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	return buf.Bytes()
+}
+
+func TestStatsImage(t *testing.T) {
+	cases := []struct {
+		name  string
+		img   image.Image
+		solid bool
+		blank bool
+	}{
+		{"white", newTestSolidImage(20, 10, color.White), true, true},
+		{"black", newTestSolidImage(20, 10, color.Black), true, true},
+		{"red", newTestSolidImage(20, 10, color.NRGBA{255, 0, 0, 255}), true, false},
+		{"wave", newTestWaveImage(20, 10, 0), false, false},
+	}
+
+	for _, c := range cases {
+		img, err := StatsImage(encodeTestPNG(c.img), ImageOptions{})
+		if err != nil {
+			t.Fatalf("Cannot process image %s: %s", c.name, err)
+		}
+
+		var stats ImageStats
+		if err := json.Unmarshal(img.Body, &stats); err != nil {
+			t.Fatalf("Invalid JSON: %s", err)
+		}
+		if stats.Solid != c.solid || stats.Blank != c.blank {
+			t.Errorf("Invalid flags of %s image: solid=%v blank=%v", c.name, stats.Solid, stats.Blank)
+		}
+		if len(stats.Channels) < 3 || stats.Channels[0].Name != "red" {
+			t.Fatalf("Invalid channels of %s image: %+v", c.name, stats.Channels)
+		}
+
+		for _, ch := range stats.Channels {
+			total := 0
+			for _, n := range ch.Histogram {
+				total += n
+			}
+			if len(ch.Histogram) != 256 || total != 200 {
+				t.Errorf("Invalid histogram of %s image: %s has %d pixels", c.name, ch.Name, total)
+			}
+			if ch.Min > ch.Mean || ch.Mean > ch.Max {
+				t.Errorf("Invalid statistics of %s image: %+v", c.name, ch)
+			}
+		}
+	}
+}
@@ -1,8 +1,14 @@
-package main
+package processing
 
 import (
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
+	"path"
 	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
 )
 
 func TestImageResize(t *testing.T) {
@@ -65,3 +71,19 @@ func TestImageNoConvert(t *testing.T) {
 		t.Errorf(err.Error())
 	}
 }
+
+func readFile(file string) io.Reader {
+	buf, _ := os.Open(path.Join("../testdata", file))
+	return buf
+}
+
+func assertSize(buf []byte, width, height int) error {
+	size, err := bimg.NewImage(buf).Size()
+	if err != nil {
+		return err
+	}
+	if size.Width != width || size.Height != height {
+		return fmt.Errorf("Invalid image size: exprected %dx%d, but actual %dx%d", width, height, size.Width, size.Height)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package processing
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const (
+	tileSize          = 256
+	deepZoomNamespace = "http://schemas.microsoft.com/deepzoom/2008"
+)
+
+// DeepZoomImage represents the .dzi descriptor of the tile pyramid
+type DeepZoomImage struct {
+	XMLName  xml.Name     `xml:"Image"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Format   string       `xml:"Format,attr"`
+	Overlap  int          `xml:"Overlap,attr"`
+	TileSize int          `xml:"TileSize,attr"`
+	Size     DeepZoomSize `xml:"Size"`
+}
+
+type DeepZoomSize struct {
+	Width  int `xml:"Width,attr"`
+	Height int `xml:"Height,attr"`
+}
+
+// tileMaxLevel returns the level of the full resolution image.
+// The image of level 0 is 1x1 and the size is doubled at each level.
+func tileMaxLevel(width, height int) int {
+	size := width
+	if height > size {
+		size = height
+	}
+	return int(math.Ceil(math.Log2(float64(size))))
+}
+
+// tileLevelSize returns the image size at the level
+func tileLevelSize(width, height, level int) (int, int) {
+	scale := math.Pow(2, float64(tileMaxLevel(width, height)-level))
+	return int(math.Ceil(float64(width) / scale)), int(math.Ceil(float64(height) / scale))
+}
+
+// tileFormat returns the output format of the tiles, PNG is used to keep the transparency.
+func tileFormat(buf []byte) string {
+	meta, err := bimg.Metadata(buf)
+	if err == nil && meta.Alpha {
+		return "png"
+	}
+	return "jpeg"
+}
+
+// DeepZoomDescriptorImage returns the .dzi descriptor of the image
+func DeepZoomDescriptorImage(buf []byte) (Image, error) {
+	width, height, err := OrientedImageSize(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	format := tileFormat(buf)
+	if format == "jpeg" {
+		format = "jpg"
+	}
+
+	dzi := DeepZoomImage{
+		XMLNS:    deepZoomNamespace,
+		Format:   format,
+		TileSize: tileSize,
+		Size:     DeepZoomSize{Width: width, Height: height},
+	}
+	body, _ := xml.Marshal(dzi)
+	return Image{Body: append([]byte(xml.Header), body...), Mime: "application/xml"}, nil
+}
+
+// TileImage extracts the tile at the column x and the row y of the level z from the image.
+func TileImage(buf []byte, z, x, y int, o ImageOptions) (Image, error) {
+	width, height, err := OrientedImageSize(buf)
+	if err != nil {
+		return Image{}, err
+	}
+
+	maxLevel := tileMaxLevel(width, height)
+	if z < 0 || z > maxLevel {
+		return Image{}, NewError(fmt.Sprintf("Tile level is out of range: %d (max=%d)", z, maxLevel), BadRequest)
+	}
+
+	levelWidth, levelHeight := tileLevelSize(width, height, z)
+	tx, ty := x*tileSize, y*tileSize
+	if x < 0 || y < 0 || tx >= levelWidth || ty >= levelHeight {
+		return Image{}, NewError(fmt.Sprintf("Tile is out of range: %d/%d/%d", z, x, y), BadRequest)
+	}
+	tw, th := MinInt(tileSize, levelWidth-tx), MinInt(tileSize, levelHeight-ty)
+
+	// Map the tile area onto the source image
+	scale := math.Pow(2, float64(maxLevel-z))
+	x1, y1 := int(float64(tx)*scale), int(float64(ty)*scale)
+	x2, y2 := MinInt(width, int(float64(tx+tw)*scale)), MinInt(height, int(float64(ty+th)*scale))
+
+	o.Clip = []int{x1, y1, x2, y2}
+	o.Width = tw
+	o.Height = th
+	o.Force = true
+	o.ResizeMode = ResizeModeScale
+	o.OutputFormat = tileFormat(buf)
+
+	return ConvertImage(buf, o)
+}
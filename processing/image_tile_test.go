@@ -0,0 +1,80 @@
+package processing
+
+import (
+	"encoding/xml"
+	"io/ioutil"
+	"testing"
+)
+
+func TestTileLevelSize(t *testing.T) {
+	if level := tileMaxLevel(1920, 1080); level != 11 {
+		t.Errorf("Invalid max level: %d", level)
+	}
+
+	cases := []struct {
+		level         int
+		width, height int
+	}{
+		{11, 1920, 1080},
+		{10, 960, 540},
+		{8, 240, 135},
+		{1, 2, 2},
+		{0, 1, 1},
+	}
+	for _, c := range cases {
+		w, h := tileLevelSize(1920, 1080, c.level)
+		if w != c.width || h != c.height {
+			t.Errorf("Invalid level size: (level=%d) (got=%dx%d)", c.level, w, h)
+		}
+	}
+}
+
+func TestTileImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	cases := []struct {
+		z, x, y       int
+		width, height int
+	}{
+		{11, 0, 0, 256, 256},
+		{10, 3, 2, 192, 28},
+		{8, 0, 0, 240, 135},
+		{0, 0, 0, 1, 1},
+	}
+	for _, c := range cases {
+		img, err := TileImage(buf, c.z, c.x, c.y, ImageOptions{})
+		if err != nil {
+			t.Errorf("Cannot process tile %d/%d/%d: %s", c.z, c.x, c.y, err)
+			continue
+		}
+		if img.Mime != "image/jpeg" {
+			t.Errorf("Invalid MIME type: %s", img.Mime)
+		}
+		if err := assertSize(img.Body, c.width, c.height); err != nil {
+			t.Errorf("Invalid tile %d/%d/%d: %s", c.z, c.x, c.y, err)
+		}
+	}
+
+	for _, c := range [][3]int{{12, 0, 0}, {10, 4, 0}, {10, 0, 3}} {
+		if _, err := TileImage(buf, c[0], c[1], c[2], ImageOptions{}); err == nil {
+			t.Errorf("Expected error: %v", c)
+		}
+	}
+}
+
+func TestDeepZoomDescriptorImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	img, err := DeepZoomDescriptorImage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var dzi DeepZoomImage
+	if err := xml.Unmarshal(img.Body, &dzi); err != nil {
+		t.Fatal(err)
+	}
+	if dzi.Format != "jpg" || dzi.TileSize != 256 || dzi.Size.Width != 1920 || dzi.Size.Height != 1080 {
+		t.Errorf("Invalid descriptor: %+v", dzi)
+	}
+}
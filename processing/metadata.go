@@ -0,0 +1,519 @@
+package processing
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/xml"
+	"hash/crc32"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+type MetadataMode int
+
+const (
+	MetadataModeDefault   MetadataMode = 0
+	MetadataModeStrip     MetadataMode = 1
+	MetadataModeKeep      MetadataMode = 2
+	MetadataModeCopyright MetadataMode = 3
+)
+
+const (
+	xmpHeaderJPEG         = "http://ns.adobe.com/xap/1.0/\x00"
+	xmpKeywordPNG         = "XML:com.adobe.xmp"
+	dublinCoreNamespace   = "http://purl.org/dc/elements/1.1/"
+	photoshopHeaderJPEG   = "Photoshop 3.0\x00"
+	photoshopResourceIPTC = 0x0404
+)
+
+// Flags of WebP VP8X chunk
+const (
+	webpFlagAnimation = 0x02
+	webpFlagEXIF      = 0x08
+	webpFlagAlpha     = 0x10
+	webpFlagICC       = 0x20
+)
+
+// EXIF fields carried into the output image in copyright mode
+var copyrightEXIFTags = []uint16{
+	EXIFTagArtist,
+	EXIFTagCopyright,
+}
+
+// Output image types into which the copyright fields cannot be restored
+var copyrightUnsupportedTypes = map[bimg.ImageType]bool{
+	bimg.GIF:  true,
+	bimg.TIFF: true,
+}
+
+// XMP Dublin Core properties which are carried as the EXIF fields
+var xmpCopyrightTags = map[string]uint16{
+	"creator": EXIFTagArtist,
+	"rights":  EXIFTagCopyright,
+}
+
+// IPTC-IIM datasets (record << 8 | dataset) which are carried as the EXIF fields: By-line and Copyright Notice
+var iptcCopyrightTags = map[int]uint16{
+	2<<8 | 80:  EXIFTagArtist,
+	2<<8 | 116: EXIFTagCopyright,
+}
+
+// applyMetadataMode fixes up the metadata of the output image which was processed by libvips.
+//
+// strip: libvips already removed all of the metadata.
+// keep: libvips kept all of the metadata, but the orientation must be reset since the image was auto rotated.
+// copyright: libvips removed all of the metadata, only the creator and copyright fields are restored
+// from EXIF, XMP or IPTC of the source as EXIF Artist and Copyright, with the ICC profile of the source
+// unless the image is converted to the output profile. JPEG, PNG and WebP are supported.
+func applyMetadataMode(src []byte, out Image, o ImageOptions) Image {
+	switch o.MetadataMode {
+	case MetadataModeKeep:
+		// The orientation is overwritten in place, so the CRC of PNG chunk must be updated
+		if exif := ExtractEXIF(out.Body); exif != nil && setEXIFOrientation(exif, 1) && isPNGBuffer(out.Body) {
+			updatePNGChunkCRC(out.Body, "eXIf")
+		}
+	case MetadataModeCopyright:
+		var exif []byte
+		if fields := copyrightFields(src); len(fields) != 0 {
+			exif = BuildEXIF(fields)
+		}
+		// The pixels are in the colour space of the source profile unless they are converted
+		var profile []byte
+		if o.OutputICC == "" && !o.Monochrome {
+			if p := ExtractICCProfile(src); ICCProfileColorSpace(p) == "RGB" {
+				profile = p
+			}
+		}
+		if exif == nil && profile == nil {
+			break
+		}
+
+		switch {
+		case isJPEGBuffer(out.Body):
+			if profile != nil {
+				out.Body = insertJPEGICCProfile(out.Body, profile)
+			}
+			if exif != nil {
+				out.Body = insertJPEGEXIF(out.Body, exif)
+			}
+		case isPNGBuffer(out.Body):
+			if profile != nil {
+				out.Body = insertPNGICCProfile(out.Body, profile)
+			}
+			if exif != nil {
+				out.Body = insertPNGEXIF(out.Body, exif)
+			}
+		case isWebPBuffer(out.Body):
+			out.Body = insertWebPMetadata(out.Body, exif, profile)
+		}
+	}
+	return out
+}
+
+// copyrightFields returns the creator and copyright fields of the source as EXIF fields.
+// The fields of EXIF take precedence over XMP, and XMP over IPTC.
+func copyrightFields(src []byte) map[uint16]string {
+	fields := make(map[uint16]string)
+	for _, f := range []map[uint16]string{copyrightEXIFFields(src), copyrightXMPFields(ExtractXMP(src)), copyrightIPTCFields(src)} {
+		for tag, v := range f {
+			if fields[tag] == "" {
+				fields[tag] = v
+			}
+		}
+	}
+	return fields
+}
+
+func copyrightEXIFFields(src []byte) map[uint16]string {
+	raw := ExtractEXIF(src)
+	if raw == nil {
+		return nil
+	}
+	exif, err := ParseEXIF(raw)
+	if err != nil {
+		return nil
+	}
+
+	fields := make(map[uint16]string)
+	for _, tag := range copyrightEXIFTags {
+		if v := exif.Tags[tag].String(); v != "" {
+			fields[tag] = v
+		}
+	}
+	return fields
+}
+
+// ExtractXMP returns the XMP packet of JPEG, PNG or WebP image buffer.
+// It returns nil if the image has no XMP packet.
+func ExtractXMP(buf []byte) []byte {
+	var xmp []byte
+	switch {
+	case isJPEGBuffer(buf):
+		eachJPEGSegment(buf, func(marker byte, offset int, data []byte) bool {
+			if marker == jpegMarkerAPP1 && strings.HasPrefix(string(data), xmpHeaderJPEG) {
+				xmp = data[len(xmpHeaderJPEG):]
+				return false
+			}
+			return true
+		})
+	case isPNGBuffer(buf):
+		eachPNGChunk(buf, func(kind string, offset int, data []byte) bool {
+			if kind == "iTXt" && strings.HasPrefix(string(data), xmpKeywordPNG+"\x00") {
+				xmp = readPNGInternationalText(data)
+				return false
+			}
+			return kind != "IDAT"
+		})
+	case isWebPBuffer(buf):
+		eachWebPChunk(buf, func(kind string, data []byte) bool {
+			if kind == "XMP " {
+				xmp = data
+				return false
+			}
+			return true
+		})
+	}
+	return xmp
+}
+
+// readPNGInternationalText returns the text of iTXt chunk, which is
+// keyword, compression flag and method, language tag and translated keyword followed by the text.
+func readPNGInternationalText(data []byte) []byte {
+	i := bytes.IndexByte(data, 0)
+	if i < 0 || i+3 > len(data) {
+		return nil
+	}
+	compressed := data[i+1] == 1
+	rest := data[i+3:]
+	for n := 0; n < 2; n++ {
+		j := bytes.IndexByte(rest, 0)
+		if j < 0 {
+			return nil
+		}
+		rest = rest[j+1:]
+	}
+	if !compressed {
+		return rest
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil
+	}
+	defer r.Close()
+	text, _ := ioutil.ReadAll(r)
+	return text
+}
+
+// copyrightXMPFields returns the first values of dc:creator and dc:rights of the XMP packet.
+func copyrightXMPFields(xmp []byte) map[uint16]string {
+	if xmp == nil {
+		return nil
+	}
+
+	fields := make(map[uint16]string)
+	var tag uint16
+	inItem := false
+	d := xml.NewDecoder(bytes.NewReader(xmp))
+	for {
+		token, err := d.Token()
+		if err != nil {
+			break
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Space == dublinCoreNamespace {
+				tag = xmpCopyrightTags[t.Name.Local]
+			} else if tag != 0 && t.Name.Local == "li" {
+				inItem = true
+			}
+		case xml.EndElement:
+			if t.Name.Space == dublinCoreNamespace {
+				tag = 0
+			} else if t.Name.Local == "li" {
+				inItem = false
+			}
+		case xml.CharData:
+			if v := strings.TrimSpace(string(t)); tag != 0 && inItem && v != "" && fields[tag] == "" {
+				fields[tag] = v
+			}
+		}
+	}
+	return fields
+}
+
+// copyrightIPTCFields returns the By-line and Copyright Notice of the IPTC-IIM records
+// in the Photoshop APP13 segment of JPEG image buffer.
+func copyrightIPTCFields(buf []byte) map[uint16]string {
+	if !isJPEGBuffer(buf) {
+		return nil
+	}
+
+	fields := make(map[uint16]string)
+	eachJPEGSegment(buf, func(marker byte, offset int, data []byte) bool {
+		if marker != jpegMarkerAPP13 || !strings.HasPrefix(string(data), photoshopHeaderJPEG) {
+			return true
+		}
+		eachPhotoshopResource(data[len(photoshopHeaderJPEG):], func(id uint16, resource []byte) {
+			if id != photoshopResourceIPTC {
+				return
+			}
+			eachIPTCRecord(resource, func(record, dataset byte, value []byte) {
+				if tag := iptcCopyrightTags[int(record)<<8|int(dataset)]; tag != 0 && fields[tag] == "" {
+					fields[tag] = strings.TrimSpace(string(value))
+				}
+			})
+		})
+		return false
+	})
+	return fields
+}
+
+// eachPhotoshopResource calls fn for each image resource block: "8BIM", the resource ID,
+// the even padded Pascal string name and the even padded data with its size.
+func eachPhotoshopResource(data []byte, fn func(id uint16, resource []byte)) {
+	pos := 0
+	for pos+8 <= len(data) && string(data[pos:pos+4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(data[pos+4:])
+		name := 1 + int(data[pos+6])
+		name += name % 2
+		p := pos + 6 + name
+		if p+4 > len(data) {
+			return
+		}
+		size := int(binary.BigEndian.Uint32(data[p:]))
+		p += 4
+		if size < 0 || p+size > len(data) {
+			return
+		}
+		fn(id, data[p:p+size])
+		pos = p + size + size%2
+	}
+}
+
+// eachIPTCRecord calls fn for each dataset of IPTC-IIM: the tag marker, the record number,
+// the dataset number and the data with its size. The extended size is not supported.
+func eachIPTCRecord(data []byte, fn func(record, dataset byte, value []byte)) {
+	pos := 0
+	for pos+5 <= len(data) && data[pos] == 0x1C {
+		size := int(binary.BigEndian.Uint16(data[pos+3:]))
+		if size&0x8000 != 0 || pos+5+size > len(data) {
+			return
+		}
+		fn(data[pos+1], data[pos+2], data[pos+5:pos+5+size])
+		pos += 5 + size
+	}
+}
+
+// insertJPEGSegments inserts the segments of the marker just after SOI (and JFIF APP0 segment, if present).
+func insertJPEGSegments(buf []byte, marker byte, payloads ...[]byte) []byte {
+	pos := 2
+	eachJPEGSegment(buf, func(m byte, offset int, data []byte) bool {
+		if m == 0xE0 && strings.HasPrefix(string(data), "JFIF\x00") {
+			pos = offset + 4 + len(data)
+		}
+		return false
+	})
+
+	var segments []byte
+	for _, payload := range payloads {
+		if len(payload)+2 > 0xFFFF {
+			return buf
+		}
+		segments = append(segments, 0xFF, marker, 0, 0)
+		binary.BigEndian.PutUint16(segments[len(segments)-2:], uint16(len(payload)+2))
+		segments = append(segments, payload...)
+	}
+
+	out := make([]byte, 0, len(buf)+len(segments))
+	out = append(out, buf[:pos]...)
+	out = append(out, segments...)
+	return append(out, buf[pos:]...)
+}
+
+// insertJPEGEXIF inserts EXIF APP1 segment just after SOI (and JFIF APP0 segment, if present).
+func insertJPEGEXIF(buf []byte, exif []byte) []byte {
+	return insertJPEGSegments(buf, jpegMarkerAPP1, append([]byte(exifHeaderJPEG), exif...))
+}
+
+// insertJPEGICCProfile inserts the profile which is split into APP2 segments with the sequence numbers.
+func insertJPEGICCProfile(buf []byte, profile []byte) []byte {
+	chunkSize := 0xFFFF - 2 - len(iccMarkerJPEG) - 2
+	total := (len(profile) + chunkSize - 1) / chunkSize
+	if total > 255 {
+		return buf
+	}
+
+	payloads := make([][]byte, total)
+	for i := range payloads {
+		chunk := profile[i*chunkSize:]
+		if len(chunk) > chunkSize {
+			chunk = chunk[:chunkSize]
+		}
+		payloads[i] = append(append([]byte(iccMarkerJPEG), byte(i+1), byte(total)), chunk...)
+	}
+	return insertJPEGSegments(buf, jpegMarkerAPP2, payloads...)
+}
+
+// insertPNGChunk inserts the chunk just after IHDR chunk.
+func insertPNGChunk(buf []byte, kind string, data []byte) []byte {
+	pos := 0
+	eachPNGChunk(buf, func(k string, offset int, d []byte) bool {
+		if k == "IHDR" {
+			pos = offset + 12 + len(d)
+		}
+		return false
+	})
+	if pos == 0 {
+		return buf
+	}
+
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], kind)
+	chunk = append(chunk, data...)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.ChecksumIEEE(chunk[4:]))
+	chunk = append(chunk, crc...)
+
+	out := make([]byte, 0, len(buf)+len(chunk))
+	out = append(out, buf[:pos]...)
+	out = append(out, chunk...)
+	return append(out, buf[pos:]...)
+}
+
+// insertPNGEXIF inserts eXIf chunk just after IHDR chunk.
+func insertPNGEXIF(buf []byte, exif []byte) []byte {
+	return insertPNGChunk(buf, "eXIf", exif)
+}
+
+// insertPNGICCProfile inserts iCCP chunk, which has the profile name, null separator,
+// compression method and zlib compressed profile.
+func insertPNGICCProfile(buf []byte, profile []byte) []byte {
+	var data bytes.Buffer
+	data.WriteString("icc\x00\x00")
+	w := zlib.NewWriter(&data)
+	w.Write(profile)
+	w.Close()
+	return insertPNGChunk(buf, "iCCP", data.Bytes())
+}
+
+// insertWebPMetadata rebuilds the RIFF container with ICCP and EXIF chunks, which require
+// the extended format. VP8X chunk is created with the canvas size of the image, if absent.
+func insertWebPMetadata(buf []byte, exif []byte, profile []byte) []byte {
+	type chunk struct {
+		kind string
+		data []byte
+	}
+
+	var header []byte
+	var chunks []chunk
+	width, height, alpha := 0, 0, false
+	eachWebPChunk(buf, func(kind string, data []byte) bool {
+		switch kind {
+		case "VP8X":
+			header = append([]byte{}, data...)
+			return true
+		case "ICCP":
+			if profile != nil {
+				return true
+			}
+		case "EXIF":
+			if exif != nil {
+				return true
+			}
+		case "VP8 ":
+			// Frame tag(3), start code(3), 14 bits width and height
+			if len(data) >= 10 && string(data[3:6]) == "\x9d\x01\x2a" {
+				width = int(binary.LittleEndian.Uint16(data[6:]) & 0x3FFF)
+				height = int(binary.LittleEndian.Uint16(data[8:]) & 0x3FFF)
+			}
+		case "VP8L":
+			// Signature, 14 bits width - 1, 14 bits height - 1 and alpha flag
+			if len(data) >= 5 && data[0] == 0x2F {
+				bits := binary.LittleEndian.Uint32(data[1:])
+				width = int(bits&0x3FFF) + 1
+				height = int(bits>>14&0x3FFF) + 1
+				alpha = alpha || bits>>28&1 == 1
+			}
+		case "ALPH":
+			alpha = true
+		}
+		chunks = append(chunks, chunk{kind, data})
+		return true
+	})
+
+	if header == nil {
+		if width == 0 || height == 0 {
+			return buf
+		}
+		header = make([]byte, 10)
+		putUint24LE(header[4:], uint32(width-1))
+		putUint24LE(header[7:], uint32(height-1))
+		if alpha {
+			header[0] |= webpFlagAlpha
+		}
+	}
+	if len(header) < 10 {
+		return buf
+	}
+	if profile != nil {
+		header[0] |= webpFlagICC
+	}
+	if exif != nil {
+		header[0] |= webpFlagEXIF
+	}
+
+	// ICCP chunk must follow VP8X chunk, and EXIF chunk must follow the image data
+	out := append([]byte{}, "RIFF\x00\x00\x00\x00WEBP"...)
+	out = appendWebPChunk(out, "VP8X", header)
+	if profile != nil {
+		out = appendWebPChunk(out, "ICCP", profile)
+	}
+	for _, c := range chunks {
+		out = appendWebPChunk(out, c.kind, c.data)
+	}
+	if exif != nil {
+		out = appendWebPChunk(out, "EXIF", exif)
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func appendWebPChunk(buf []byte, kind string, data []byte) []byte {
+	header := make([]byte, 8)
+	copy(header, kind)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(data)))
+	buf = append(buf, header...)
+	buf = append(buf, data...)
+	// Chunks are padded to even size
+	if len(data)%2 == 1 {
+		buf = append(buf, 0)
+	}
+	return buf
+}
+
+func putUint24LE(b []byte, v uint32) {
+	b[0] = byte(v)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v >> 16)
+}
+
+func getUint24LE(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16
+}
+
+// updatePNGChunkCRC recomputes the CRC of the first chunk of the kind in place.
+func updatePNGChunkCRC(buf []byte, kind string) {
+	eachPNGChunk(buf, func(k string, offset int, data []byte) bool {
+		if k != kind {
+			return true
+		}
+		end := offset + 8 + len(data)
+		binary.BigEndian.PutUint32(buf[end:], crc32.ChecksumIEEE(buf[offset+4:end]))
+		return false
+	})
+}
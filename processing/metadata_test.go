@@ -0,0 +1,244 @@
+package processing
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func newTestJPEGWithEXIF(exif []byte) []byte {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+	return insertJPEGEXIF(buf, exif)
+}
+
+func assertCopyrightOnlyEXIF(t *testing.T, buf []byte) {
+	raw := ExtractEXIF(buf)
+	if raw == nil {
+		t.Fatal("EXIF not found in output image")
+	}
+	exif, err := ParseEXIF(raw)
+	if err != nil {
+		t.Fatalf("Cannot parse EXIF: %s", err)
+	}
+
+	if v := exif.Tags[EXIFTagArtist].String(); v != "Taro Yamada" {
+		t.Errorf("Invalid artist: %s", v)
+	}
+	if v := exif.Tags[EXIFTagCopyright].String(); v != "(c) Example Inc." {
+		t.Errorf("Invalid copyright: %s", v)
+	}
+	if _, ok := exif.Tags[EXIFTagGPSIFD]; ok || len(exif.GPSTags) != 0 {
+		t.Error("GPS data should be dropped")
+	}
+	if _, ok := exif.Tags[EXIFTagOrientation]; ok {
+		t.Error("Orientation should be dropped")
+	}
+}
+
+func TestApplyMetadataModeCopyright(t *testing.T) {
+	src := newTestJPEGWithEXIF(newTestEXIF(1))
+	out, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	image := applyMetadataMode(src, Image{Body: out, Mime: "image/jpeg"}, ImageOptions{MetadataMode: MetadataModeCopyright})
+	assertCopyrightOnlyEXIF(t, image.Body)
+
+	image = applyMetadataMode(src, Image{Body: out, Mime: "image/jpeg"}, ImageOptions{MetadataMode: MetadataModeStrip})
+	if ExtractEXIF(image.Body) != nil {
+		t.Error("EXIF should not be added in strip mode")
+	}
+}
+
+func newTestXMP(creator, rights string) []byte {
+	return []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">` +
+		`<rdf:Description xmlns:dc="http://purl.org/dc/elements/1.1/">` +
+		`<dc:creator><rdf:Seq><rdf:li>` + creator + `</rdf:li></rdf:Seq></dc:creator>` +
+		`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">` + rights + `</rdf:li></rdf:Alt></dc:rights>` +
+		`</rdf:Description></rdf:RDF></x:xmpmeta>`)
+}
+
+func newTestIPTC(byline, copyright string) []byte {
+	var iim []byte
+	for _, d := range []struct {
+		dataset byte
+		value   string
+	}{{80, byline}, {116, copyright}} {
+		iim = append(iim, 0x1C, 2, d.dataset, 0, byte(len(d.value)))
+		iim = append(iim, d.value...)
+	}
+	resource := []byte("8BIM\x04\x04\x00\x00")
+	resource = append(resource, 0, 0, 0, byte(len(iim)))
+	resource = append(resource, iim...)
+	if len(iim)%2 == 1 {
+		resource = append(resource, 0)
+	}
+	return append([]byte(photoshopHeaderJPEG), resource...)
+}
+
+func TestApplyMetadataModeCopyrightXMPAndIPTC(t *testing.T) {
+	out, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	cases := []struct {
+		description string
+		src         []byte
+	}{
+		{"XMP", insertJPEGSegments(out, jpegMarkerAPP1, append([]byte(xmpHeaderJPEG), newTestXMP("Taro Yamada", "(c) Example Inc.")...))},
+		{"IPTC", insertJPEGSegments(out, jpegMarkerAPP13, newTestIPTC("Taro Yamada", "(c) Example Inc."))},
+		{"EXIF takes precedence", insertJPEGSegments(newTestJPEGWithEXIF(newTestEXIF(1)), jpegMarkerAPP13, newTestIPTC("Someone", "Other"))},
+	}
+
+	for _, c := range cases {
+		image := applyMetadataMode(c.src, Image{Body: out, Mime: "image/jpeg"}, ImageOptions{MetadataMode: MetadataModeCopyright})
+		exif, err := ParseEXIF(ExtractEXIF(image.Body))
+		if err != nil {
+			t.Fatalf("%s: Cannot parse EXIF: %s", c.description, err)
+		}
+		if v := exif.Tags[EXIFTagArtist].String(); v != "Taro Yamada" {
+			t.Errorf("%s: Invalid artist: %s", c.description, v)
+		}
+		if v := exif.Tags[EXIFTagCopyright].String(); v != "(c) Example Inc." {
+			t.Errorf("%s: Invalid copyright: %s", c.description, v)
+		}
+	}
+}
+
+func TestApplyMetadataModeCopyrightICC(t *testing.T) {
+	out, _ := ioutil.ReadAll(readFile("large.jpg"))
+	profile := newTestICCProfile("RGB ", newTestDescTag("sRGB"))
+	src := insertJPEGICCProfile(out, profile)
+
+	cases := []struct {
+		opts     ImageOptions
+		expected bool
+	}{
+		{ImageOptions{MetadataMode: MetadataModeCopyright}, true},
+		{ImageOptions{MetadataMode: MetadataModeCopyright, OutputICC: "srgb"}, false},
+		{ImageOptions{MetadataMode: MetadataModeCopyright, Monochrome: true}, false},
+	}
+
+	for _, c := range cases {
+		image := applyMetadataMode(src, Image{Body: out, Mime: "image/jpeg"}, c.opts)
+		actual := ExtractICCProfile(image.Body)
+		if c.expected && !bytes.Equal(actual, profile) {
+			t.Errorf("The profile must be restored: %#v", c.opts)
+		}
+		if !c.expected && actual != nil {
+			t.Errorf("The profile must not be restored into the converted image: %#v", c.opts)
+		}
+	}
+}
+
+func TestApplyMetadataModeCopyrightPNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	src := insertJPEGICCProfile(newTestJPEGWithEXIF(newTestEXIF(1)), newTestICCProfile("RGB ", newTestDescTag("sRGB")))
+
+	image := applyMetadataMode(src, Image{Body: buf.Bytes(), Mime: "image/png"}, ImageOptions{MetadataMode: MetadataModeCopyright})
+	assertCopyrightOnlyEXIF(t, image.Body)
+	if !bytes.Equal(ExtractICCProfile(image.Body), newTestICCProfile("RGB ", newTestDescTag("sRGB"))) {
+		t.Error("The profile must be restored")
+	}
+	if _, err := png.Decode(bytes.NewReader(image.Body)); err != nil {
+		t.Errorf("Invalid PNG: %s", err)
+	}
+}
+
+func TestApplyMetadataModeCopyrightWebP(t *testing.T) {
+	out, _ := ioutil.ReadAll(readFile("test.webp"))
+	src := insertJPEGICCProfile(newTestJPEGWithEXIF(newTestEXIF(1)), newTestICCProfile("RGB ", newTestDescTag("sRGB")))
+
+	image := applyMetadataMode(src, Image{Body: out, Mime: "image/webp"}, ImageOptions{MetadataMode: MetadataModeCopyright})
+	assertCopyrightOnlyEXIF(t, image.Body)
+	if !bytes.Equal(ExtractICCProfile(image.Body), newTestICCProfile("RGB ", newTestDescTag("sRGB"))) {
+		t.Error("The profile must be restored")
+	}
+
+	var kinds []string
+	var header []byte
+	eachWebPChunk(image.Body, func(kind string, data []byte) bool {
+		kinds = append(kinds, kind)
+		if kind == "VP8X" {
+			header = data
+		}
+		return true
+	})
+	if strings.Join(kinds, ",") != "VP8X,ICCP,VP8 ,EXIF" {
+		t.Fatalf("Invalid chunks: %v", kinds)
+	}
+	if header[0] != webpFlagICC|webpFlagEXIF {
+		t.Errorf("Invalid VP8X flags: %x", header[0])
+	}
+	// The canvas size is 550x368
+	if width, height := int(header[4])|int(header[5])<<8|int(header[6])<<16, int(header[7])|int(header[8])<<8|int(header[9])<<16; width != 549 || height != 367 {
+		t.Errorf("Invalid canvas size: %dx%d", width+1, height+1)
+	}
+	if size := int(binary.LittleEndian.Uint32(image.Body[4:])); size != len(image.Body)-8 {
+		t.Errorf("Invalid RIFF size: %d", size)
+	}
+}
+
+func TestApplyMetadataModeKeepPNG(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 4, 4)))
+	out := insertPNGEXIF(buf.Bytes(), newTestEXIF(6))
+
+	image := applyMetadataMode(out, Image{Body: out, Mime: "image/png"}, ImageOptions{MetadataMode: MetadataModeKeep})
+	exif, err := ParseEXIF(ExtractEXIF(image.Body))
+	if err != nil || exif.Tags[EXIFTagOrientation].Int(0) != 1 {
+		t.Fatalf("The orientation must be reset: %v", err)
+	}
+	// The decoder verifies the CRC of each chunk
+	if _, err := png.Decode(bytes.NewReader(image.Body)); err != nil {
+		t.Errorf("Invalid PNG: %s", err)
+	}
+}
+
+func TestImageMetadataMode(t *testing.T) {
+	buf := newTestJPEGWithEXIF(newTestEXIF(1))
+
+	cases := []struct {
+		mode MetadataMode
+		gps  bool
+		exif bool
+	}{
+		{MetadataModeDefault, false, false},
+		{MetadataModeStrip, false, false},
+		{MetadataModeKeep, true, true},
+		{MetadataModeCopyright, false, true},
+	}
+
+	for _, test := range cases {
+		opts := ImageOptions{
+			Width:        300,
+			MetadataMode: test.mode,
+		}
+
+		img, err := ConvertImage(buf, opts)
+		if err != nil {
+			t.Fatalf("Cannot process image: %s", err)
+		}
+
+		raw := ExtractEXIF(img.Body)
+		if (raw != nil) != test.exif {
+			t.Errorf("Invalid EXIF presence: (mode=%d) %t != %t", test.mode, raw != nil, test.exif)
+			continue
+		}
+		if raw == nil {
+			continue
+		}
+
+		exif, err := ParseEXIF(raw)
+		if err != nil {
+			t.Fatalf("Cannot parse EXIF: %s", err)
+		}
+		if (len(exif.GPSTags) != 0) != test.gps {
+			t.Errorf("Invalid GPS data presence: (mode=%d) %t != %t", test.mode, len(exif.GPSTags) != 0, test.gps)
+		}
+		if test.mode == MetadataModeCopyright {
+			assertCopyrightOnlyEXIF(t, img.Body)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package processing
+
+import "math"
+
+// Round rounds the number half away from zero.
+func Round(num float64) int {
+	return int(num + math.Copysign(0.5, num))
+}
+
+// ToFixed rounds the number to the given decimal places.
+func ToFixed(num float64, precision int) float64 {
+	output := math.Pow(10, float64(precision))
+	return float64(Round(num*output)) / output
+}
+
+// MinInt returns the smaller of the integers.
+func MinInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
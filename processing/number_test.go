@@ -1,27 +1,7 @@
-package main
+package processing
 
 import "testing"
 
-func TestToMegaBytes(t *testing.T) {
-	tests := []struct {
-		value    uint64
-		expected float64
-	}{
-		{1024, 0},
-		{1024 * 1024, 1},
-		{1024 * 1024 * 10, 10},
-		{1024 * 1024 * 100, 100},
-		{1024 * 1024 * 250, 250},
-	}
-
-	for _, test := range tests {
-		val := toMegaBytes(test.value)
-		if val != test.expected {
-			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
-		}
-	}
-}
-
 func TestRound(t *testing.T) {
 	tests := []struct {
 		value    float64
@@ -35,7 +15,7 @@ func TestRound(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		val := round(test.value)
+		val := Round(test.value)
 		if val != test.expected {
 			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
 		}
@@ -58,7 +38,26 @@ func TestToFixed(t *testing.T) {
 	}
 
 	for _, test := range tests {
-		val := toFixed(test.value, 1)
+		val := ToFixed(test.value, 1)
+		if val != test.expected {
+			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
+		}
+	}
+}
+
+func TestMinInt(t *testing.T) {
+	tests := []struct {
+		a, b     int
+		expected int
+	}{
+		{1, 2, 1},
+		{2, 1, 1},
+		{-1, 0, -1},
+		{3, 3, 3},
+	}
+
+	for _, test := range tests {
+		val := MinInt(test.a, test.b)
 		if val != test.expected {
 			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
 		}
@@ -0,0 +1,379 @@
+package processing
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// Operation represents an image transformation which is configured by the URL params.
+type Operation interface {
+	// Params returns the param names and their kinds like {"w": "int"}, see parseParam for the kinds
+	Params() map[string]string
+	// Parse maps the parsed params onto the options
+	Parse(params map[string]interface{}, opts *ImageOptions)
+	// Canonical serializes the options back to the params like "w=300", the params of the default value are omitted
+	Canonical(opts ImageOptions) []string
+	// CheckParam returns the expected format if the value of the param is invalid, or empty string
+	CheckParam(key, value string) string
+	// Validate checks the options before the image is processed
+	Validate(opts ImageOptions) error
+	// Apply maps the options onto the libvips options
+	Apply(opts ImageOptions, bopts *bimg.Options)
+}
+
+// ImageOperation is implemented by the operations which transform the processed image by themselves.
+type ImageOperation interface {
+	Operation
+	// ApplyImage transforms the processed image. Each frame of the animation is given as PNG image
+	ApplyImage(buf []byte, opts ImageOptions) ([]byte, error)
+}
+
+// OperationFuncs is an adapter to use the functions as Operation. The nil functions do nothing,
+// except that the params in ImageOptions.Custom are serialized and checked by their kinds.
+type OperationFuncs struct {
+	ParamKinds     map[string]string
+	ParseFunc      func(params map[string]interface{}, opts *ImageOptions)
+	CanonicalFunc  func(opts ImageOptions) []string
+	CheckParamFunc func(key, value string) string
+	ValidateFunc   func(opts ImageOptions) error
+	ApplyFunc      func(opts ImageOptions, bopts *bimg.Options)
+	ApplyImageFunc func(buf []byte, opts ImageOptions) ([]byte, error)
+}
+
+func (f OperationFuncs) Params() map[string]string {
+	return f.ParamKinds
+}
+
+func (f OperationFuncs) Parse(params map[string]interface{}, opts *ImageOptions) {
+	if f.ParseFunc != nil {
+		f.ParseFunc(params, opts)
+	}
+}
+
+func (f OperationFuncs) Canonical(opts ImageOptions) []string {
+	if f.CanonicalFunc != nil {
+		return f.CanonicalFunc(opts)
+	}
+
+	keys := make([]string, 0, len(f.ParamKinds))
+	for key := range f.ParamKinds {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	params := make([]string, len(keys))
+	for i, key := range keys {
+		if value, ok := opts.Custom[key]; ok {
+			params[i] = canonicalParam(key, f.ParamKinds[key], value)
+		}
+	}
+	return nonEmptyParams(params...)
+}
+
+func (f OperationFuncs) CheckParam(key, value string) string {
+	if f.CheckParamFunc != nil {
+		return f.CheckParamFunc(key, value)
+	}
+	return checkParamKind(f.ParamKinds[key], value)
+}
+
+func (f OperationFuncs) Validate(opts ImageOptions) error {
+	if f.ValidateFunc != nil {
+		return f.ValidateFunc(opts)
+	}
+	return nil
+}
+
+func (f OperationFuncs) Apply(opts ImageOptions, bopts *bimg.Options) {
+	if f.ApplyFunc != nil {
+		f.ApplyFunc(opts, bopts)
+	}
+}
+
+func (f OperationFuncs) ApplyImage(buf []byte, opts ImageOptions) ([]byte, error) {
+	if f.ApplyImageFunc != nil {
+		return f.ApplyImageFunc(buf, opts)
+	}
+	return buf, nil
+}
+
+type namedOperation struct {
+	Name string
+	Operation
+}
+
+// The operations in the order of the registration
+var operations []namedOperation
+
+// allowedParams maps the params of the registered operations onto their kinds
+var allowedParams = make(map[string]string)
+
+// RegisterOperation registers the operation, so that its params are accepted by all of the params endpoints.
+// The operations are applied in the order of the registration.
+// It panics if the name or any of the params is already registered.
+func RegisterOperation(name string, op Operation) {
+	for _, registered := range operations {
+		if registered.Name == name {
+			panic(fmt.Sprintf("Operation is already registered: %s", name))
+		}
+	}
+	for key := range op.Params() {
+		if _, ok := allowedParams[key]; ok || key == "p" {
+			panic(fmt.Sprintf("Param of operation %s is already registered: %s", name, key))
+		}
+	}
+
+	for key, kind := range op.Params() {
+		allowedParams[key] = kind
+	}
+	operations = append(operations, namedOperation{name, op})
+}
+
+// SetCustom sets the value of the param of the operation which is not a member of ImageOptions.
+func (o *ImageOptions) SetCustom(key string, value interface{}) {
+	if o.Custom == nil {
+		o.Custom = make(map[string]interface{})
+	}
+	o.Custom[key] = value
+}
+
+// ValidateOperations checks the options by all of the operations.
+func ValidateOperations(opts ImageOptions) error {
+	for _, op := range operations {
+		if err := op.Validate(opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyImageOperations transforms the processed image by the image operations.
+func applyImageOperations(buf []byte, opts ImageOptions) ([]byte, error) {
+	for _, op := range operations {
+		imageOp, ok := op.Operation.(ImageOperation)
+		if !ok {
+			continue
+		}
+		var err error
+		if buf, err = imageOp.ApplyImage(buf, opts); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+func init() {
+	RegisterOperation("resize", OperationFuncs{
+		ParamKinds: map[string]string{"w": "int", "h": "int", "u": "bool", "m": "resizemode"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.Width = params["w"].(int)
+			opts.Height = params["h"].(int)
+			opts.Upscale = params["u"].(bool)
+			opts.ResizeMode = params["m"].(ResizeMode)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(
+				canonicalParam("w", "int", opts.Width),
+				canonicalParam("h", "int", opts.Height),
+				canonicalParam("u", "bool", opts.Upscale),
+				canonicalParam("m", "resizemode", opts.ResizeMode),
+			)
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			bopts.Width = opts.Width
+			bopts.Height = opts.Height
+			bopts.Enlarge = opts.Upscale
+		},
+	})
+
+	RegisterOperation("gravity", OperationFuncs{
+		ParamKinds: map[string]string{"g": "gravity9"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.Gravity = params["g"].(Gravity9)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(canonicalParam("g", "gravity9", opts.Gravity))
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			if g, ok := bimgGravities[opts.Gravity]; ok {
+				bopts.Gravity = g
+			}
+		},
+	})
+
+	RegisterOperation("background", OperationFuncs{
+		ParamKinds: map[string]string{"b": "hexcolor"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.Background = params["b"].([]uint8)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			if len(opts.Background) != 3 {
+				return nil
+			}
+			return nonEmptyParams(canonicalParam("b", "hexcolor", opts.Background))
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			if len(opts.Background) != 0 {
+				bopts.Background = bimg.Color{opts.Background[0], opts.Background[1], opts.Background[2]}
+				bopts.Extend = bimg.ExtendBackground
+			}
+		},
+	})
+
+	RegisterOperation("overlay", OperationFuncs{
+		ParamKinds: map[string]string{"l": "string", "lx": "int", "ly": "int", "lg": "gravity9", "lo": "float"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.OverlayURL = params["l"].(string)
+			opts.OverlayX = params["lx"].(int)
+			opts.OverlayY = params["ly"].(int)
+			opts.OverlayGravity = params["lg"].(Gravity9)
+			opts.OverlayOpacity = float32(params["lo"].(float64))
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			var opacity string
+			if opts.OverlayOpacity != 0 {
+				// The opacity is float32, which is not exact in float64
+				opacity = "lo=" + strconv.FormatFloat(float64(opts.OverlayOpacity), 'f', -1, 32)
+			}
+			return nonEmptyParams(
+				canonicalParam("l", "string", opts.OverlayURL),
+				canonicalParam("lx", "int", opts.OverlayX),
+				canonicalParam("ly", "int", opts.OverlayY),
+				canonicalParam("lg", "gravity9", opts.OverlayGravity),
+				opacity,
+			)
+		},
+		CheckParamFunc: func(key, value string) string {
+			if key == "lo" {
+				return checkParamRange("float", value, 0, 1)
+			}
+			return checkParamKind(allowedParams[key], value)
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			if opts.OverlayURL != "" {
+				bopts.WatermarkImage.Left = opts.OverlayX
+				bopts.WatermarkImage.Top = opts.OverlayY
+				bopts.WatermarkImage.Buf = opts.OverlayBuf
+				bopts.WatermarkImage.Opacity = opts.OverlayOpacity
+			}
+		},
+	})
+
+	RegisterOperation("monochrome", OperationFuncs{
+		ParamKinds: map[string]string{"mono": "bool"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.Monochrome = params["mono"].(bool)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(canonicalParam("mono", "bool", opts.Monochrome))
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			if opts.Monochrome {
+				bopts.Interpretation = bimg.InterpretationBW
+			} else if opts.OutputICC != "" {
+				// Transform from the embedded profile(including CMYK) to the output profile
+				bopts.OutputICC = opts.OutputICC
+			}
+		},
+	})
+
+	RegisterOperation("animation", OperationFuncs{
+		ParamKinds: map[string]string{"anim": "booltrue"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.NoAnimation = !params["anim"].(bool)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(canonicalParam("anim", "booltrue", !opts.NoAnimation))
+		},
+	})
+
+	RegisterOperation("metadata", OperationFuncs{
+		ParamKinds: map[string]string{"meta": "metamode"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.MetadataMode = params["meta"].(MetadataMode)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(canonicalParam("meta", "metamode", opts.MetadataMode))
+		},
+		ValidateFunc: func(opts ImageOptions) error {
+			// The copyright fields are restored only into JPEG, PNG and WebP
+			if opts.MetadataMode == MetadataModeCopyright && copyrightUnsupportedTypes[ImageType(opts.OutputFormat)] {
+				return NewError("Copyright metadata mode is not supported by the output format: "+opts.OutputFormat, BadRequest)
+			}
+			return nil
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			bopts.StripMetadata = opts.MetadataMode != MetadataModeKeep
+		},
+	})
+
+	RegisterOperation("sprite", OperationFuncs{
+		ParamKinds: map[string]string{"cols": "int", "gap": "int"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.SpriteColumns = params["cols"].(int)
+			opts.SpriteGap = params["gap"].(int)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(
+				canonicalParam("cols", "int", opts.SpriteColumns),
+				canonicalParam("gap", "int", opts.SpriteGap),
+			)
+		},
+		CheckParamFunc: func(key, value string) string {
+			if key == "cols" {
+				return checkParamRange("int", value, 1, spriteMaxTiles)
+			}
+			return checkParamKind(allowedParams[key], value)
+		},
+	})
+
+	RegisterOperation("palette", OperationFuncs{
+		ParamKinds: map[string]string{"pal": "int", "json": "bool"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.PaletteSize = params["pal"].(int)
+			opts.JSONResponse = params["json"].(bool)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			return nonEmptyParams(
+				canonicalParam("pal", "int", opts.PaletteSize),
+				canonicalParam("json", "bool", opts.JSONResponse),
+			)
+		},
+		CheckParamFunc: func(key, value string) string {
+			if key == "pal" {
+				return checkParamRange("int", value, 1, paletteMaxSize)
+			}
+			return checkParamKind(allowedParams[key], value)
+		},
+	})
+
+	RegisterOperation("format", OperationFuncs{
+		ParamKinds: map[string]string{"f": "string", "q": "int"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.OutputFormat = params["f"].(string)
+			opts.Quality = params["q"].(int)
+		},
+		CanonicalFunc: func(opts ImageOptions) []string {
+			// libvips encodes with the default quality when it is not given,
+			// but the data outputs (e.g. datauri) have their own default.
+			var quality string
+			if opts.Quality != bimg.Quality || DataOutputFuncs[opts.OutputFormat] != nil {
+				quality = canonicalParam("q", "int", opts.Quality)
+			}
+			return nonEmptyParams(canonicalParam("f", "string", opts.OutputFormat), quality)
+		},
+		CheckParamFunc: func(key, value string) string {
+			if key == "q" {
+				return checkParamRange("int", value, 1, 100)
+			}
+			return checkOutputFormat(value)
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			bopts.Type = ImageType(opts.OutputFormat)
+			bopts.Quality = opts.Quality
+		},
+	})
+}
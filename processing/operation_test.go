@@ -0,0 +1,122 @@
+package processing
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// registerTestOperation registers the sharpen operation like the embedding code does,
+// and returns the function which unregisters it.
+func registerTestOperation(applied *[]byte) func() {
+	RegisterOperation("test-sharpen", OperationFuncs{
+		ParamKinds: map[string]string{"sh": "int"},
+		ParseFunc: func(params map[string]interface{}, opts *ImageOptions) {
+			opts.SetCustom("sh", params["sh"])
+		},
+		ValidateFunc: func(opts ImageOptions) error {
+			if radius, _ := opts.Custom["sh"].(int); radius > 10 {
+				return errors.New("Too large sharpen radius")
+			}
+			return nil
+		},
+		ApplyFunc: func(opts ImageOptions, bopts *bimg.Options) {
+			bopts.Sharpen.Radius, _ = opts.Custom["sh"].(int)
+		},
+		ApplyImageFunc: func(buf []byte, opts ImageOptions) ([]byte, error) {
+			*applied = buf
+			return buf, nil
+		},
+	})
+
+	return func() {
+		operations = operations[:len(operations)-1]
+		delete(allowedParams, "sh")
+	}
+}
+
+func TestRegisterOperation(t *testing.T) {
+	var applied []byte
+	defer registerTestOperation(&applied)()
+
+	opts := ReadParams("w=300,sh=2", nil)
+	if opts.Width != 300 || opts.Custom["sh"] != 2 {
+		t.Errorf("Invalid params of the operation: %+v", opts)
+	}
+	if err := ValidateOperations(opts); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+	if err := ValidateOperations(ReadParams("sh=20", nil)); err == nil {
+		t.Error("Expected the error of the operation")
+	}
+	if err := ValidateStrictParams("sh=abc", nil); err == nil {
+		t.Error("Expected the strict error of the param")
+	}
+
+	if radius := BimgOptions(opts).Sharpen.Radius; radius != 2 {
+		t.Errorf("Invalid sharpen radius: %d", radius)
+	}
+
+	if actual := CanonicalParams(ReadParams("sh=2,h=200", nil)); actual != "h=200,sh=2" {
+		t.Errorf("Invalid canonical params: %s", actual)
+	}
+	if actual := CanonicalParams(ReadParams("h=200,sh=0", nil)); actual != "h=200" {
+		t.Errorf("Invalid canonical params: %s", actual)
+	}
+
+	pipeline, err := ReadJSONParams([]byte(`{"w":300,"sh":2}`), true)
+	if err != nil || pipeline[0].Custom["sh"] != 2 {
+		t.Errorf("Invalid JSON params of the operation: %v, %+v", err, pipeline)
+	}
+
+	buf, _ := ioutil.ReadAll(readFile("thumbnary.jpg"))
+	img, err := ConvertImage(buf, ImageOptions{Width: 300, Height: 300, ResizeMode: ResizeModeCrop})
+	if err != nil {
+		t.Fatalf("Cannot process image: %s", err)
+	}
+	if applied == nil || !bytes.Equal(applied, img.Body) {
+		t.Error("The image operation is not applied")
+	}
+
+	// The image operation is applied to each frame of the animation and to the image of the data outputs
+	applied = nil
+	gifBuf, _ := ioutil.ReadAll(readFile("animated.gif"))
+	if _, err := ConvertImage(gifBuf, ImageOptions{Width: 60, OutputFormat: "gif"}); err != nil {
+		t.Fatalf("Cannot process the animation: %s", err)
+	}
+	if applied == nil {
+		t.Error("The image operation is not applied to the frames of the animation")
+	}
+	applied = nil
+	if _, err := JSONImage(buf, ImageOptions{}); err != nil {
+		t.Fatalf("Cannot process the palette: %s", err)
+	}
+	if applied == nil {
+		t.Error("The image operation is not applied to the data output")
+	}
+}
+
+func TestRegisterOperationConflict(t *testing.T) {
+	cases := []struct {
+		name   string
+		params map[string]string
+	}{
+		{"resize", map[string]string{"x": "int"}},
+		{"test-conflict", map[string]string{"w": "int"}},
+		{"test-conflict", map[string]string{"p": "string"}},
+	}
+
+	for _, c := range cases {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic: %s %v", c.name, c.params)
+				}
+			}()
+			RegisterOperation(c.name, OperationFuncs{ParamKinds: c.params})
+		}()
+	}
+}
@@ -0,0 +1,130 @@
+package processing
+
+import "gopkg.in/h2non/bimg.v1"
+
+type ResizeMode int
+
+const (
+	ResizeModeScale ResizeMode = 0
+	ResizeModeCrop  ResizeMode = 1
+	ResizeModeFit   ResizeMode = 2
+	ResizeModePad   ResizeMode = 4
+)
+
+type Gravity9 int
+
+const (
+	Gravity9TopLeft      Gravity9 = 1
+	Gravity9TopCenter    Gravity9 = 2
+	Gravity9TopRight     Gravity9 = 3
+	Gravity9MiddleLeft   Gravity9 = 4
+	Gravity9MiddleCenter Gravity9 = 5
+	Gravity9MiddleRight  Gravity9 = 6
+	Gravity9BottomLeft   Gravity9 = 7
+	Gravity9BottomCenter Gravity9 = 8
+	Gravity9BottomRight  Gravity9 = 9
+	Gravity9Smart        Gravity9 = 20
+)
+
+// AlignGravities maps the vertical and horizontal alignments like "top/left" onto Gravity9
+var AlignGravities = map[string]Gravity9{
+	"top/left":      Gravity9TopLeft,
+	"top/center":    Gravity9TopCenter,
+	"top/right":     Gravity9TopRight,
+	"middle/left":   Gravity9MiddleLeft,
+	"middle/center": Gravity9MiddleCenter,
+	"middle/right":  Gravity9MiddleRight,
+	"bottom/left":   Gravity9BottomLeft,
+	"bottom/center": Gravity9BottomCenter,
+	"bottom/right":  Gravity9BottomRight,
+}
+
+// ImageOptions represent all the supported image transformation params as first level members
+type ImageOptions struct {
+	NoConvert  bool
+	Width      int
+	Height     int
+	Upscale    bool
+	ResizeMode ResizeMode
+	Force      bool
+	Clip       []int // x1, y1, x2, y2 of the area extracted before resize
+	//	ClipRate   []float
+	Gravity    Gravity9
+	Background []uint8
+
+	Rotate int // 90, 180 or 270 degrees, applied before resize
+	Flop   bool
+
+	OverlayURL     string
+	OverlayBuf     []byte
+	OverlayX       int
+	OverlayY       int
+	OverlayGravity Gravity9
+	OverlayOpacity float32
+
+	Monochrome bool
+	BlurSigma  float64
+
+	NoAnimation        bool
+	MaxAnimationFrames int
+	MaxAnimationMP     int
+
+	OutputICC string
+
+	MetadataMode MetadataMode
+
+	SpriteColumns int
+	SpriteGap     int
+
+	PaletteSize  int
+	JSONResponse bool
+
+	OutputFormat string
+	Quality      int
+
+	Custom map[string]interface{} // The params of the operations which are registered by the embedding code
+}
+
+// ImageOptionsNoConvert represent No conversion options
+var ImageOptionsNoConvert = ImageOptions{
+	NoConvert: true,
+}
+
+// bimgGravities maps Gravity9 onto the gravities which libvips supports, the others are centered
+var bimgGravities = map[Gravity9]bimg.Gravity{
+	Gravity9BottomCenter: bimg.GravitySouth,
+	Gravity9TopCenter:    bimg.GravityNorth,
+	Gravity9MiddleRight:  bimg.GravityEast,
+	Gravity9MiddleLeft:   bimg.GravityWest,
+	Gravity9MiddleCenter: bimg.GravityCentre,
+	Gravity9Smart:        bimg.GravitySmart,
+}
+
+// BimgOptions creates a new bimg compatible options struct mapping the fields properly.
+// The options of the params are mapped by the registered operations.
+func BimgOptions(o ImageOptions) bimg.Options {
+	opts := bimg.Options{
+		Flip:           false,
+		Compression:    6,
+		NoAutoRotate:   false,
+		NoProfile:      false,
+		Force:          o.Force,
+		Gravity:        bimg.GravityCentre,
+		Embed:          false,
+		Extend:         bimg.ExtendBlack,
+		Interpretation: bimg.InterpretationSRGB,
+		StripMetadata:  true,
+		Rotate:         bimg.Angle(o.Rotate),
+		Flop:           o.Flop,
+	}
+
+	if o.BlurSigma > 0 {
+		opts.GaussianBlur.Sigma = o.BlurSigma
+	}
+
+	for _, op := range operations {
+		op.Apply(o, &opts)
+	}
+
+	return opts
+}
@@ -0,0 +1,33 @@
+package processing
+
+import "testing"
+
+func TestBimgOptions(t *testing.T) {
+	imgOpts := ImageOptions{
+		Width:  500,
+		Height: 600,
+	}
+	opts := BimgOptions(imgOpts)
+
+	if opts.Width != imgOpts.Width || opts.Height != imgOpts.Height {
+		t.Error("Invalid width and height")
+	}
+}
+
+func TestBimgOptionsOutputICC(t *testing.T) {
+	cases := []struct {
+		imgOpts  ImageOptions
+		expected string
+	}{
+		{ImageOptions{Width: 500}, ""},
+		{ImageOptions{Width: 500, OutputICC: "/tmp/sRGB.icc"}, "/tmp/sRGB.icc"},
+		{ImageOptions{Width: 500, OutputICC: "/tmp/sRGB.icc", Monochrome: true}, ""},
+	}
+
+	for _, test := range cases {
+		opts := BimgOptions(test.imgOpts)
+		if opts.OutputICC != test.expected {
+			t.Errorf("Invalid output ICC: %s != %s", opts.OutputICC, test.expected)
+		}
+	}
+}
@@ -0,0 +1,373 @@
+package processing
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// ReadParams parses the params like "w=300,h=200". Presets of the origin are expanded
+// like "p=card" or "card", and the explicit params override the params of the preset.
+func ReadParams(inputParamsStr string, presets map[string]string) ImageOptions {
+	if inputParamsStr == "none" {
+		return ImageOptionsNoConvert
+	}
+	inputParamsStr = ExpandPresetParams(inputParamsStr, presets)
+
+	paramsMap := make(map[string]string)
+
+	r := regexp.MustCompile(`([^,=]+)=([^,=]+)`)
+	paramsList := r.FindAllStringSubmatch(inputParamsStr, -1)
+	for i := 0; i < len(paramsList); i++ {
+		paramsMap[paramsList[i][1]] = paramsList[i][2]
+	}
+
+	params := make(map[string]interface{})
+
+	for key, kind := range allowedParams {
+		param := paramsMap[key]
+		params[key] = parseParam(param, kind)
+	}
+
+	opts := mapImageParams(params)
+	return opts
+}
+
+// ExpandPresetParams replaces the presets in the params with their params.
+// The params of the presets are put first, so that the explicit params take precedence.
+func ExpandPresetParams(inputParamsStr string, presets map[string]string) string {
+	var expanded, explicit []string
+	for _, param := range strings.Split(inputParamsStr, ",") {
+		name, ok := presetName(param, presets)
+		if !ok {
+			explicit = append(explicit, param)
+			continue
+		}
+		if params, ok := presets[name]; ok {
+			expanded = append(expanded, params)
+		}
+	}
+	return strings.Join(append(expanded, explicit...), ",")
+}
+
+// presetName returns the preset name of the param like "p=card" or "card".
+// The bare name is taken as the preset only if the origin has presets, otherwise it is ignored as before.
+func presetName(param string, presets map[string]string) (string, bool) {
+	if strings.HasPrefix(param, "p=") {
+		return param[2:], true
+	}
+	if len(presets) != 0 && param != "" && !strings.Contains(param, "=") {
+		return param, true
+	}
+	return "", false
+}
+
+// ValidatePresetParams checks the presets in the params exist in the presets of the origin.
+// If the origin allows presets only, the other params are rejected.
+func ValidatePresetParams(inputParamsStr string, presets map[string]string, presetsOnly bool) error {
+	if inputParamsStr == "none" {
+		return nil
+	}
+
+	for _, param := range strings.Split(inputParamsStr, ",") {
+		name, ok := presetName(param, presets)
+		if !ok {
+			if presetsOnly {
+				return fmt.Errorf("Only presets are allowed: %s", param)
+			}
+			continue
+		}
+		if _, ok := presets[name]; !ok {
+			return fmt.Errorf("Unknown preset: %s", name)
+		}
+	}
+	return nil
+}
+
+// readMapParams maps the decoded JSON params onto ImageOptions.
+// JSON numbers, booleans and strings are parsed in the same way as the URL params,
+// the values of the other types are ignored.
+func readMapParams(options map[string]interface{}) ImageOptions {
+	params := make(map[string]interface{})
+
+	for key, kind := range allowedParams {
+		// Force type defaults
+		params[key] = parseParam("", kind)
+
+		switch v := options[key].(type) {
+		case string:
+			params[key] = parseParam(v, kind)
+		case float64:
+			params[key] = parseParam(strconv.FormatFloat(v, 'f', -1, 64), kind)
+		case int:
+			params[key] = parseParam(strconv.Itoa(v), kind)
+		case bool:
+			params[key] = parseParam(strconv.FormatBool(v), kind)
+		}
+	}
+
+	return mapImageParams(params)
+}
+
+// ReadJSONParams parses the JSON params, which is an object of the params like {"w":300,"f":"webp"},
+// or an object which has the steps of the pipeline like {"steps":[{"w":800,"h":600},{"w":300,"f":"webp"}]}.
+// In strict mode, each of the params is validated like the URL params.
+func ReadJSONParams(data []byte, strict bool) ([]ImageOptions, error) {
+	var options map[string]interface{}
+	if err := json.Unmarshal(data, &options); err != nil {
+		return nil, fmt.Errorf("Invalid JSON params: %s", err)
+	}
+
+	steps, ok := options["steps"]
+	if !ok {
+		if strict {
+			if err := validateStrictMapParams(options); err != nil {
+				return nil, err
+			}
+		}
+		return []ImageOptions{readMapParams(options)}, nil
+	}
+	if strict && len(options) > 1 {
+		return nil, fmt.Errorf("Invalid JSON params: only steps is allowed with steps")
+	}
+
+	list, ok := steps.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("Invalid JSON params: steps must be a non-empty array")
+	}
+	pipeline := make([]ImageOptions, len(list))
+	for i, step := range list {
+		m, ok := step.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Step %d: Invalid JSON params: step must be an object", i+1)
+		}
+		if strict {
+			if err := validateStrictMapParams(m); err != nil {
+				return nil, fmt.Errorf("Step %d: %s", i+1, err)
+			}
+		}
+		pipeline[i] = readMapParams(m)
+	}
+	return pipeline, nil
+}
+
+func parseParam(param, kind string) interface{} {
+	if kind == "int" {
+		return ParseInt(param)
+	}
+	if kind == "float" {
+		return parseFloat(param)
+	}
+	if kind == "color" {
+		return parseColor(param)
+	}
+	if kind == "colorspace" {
+		return parseColorspace(param)
+	}
+	if kind == "gravity" {
+		return parseGravity(param)
+	}
+	if kind == "bool" {
+		return ParseBool(param)
+	}
+	if kind == "booltrue" {
+		return parseBoolDefaultTrue(param)
+	}
+	if kind == "extend" {
+		return parseExtendMode(param)
+	}
+	if kind == "hexcolor" {
+		return ParseHexColor(param)
+	}
+	if kind == "gravity9" {
+		return parseGravity9(param)
+	}
+	if kind == "rectInt" {
+		return parseRectInt(param)
+	}
+	if kind == "rectFloat" {
+		return parseRectFloat(param)
+	}
+	if kind == "resizemode" {
+		return parseResizeMode(param)
+	}
+	if kind == "metamode" {
+		return ParseMetadataMode(param)
+	}
+	return param
+}
+
+// mapImageParams maps the parsed params onto ImageOptions by the registered operations.
+func mapImageParams(params map[string]interface{}) ImageOptions {
+	var opts ImageOptions
+	for _, op := range operations {
+		op.Parse(params, &opts)
+	}
+	return opts
+}
+
+func ParseBool(val string) bool {
+	value, _ := strconv.ParseBool(val)
+	return value
+}
+
+func parseBoolDefaultTrue(val string) bool {
+	value, err := strconv.ParseBool(val)
+	if err != nil {
+		return true
+	}
+	return value
+}
+
+func ParseInt(param string) int {
+	return int(math.Floor(parseFloat(param) + 0.5))
+}
+
+func parseFloat(param string) float64 {
+	val, _ := strconv.ParseFloat(param, 64)
+	return math.Abs(val)
+}
+
+func parseColorspace(val string) bimg.Interpretation {
+	if val == "bw" {
+		return bimg.InterpretationBW
+	}
+	return bimg.InterpretationSRGB
+}
+
+func parseColor(val string) []uint8 {
+	const max float64 = 255
+	buf := []uint8{}
+	if val != "" {
+		for _, num := range strings.Split(val, ",") {
+			n, _ := strconv.ParseUint(strings.Trim(num, " "), 10, 8)
+			buf = append(buf, uint8(math.Min(float64(n), max)))
+		}
+	}
+	return buf
+}
+
+func ParseHexColor(val string) []uint8 {
+	var r, g, b uint8
+
+	if len(val) == 3 {
+		fmt.Sscanf(val, "%1x%1x%1x", &r, &g, &b)
+		r *= 17
+		g *= 17
+		b *= 17
+	} else {
+		fmt.Sscanf(val, "%02x%02x%02x", &r, &g, &b)
+	}
+	return []uint8{r, g, b}
+}
+
+func parseRectInt(val string) []int {
+	var x1, y1, x2, y2 int
+
+	if n, _ := fmt.Sscanf(val, "%d,%d,%d,%d", &x1, &y1, &x2, &y2); n == 4 {
+		return []int{x1, y1, x2, y2}
+	}
+	return []int{x1, y1, x2, y2}
+}
+
+func parseRectFloat(val string) []float32 {
+	var x1, y1, x2, y2 float32
+
+	if n, _ := fmt.Sscanf(val, "%f,%f,%f,%f", &x1, &y1, &x2, &y2); n == 4 {
+		return []float32{x1, y1, x2, y2}
+	}
+	return []float32{x1, y1, x2, y2}
+}
+
+func parseExtendMode(val string) bimg.Extend {
+	val = strings.TrimSpace(strings.ToLower(val))
+	if val == "white" {
+		return bimg.ExtendWhite
+	}
+	if val == "copy" {
+		return bimg.ExtendCopy
+	}
+	if val == "mirror" {
+		return bimg.ExtendMirror
+	}
+	if val == "background" {
+		return bimg.ExtendBackground
+	}
+	return bimg.ExtendBlack
+}
+
+func parseGravity(val string) bimg.Gravity {
+	var m = map[string]bimg.Gravity{
+		"south": bimg.GravitySouth,
+		"north": bimg.GravityNorth,
+		"east":  bimg.GravityEast,
+		"west":  bimg.GravityWest,
+		"smart": bimg.GravitySmart,
+	}
+
+	val = strings.TrimSpace(strings.ToLower(val))
+	if g, ok := m[val]; ok {
+		return g
+	}
+
+	return bimg.GravityCentre
+}
+
+var gravity9Names = map[string]Gravity9{
+	"1":     Gravity9TopLeft,
+	"2":     Gravity9TopCenter,
+	"3":     Gravity9TopRight,
+	"4":     Gravity9MiddleLeft,
+	"5":     Gravity9MiddleCenter,
+	"6":     Gravity9MiddleRight,
+	"7":     Gravity9BottomLeft,
+	"8":     Gravity9BottomCenter,
+	"9":     Gravity9BottomRight,
+	"smart": Gravity9Smart,
+}
+
+func parseGravity9(val string) Gravity9 {
+	val = strings.TrimSpace(strings.ToLower(val))
+	if g, ok := gravity9Names[val]; ok {
+		return g
+	}
+
+	return Gravity9MiddleCenter
+}
+
+var resizeModeNames = map[string]ResizeMode{
+	"scale": ResizeModeScale,
+	"crop":  ResizeModeCrop,
+	"fit":   ResizeModeFit,
+	"pad":   ResizeModePad,
+}
+
+func parseResizeMode(val string) ResizeMode {
+	val = strings.TrimSpace(strings.ToLower(val))
+	if a, ok := resizeModeNames[val]; ok {
+		return a
+	}
+
+	return ResizeModeCrop
+}
+
+var metadataModeNames = map[string]MetadataMode{
+	"strip":          MetadataModeStrip,
+	"keep":           MetadataModeKeep,
+	"copyright":      MetadataModeCopyright,
+	"copyright-only": MetadataModeCopyright,
+}
+
+func ParseMetadataMode(val string) MetadataMode {
+	val = strings.TrimSpace(strings.ToLower(val))
+	if a, ok := metadataModeNames[val]; ok {
+		return a
+	}
+
+	return MetadataModeDefault
+}
@@ -0,0 +1,187 @@
+package processing
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParamError represents an invalid param with its expected format
+type ParamError struct {
+	Param    string
+	Expected string
+}
+
+// ParamErrors represents all of the invalid params of the request
+type ParamErrors []ParamError
+
+func (e ParamErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, pe := range e {
+		msgs[i] = fmt.Sprintf("%s (expected %s)", pe.Param, pe.Expected)
+	}
+	return "Invalid params: " + strings.Join(msgs, ", ")
+}
+
+var HexColorPattern = regexp.MustCompile(`^([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// ValidateStrictParams checks each of the params is known, well-formed and in range.
+// The presets of the origin are validated by ValidatePresetParams.
+func ValidateStrictParams(inputParamsStr string, presets map[string]string) error {
+	if inputParamsStr == "none" {
+		return nil
+	}
+
+	var errs ParamErrors
+	seen := make(map[string]bool)
+	for _, param := range strings.Split(inputParamsStr, ",") {
+		if _, ok := presetName(param, presets); ok || param == "" {
+			continue
+		}
+
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) < 2 {
+			errs = append(errs, ParamError{param, "a param like name=value"})
+			continue
+		}
+		if seen[kv[0]] {
+			errs = append(errs, ParamError{param, "a single " + kv[0] + " param"})
+			continue
+		}
+		seen[kv[0]] = true
+
+		if expected := checkParamValue(kv[0], kv[1]); expected != "" {
+			errs = append(errs, ParamError{param, expected})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validateStrictMapParams checks each of the JSON params like ValidateStrictParams.
+func validateStrictMapParams(options map[string]interface{}) error {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var errs ParamErrors
+	for _, key := range keys {
+		var value string
+		switch v := options[key].(type) {
+		case string:
+			value = v
+		case float64:
+			value = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			value = strconv.FormatBool(v)
+		default:
+			errs = append(errs, ParamError{key, "a string, number or boolean"})
+			continue
+		}
+
+		if expected := checkParamValue(key, value); expected != "" {
+			errs = append(errs, ParamError{key + "=" + value, expected})
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// checkParamValue returns the expected format if the value is invalid, or empty string.
+// The value is checked by the operation which has the param.
+func checkParamValue(key, value string) string {
+	for _, op := range operations {
+		if _, ok := op.Params()[key]; ok {
+			return op.CheckParam(key, value)
+		}
+	}
+	return "a known param name"
+}
+
+// checkParamKind returns the expected format if the value is invalid for the kind, or empty string.
+// The numbers must be non-negative.
+func checkParamKind(kind, value string) string {
+	switch kind {
+	case "int":
+		if checkParamRange(kind, value, 0, math.MaxInt32) != "" {
+			return "non-negative integer"
+		}
+	case "float":
+		if checkParamRange(kind, value, 0, math.MaxInt32) != "" {
+			return "non-negative number"
+		}
+	case "bool", "booltrue":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return "true or false"
+		}
+	case "hexcolor":
+		if !HexColorPattern.MatchString(value) {
+			return "hex color like fff or ffffff"
+		}
+	case "gravity9":
+		if _, ok := gravity9Names[strings.ToLower(value)]; !ok {
+			return "1 to 9 or smart"
+		}
+	case "resizemode":
+		if _, ok := resizeModeNames[strings.ToLower(value)]; !ok {
+			return "scale, crop, fit or pad"
+		}
+	case "metamode":
+		if _, ok := metadataModeNames[strings.ToLower(value)]; !ok {
+			return "strip, keep or copyright"
+		}
+	case "string":
+		if value == "" {
+			return "non-empty value"
+		}
+	}
+	return ""
+}
+
+// checkParamRange returns the expected format if the value is not the number of the kind in the range, or empty string.
+func checkParamRange(kind, value string, min, max float64) string {
+	n, err := strconv.ParseFloat(value, 64)
+	if kind == "int" {
+		_, err = strconv.Atoi(value)
+	}
+	if err == nil && n >= min && n <= max {
+		return ""
+	}
+
+	name := "integer"
+	if kind == "float" {
+		name = "number"
+	}
+	return fmt.Sprintf("%s between %g and %g", name, min, max)
+}
+
+// checkOutputFormat returns the expected format if the output format is unknown, or empty string.
+func checkOutputFormat(value string) string {
+	if value == "" {
+		return "non-empty value"
+	}
+	if value == "auto" || ImageType(value) != 0 || DataOutputFuncs[value] != nil {
+		return ""
+	}
+
+	names := []string{"auto"}
+	for name := range imageTypeNames {
+		names = append(names, name)
+	}
+	for name := range DataOutputFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return "one of " + strings.Join(names, ", ")
+}
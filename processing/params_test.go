@@ -0,0 +1,449 @@
+package processing
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+const fixture = "fixtures/large.jpg"
+
+func TestReadParams(t *testing.T) {
+	str := "w=100,h=80,lo=0.2,b=ff0a14"
+	params := ReadParams(str, nil)
+
+	assert := params.Width == 100 &&
+		params.Height == 80 &&
+		params.OverlayOpacity == 0.2 &&
+		params.Background[0] == 255 &&
+		params.Background[1] == 10 &&
+		params.Background[2] == 20
+
+	if assert == false {
+		t.Error("Invalid params")
+	}
+}
+
+func TestParseParam(t *testing.T) {
+	intCases := []struct {
+		value    string
+		expected int
+	}{
+		{"1", 1},
+		{"0100", 100},
+		{"-100", 100},
+		{"99.02", 99},
+		{"99.9", 100},
+	}
+
+	for _, test := range intCases {
+		val := parseParam(test.value, "int")
+		if val != test.expected {
+			t.Errorf("Invalid param: %s != %d", test.value, test.expected)
+		}
+	}
+
+	floatCases := []struct {
+		value    string
+		expected float64
+	}{
+		{"1.1", 1.1},
+		{"01.1", 1.1},
+		{"-1.10", 1.10},
+		{"99.999999", 99.999999},
+	}
+
+	for _, test := range floatCases {
+		val := parseParam(test.value, "float")
+		if val != test.expected {
+			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
+		}
+	}
+
+	boolCases := []struct {
+		value    string
+		expected bool
+	}{
+		{"true", true},
+		{"false", false},
+		{"1", true},
+		{"1.1", false},
+		{"-1", false},
+		{"0", false},
+		{"0.0", false},
+		{"no", false},
+		{"yes", false},
+	}
+
+	for _, test := range boolCases {
+		val := parseParam(test.value, "bool")
+		if val != test.expected {
+			t.Errorf("Invalid param: %#v != %#v", val, test.expected)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected []uint8
+	}{
+		{"200,100,20", []uint8{200, 100, 20}},
+		{"0,280,200", []uint8{0, 255, 200}},
+		{" -1, 256 , 50", []uint8{0, 255, 50}},
+		{" a, 20 , &hel0", []uint8{0, 20, 0}},
+		{"", []uint8{}},
+	}
+
+	for _, color := range cases {
+		c := parseColor(color.value)
+		l := len(color.expected)
+
+		if len(c) != l {
+			t.Errorf("Invalid color length: %#v", c)
+		}
+		if l == 0 {
+			continue
+		}
+
+		assert := c[0] == color.expected[0] &&
+			c[1] == color.expected[1] &&
+			c[2] == color.expected[2]
+
+		if assert == false {
+			t.Errorf("Invalid color schema: %#v <> %#v", color.expected, c)
+		}
+	}
+}
+
+func TestParseExtend(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected bimg.Extend
+	}{
+		{"white", bimg.ExtendWhite},
+		{"black", bimg.ExtendBlack},
+		{"copy", bimg.ExtendCopy},
+		{"mirror", bimg.ExtendMirror},
+		{"background", bimg.ExtendBackground},
+		{" BACKGROUND  ", bimg.ExtendBackground},
+		{"invalid", bimg.ExtendBlack},
+		{"", bimg.ExtendBlack},
+	}
+
+	for _, extend := range cases {
+		c := parseExtendMode(extend.value)
+		if c != extend.expected {
+			t.Errorf("Invalid extend value : %d != %d", c, extend.expected)
+		}
+	}
+}
+
+func TestGravity(t *testing.T) {
+	cases := []struct {
+		gravityValue   string
+		smartCropValue bool
+	}{
+		{gravityValue: "foo", smartCropValue: false},
+		{gravityValue: "smart", smartCropValue: true},
+	}
+
+	for _, td := range cases {
+		str := "g=" + td.gravityValue
+		io := ReadParams(str, nil)
+		if (io.Gravity == Gravity9Smart) != td.smartCropValue {
+			t.Errorf("Expected %t to be %t, test data: %+v", io.Gravity == Gravity9Smart, td.smartCropValue, td)
+		}
+	}
+}
+
+func TestReadMapParams(t *testing.T) {
+	cases := []struct {
+		params   map[string]interface{}
+		expected ImageOptions
+	}{
+		{
+			map[string]interface{}{
+				"w":    100,
+				"lo":   0.1,
+				"f":    "webp",
+				"mono": true,
+				"g":    "4",
+				"b":    "ffc896",
+			},
+			ImageOptions{
+				Width:          100,
+				OverlayOpacity: 0.1,
+				OutputFormat:   "webp",
+				Monochrome:     true,
+				Gravity:        Gravity9MiddleLeft,
+				Background:     []uint8{255, 200, 150},
+			},
+		},
+	}
+
+	for _, test := range cases {
+		opts := readMapParams(test.params)
+		if opts.Width != test.expected.Width {
+			t.Errorf("Invalid width: %d != %d", opts.Width, test.expected.Width)
+		}
+		if opts.OverlayOpacity != test.expected.OverlayOpacity {
+			t.Errorf("Invalid overlay opacity: %#v != %#v", opts.OverlayOpacity, test.expected.OverlayOpacity)
+		}
+		if opts.OutputFormat != test.expected.OutputFormat {
+			t.Errorf("Invalid output format: %s != %s", opts.OutputFormat, test.expected.OutputFormat)
+		}
+		if opts.Monochrome != test.expected.Monochrome {
+			t.Errorf("Invalid monochrome: %#v != %#v", opts.Monochrome, test.expected.Monochrome)
+		}
+		if opts.Gravity != test.expected.Gravity {
+			t.Errorf("Invalid gravity: %#v != %#v", opts.Gravity, test.expected.Gravity)
+		}
+		if opts.Background[0] != test.expected.Background[0] || opts.Background[1] != test.expected.Background[1] || opts.Background[2] != test.expected.Background[2] {
+			t.Errorf("Invalid background: %#v != %#v", opts.Background, test.expected.Background)
+		}
+	}
+}
+
+func TestReadParamsAnimation(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected bool
+	}{
+		{"w=100", false},
+		{"w=100,anim=true", false},
+		{"w=100,anim=false", true},
+		{"w=100,anim=0", true},
+		{"w=100,anim=foo", false},
+	}
+
+	for _, test := range cases {
+		opts := ReadParams(test.value, nil)
+		if opts.NoAnimation != test.expected {
+			t.Errorf("Invalid no animation: %s != %t", test.value, test.expected)
+		}
+	}
+}
+
+func TestReadParamsMetadataMode(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected MetadataMode
+	}{
+		{"w=100", MetadataModeDefault},
+		{"w=100,meta=strip", MetadataModeStrip},
+		{"w=100,meta=keep", MetadataModeKeep},
+		{"w=100,meta=copyright", MetadataModeCopyright},
+		{"w=100,meta=copyright-only", MetadataModeCopyright},
+		{"w=100,meta=foo", MetadataModeDefault},
+	}
+
+	for _, test := range cases {
+		opts := ReadParams(test.value, nil)
+		if opts.MetadataMode != test.expected {
+			t.Errorf("Invalid metadata mode: %s != %d", test.value, test.expected)
+		}
+	}
+}
+
+func TestReadParamsPreset(t *testing.T) {
+	presets := map[string]string{
+		"card":  "w=300,h=200,m=crop",
+		"hq":    "q=95",
+		"thumb": "w=100",
+	}
+
+	cases := []struct {
+		value   string
+		width   int
+		height  int
+		quality int
+	}{
+		{"p=card", 300, 200, 0},
+		{"card", 300, 200, 0},
+		{"card,hq", 300, 200, 95},
+		{"w=400,p=card", 400, 200, 0},
+		{"p=card,w=400", 400, 200, 0},
+		{"p=unknown,w=50", 50, 0, 0},
+	}
+
+	for _, test := range cases {
+		opts := ReadParams(test.value, presets)
+		if opts.Width != test.width || opts.Height != test.height || opts.Quality != test.quality {
+			t.Errorf("Invalid preset params: %s (got=%dx%d q=%d)", test.value, opts.Width, opts.Height, opts.Quality)
+		}
+	}
+}
+
+func TestValidatePresetParams(t *testing.T) {
+	presets := map[string]string{"card": "w=300,h=200,m=crop"}
+
+	cases := []struct {
+		value       string
+		presetsOnly bool
+		valid       bool
+	}{
+		{"w=300", false, true},
+		{"p=card,q=80", false, true},
+		{"card", false, true},
+		{"none", true, true},
+		{"card", true, true},
+		{"p=card", true, true},
+		{"p=card,q=80", true, false},
+		{"w=300", true, false},
+		{"p=unknown", false, false},
+		{"unknown,w=300", false, false},
+	}
+
+	for _, test := range cases {
+		err := ValidatePresetParams(test.value, presets, test.presetsOnly)
+		if (err == nil) != test.valid {
+			t.Errorf("Invalid validation result: (value=%s) (presetsOnly=%t) (err=%v)", test.value, test.presetsOnly, err)
+		}
+	}
+
+	// The bare name is ignored if the origin has no presets
+	if err := ValidatePresetParams("unknown,w=300", nil, false); err != nil {
+		t.Errorf("The bare name must be ignored without presets: %v", err)
+	}
+	if err := ValidatePresetParams("p=unknown,w=300", nil, false); err == nil {
+		t.Error("The unknown preset must be rejected")
+	}
+}
+
+func TestReadJSONParams(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected []ImageOptions
+		err      bool
+	}{
+		{
+			`{"w":300,"h":"200","m":"fit","mono":true,"anim":false,"q":80.4}`,
+			[]ImageOptions{{Width: 300, Height: 200, ResizeMode: ResizeModeFit, Monochrome: true, NoAnimation: true, Quality: 80}},
+			false,
+		},
+		{
+			`{"steps":[{"w":800,"h":600},{"w":300,"f":"webp"}]}`,
+			[]ImageOptions{{Width: 800, Height: 600}, {Width: 300, OutputFormat: "webp"}},
+			false,
+		},
+		{`{"w":[300]}`, []ImageOptions{{}}, false},
+		{`{"steps":[]}`, nil, true},
+		{`{"steps":[300]}`, nil, true},
+		{`[{"w":300}]`, nil, true},
+		{`{"w":300`, nil, true},
+	}
+
+	for _, test := range cases {
+		pipeline, err := ReadJSONParams([]byte(test.value), false)
+		if (err != nil) != test.err {
+			t.Errorf("Unexpected error: %s (err=%v)", test.value, err)
+			continue
+		}
+		if len(pipeline) != len(test.expected) {
+			t.Errorf("Invalid steps: %s (got=%d)", test.value, len(pipeline))
+			continue
+		}
+		for i, opts := range pipeline {
+			e := test.expected[i]
+			if opts.Width != e.Width || opts.Height != e.Height || opts.Monochrome != e.Monochrome || opts.NoAnimation != e.NoAnimation ||
+				opts.Quality != e.Quality || opts.OutputFormat != e.OutputFormat || (e.ResizeMode != 0 && opts.ResizeMode != e.ResizeMode) {
+				t.Errorf("Invalid options: %s step %d (got=%+v)", test.value, i+1, opts)
+			}
+		}
+	}
+}
+
+func TestValidateStrictParams(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected []ParamError
+	}{
+		{"w=300,h=200,m=crop,g=smart,b=fff,q=80,lo=0.5,mono=true,meta=copyright,f=webp", nil},
+		{"none", nil},
+		{"card,w=300", nil},
+		{"p=card,w=300", nil},
+		{"w=300,", nil},
+		{"f=json,pal=16,cols=4", nil},
+		{"x=1", []ParamError{{"x=1", "a known param name"}}},
+		{"w=abc", []ParamError{{"w=abc", "non-negative integer"}}},
+		{"w=-100", []ParamError{{"w=-100", "non-negative integer"}}},
+		{"w=100.5", []ParamError{{"w=100.5", "non-negative integer"}}},
+		{"w=", []ParamError{{"w=", "non-negative integer"}}},
+		{"q=0", []ParamError{{"q=0", "integer between 1 and 100"}}},
+		{"q=101", []ParamError{{"q=101", "integer between 1 and 100"}}},
+		{"lo=1.5", []ParamError{{"lo=1.5", "number between 0 and 1"}}},
+		{"pal=17", []ParamError{{"pal=17", "integer between 1 and 16"}}},
+		{"b=ff00", []ParamError{{"b=ff00", "hex color like fff or ffffff"}}},
+		{"b=gggggg", []ParamError{{"b=gggggg", "hex color like fff or ffffff"}}},
+		{"g=10", []ParamError{{"g=10", "1 to 9 or smart"}}},
+		{"m=stretch", []ParamError{{"m=stretch", "scale, crop, fit or pad"}}},
+		{"meta=all", []ParamError{{"meta=all", "strip, keep or copyright"}}},
+		{"mono=yes", []ParamError{{"mono=yes", "true or false"}}},
+		{"l=", []ParamError{{"l=", "non-empty value"}}},
+		{"w=100,w=200", []ParamError{{"w=200", "a single w param"}}},
+		{
+			"w=abc,x=1,q=0",
+			[]ParamError{{"w=abc", "non-negative integer"}, {"x=1", "a known param name"}, {"q=0", "integer between 1 and 100"}},
+		},
+	}
+
+	presets := map[string]string{"card": "w=300,h=200,m=crop"}
+	for _, test := range cases {
+		err := ValidateStrictParams(test.value, presets)
+		if test.expected == nil {
+			if err != nil {
+				t.Errorf("Unexpected error: %s (err=%v)", test.value, err)
+			}
+			continue
+		}
+
+		errs, ok := err.(ParamErrors)
+		if !ok || !reflect.DeepEqual([]ParamError(errs), test.expected) {
+			t.Errorf("Invalid errors: %s (got=%#v)", test.value, err)
+		}
+	}
+
+	// The bare name is not a preset if the origin has no presets
+	err := ValidateStrictParams("card,w=300", nil)
+	if errs, ok := err.(ParamErrors); !ok || len(errs) != 1 || errs[0].Param != "card" {
+		t.Errorf("Invalid errors of the bare name: %v", err)
+	}
+
+	err = ValidateStrictParams("f=bmp", nil)
+	if err == nil || !strings.Contains(err.Error(), "f=bmp (expected one of auto, blurhash,") {
+		t.Errorf("Invalid error message: %v", err)
+	}
+}
+
+func TestValidateStrictMapParams(t *testing.T) {
+	cases := []struct {
+		value    map[string]interface{}
+		expected []ParamError
+	}{
+		{map[string]interface{}{"w": 300.0, "m": "fit", "mono": true, "b": "ffc896"}, nil},
+		{map[string]interface{}{"w": -1.0}, []ParamError{{"w=-1", "non-negative integer"}}},
+		{map[string]interface{}{"w": []interface{}{300.0}}, []ParamError{{"w", "a string, number or boolean"}}},
+		{
+			map[string]interface{}{"zoom": 2.0, "q": 120.0},
+			[]ParamError{{"q=120", "integer between 1 and 100"}, {"zoom=2", "a known param name"}},
+		},
+	}
+
+	for _, test := range cases {
+		err := validateStrictMapParams(test.value)
+		if test.expected == nil {
+			if err != nil {
+				t.Errorf("Unexpected error: %v (err=%v)", test.value, err)
+			}
+			continue
+		}
+
+		errs, ok := err.(ParamErrors)
+		if !ok || !reflect.DeepEqual([]ParamError(errs), test.expected) {
+			t.Errorf("Invalid errors: %v (got=%#v)", test.value, err)
+		}
+	}
+}
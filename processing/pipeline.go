@@ -0,0 +1,78 @@
+package processing
+
+import (
+	"fmt"
+
+	"gopkg.in/h2non/bimg.v1"
+)
+
+// PipelineImage processes the image by the steps in order, a single step is processed as is.
+// The intermediate images are kept in memory as lossless PNG, and their area is limited by maxMP.
+// The animated image is rejected by the steps unless the last step has anim=false,
+// since the intermediate images have the first frame only.
+func PipelineImage(buf []byte, pipeline []ImageOptions, maxMP int) (Image, error) {
+	src := buf
+	last := len(pipeline) - 1
+	if last > 0 && !pipeline[last].NoAnimation && IsAnimatedImage(buf) {
+		return Image{}, fmt.Errorf("Animated image is not supported in pipeline, set anim=false to process the first frame")
+	}
+
+	for i, step := range pipeline[:last] {
+		opts, err := keepPipelineImageSize(buf, step)
+		if err != nil {
+			return Image{}, fmt.Errorf("Step %d: %s", i+1, err)
+		}
+		opts.OutputFormat = "png"
+		opts.NoAnimation = true
+		opts.MetadataMode = MetadataModeStrip
+
+		out, err := ConvertImage(buf, opts)
+		if err != nil {
+			return Image{}, fmt.Errorf("Step %d: %s", i+1, err)
+		}
+
+		size, err := bimg.Size(out.Body)
+		if err != nil {
+			return Image{}, fmt.Errorf("Step %d: %s", i+1, err)
+		}
+		if maxMP > 0 && size.Width*size.Height > maxMP*1000000 {
+			return Image{}, fmt.Errorf("Step %d: The intermediate image area(%dx%d) is exceed maximum area(%dMP)", i+1, size.Width, size.Height, maxMP)
+		}
+		buf = out.Body
+	}
+
+	opts, err := keepPipelineImageSize(buf, pipeline[last])
+	if err != nil {
+		return Image{}, fmt.Errorf("Step %d: %s", last+1, err)
+	}
+	if fn, ok := DataOutputFuncs[opts.OutputFormat]; ok {
+		return fn(buf, opts)
+	}
+
+	image, err := ConvertImage(buf, opts)
+	if err != nil {
+		return Image{}, fmt.Errorf("Step %d: %s", last+1, err)
+	}
+	// The intermediate images have no metadata, restore the fields from the source
+	if last > 0 && opts.MetadataMode == MetadataModeCopyright {
+		image = applyMetadataMode(src, image, opts)
+	}
+	return image, nil
+}
+
+// keepPipelineImageSize sets the size of the current image to the step which has no size,
+// so that the step like "mono=true" or an overlay does not resize the image.
+func keepPipelineImageSize(buf []byte, opts ImageOptions) (ImageOptions, error) {
+	if opts.Width != 0 || opts.Height != 0 {
+		return opts, nil
+	}
+
+	width, height, err := OrientedImageSize(buf)
+	if err != nil {
+		return opts, err
+	}
+	opts.Width = width
+	opts.Height = height
+	opts.ResizeMode = ResizeModeScale
+	return opts, nil
+}
@@ -0,0 +1,42 @@
+package processing
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestPipelineImage(t *testing.T) {
+	buf, _ := ioutil.ReadAll(readFile("large.jpg"))
+
+	pipeline := []ImageOptions{
+		{Width: 800, Height: 600, ResizeMode: ResizeModeCrop},
+		{Width: 300, ResizeMode: ResizeModeScale, OutputFormat: "png"},
+	}
+	img, err := PipelineImage(buf, pipeline, 0)
+	if err != nil {
+		t.Fatalf("Cannot process pipeline: %s", err)
+	}
+	if img.Mime != "image/png" {
+		t.Errorf("Invalid MIME type: %s", img.Mime)
+	}
+	if err := assertSize(img.Body, 300, 225); err != nil {
+		t.Error(err)
+	}
+
+	pipeline[0] = ImageOptions{Width: 3000, Height: 2000, Upscale: true, ResizeMode: ResizeModeCrop}
+	if _, err := PipelineImage(buf, pipeline, 4); err == nil || !strings.HasPrefix(err.Error(), "Step 1:") {
+		t.Errorf("Expected error for the intermediate image area: %v", err)
+	}
+
+	// The animated image is flattened only by anim=false
+	anim, _ := ioutil.ReadAll(readFile("animated.gif"))
+	pipeline = []ImageOptions{{Width: 50}, {Width: 30, OutputFormat: "png"}}
+	if _, err := PipelineImage(anim, pipeline, 0); err == nil {
+		t.Error("Expected error for the animated image")
+	}
+	pipeline[1].NoAnimation = true
+	if _, err := PipelineImage(anim, pipeline, 0); err != nil {
+		t.Errorf("Cannot process the first frame of the animated image: %s", err)
+	}
+}
@@ -0,0 +1,79 @@
+package processing
+
+import "encoding/binary"
+
+const (
+	jpegMarkerAPP1  = 0xE1
+	jpegMarkerAPP2  = 0xE2
+	jpegMarkerAPP13 = 0xED
+	jpegMarkerSOS   = 0xDA
+	jpegMarkerEOI   = 0xD9
+)
+
+func isJPEGBuffer(buf []byte) bool {
+	return len(buf) > 3 && buf[0] == 0xFF && buf[1] == 0xD8
+}
+
+func isPNGBuffer(buf []byte) bool {
+	return len(buf) > 8 && string(buf[:8]) == "\x89PNG\r\n\x1a\n"
+}
+
+func isWebPBuffer(buf []byte) bool {
+	return len(buf) > 12 && string(buf[:4]) == "RIFF" && string(buf[8:12]) == "WEBP"
+}
+
+// eachJPEGSegment calls fn with the marker, the offset of the segment and its payload
+// for each segment until the start of scan. Iteration stops when fn returns false.
+func eachJPEGSegment(buf []byte, fn func(marker byte, offset int, data []byte) bool) {
+	pos := 2
+	for pos+4 <= len(buf) {
+		if buf[pos] != 0xFF {
+			return
+		}
+		marker := buf[pos+1]
+		if marker == jpegMarkerEOI || marker == jpegMarkerSOS {
+			return
+		}
+		size := int(binary.BigEndian.Uint16(buf[pos+2:]))
+		if size < 2 || pos+2+size > len(buf) {
+			return
+		}
+		if !fn(marker, pos, buf[pos+4:pos+2+size]) {
+			return
+		}
+		pos += 2 + size
+	}
+}
+
+// eachPNGChunk calls fn with the chunk type, the offset of the chunk and its payload
+// for each chunk. Iteration stops when fn returns false.
+func eachPNGChunk(buf []byte, fn func(kind string, offset int, data []byte) bool) {
+	pos := 8
+	for pos+12 <= len(buf) {
+		size := int(binary.BigEndian.Uint32(buf[pos:]))
+		if size < 0 || pos+12+size > len(buf) {
+			return
+		}
+		if !fn(string(buf[pos+4:pos+8]), pos, buf[pos+8:pos+8+size]) {
+			return
+		}
+		pos += 12 + size
+	}
+}
+
+// eachWebPChunk calls fn with the chunk FourCC and its payload for each chunk
+// of the RIFF container. Iteration stops when fn returns false.
+func eachWebPChunk(buf []byte, fn func(kind string, data []byte) bool) {
+	pos := 12
+	for pos+8 <= len(buf) {
+		size := int(binary.LittleEndian.Uint32(buf[pos+4:]))
+		if size < 0 || pos+8+size > len(buf) {
+			return
+		}
+		if !fn(string(buf[pos:pos+4]), buf[pos+8:pos+8+size]) {
+			return
+		}
+		// Chunks are padded to even size
+		pos += 8 + size + size%2
+	}
+}
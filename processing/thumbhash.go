@@ -0,0 +1,143 @@
+package processing
+
+import (
+	"image"
+	"math"
+)
+
+// EncodeThumbHash returns the ThumbHash (https://evanw.github.io/thumbhash/) of the image.
+// The image must fit in 100x100.
+func EncodeThumbHash(img image.Image) []byte {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	// Determine the average color
+	rgba := make([][4]float64, w*h)
+	var avgR, avgG, avgB, avgA float64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			r, g, b, a := pixelRGBA(img, bounds.Min.X+x, bounds.Min.Y+y)
+			p := [4]float64{float64(r) / 255, float64(g) / 255, float64(b) / 255, float64(a) / 255}
+			rgba[y*w+x] = p
+			avgR += p[3] * p[0]
+			avgG += p[3] * p[1]
+			avgB += p[3] * p[2]
+			avgA += p[3]
+		}
+	}
+	if avgA > 0 {
+		avgR /= avgA
+		avgG /= avgA
+		avgB /= avgA
+	}
+
+	hasAlpha := avgA < float64(w*h)
+	lLimit := 7.0
+	if hasAlpha {
+		// Use fewer luminance bits if there's alpha
+		lLimit = 5
+	}
+	maxWH := math.Max(float64(w), float64(h))
+	lx := int(math.Max(1, jsRound(lLimit*float64(w)/maxWH)))
+	ly := int(math.Max(1, jsRound(lLimit*float64(h)/maxWH)))
+
+	// Convert the image from RGBA to LPQA (composite atop the average color)
+	l := make([]float64, w*h) // luminance
+	p := make([]float64, w*h) // yellow - blue
+	q := make([]float64, w*h) // red - green
+	a := make([]float64, w*h) // alpha
+	for i, px := range rgba {
+		r := avgR*(1-px[3]) + px[3]*px[0]
+		g := avgG*(1-px[3]) + px[3]*px[1]
+		b := avgB*(1-px[3]) + px[3]*px[2]
+		l[i] = (r + g + b) / 3
+		p[i] = (r+g)/2 - b
+		q[i] = r - g
+		a[i] = px[3]
+	}
+
+	// Encode using the DCT into DC (constant) and normalized AC (varying) terms
+	encodeChannel := func(channel []float64, nx, ny int) (float64, []float64, float64) {
+		var dc, scale float64
+		var ac []float64
+		fx := make([]float64, w)
+		for cy := 0; cy < ny; cy++ {
+			for cx := 0; cx*ny < nx*(ny-cy); cx++ {
+				f := 0.0
+				for x := 0; x < w; x++ {
+					fx[x] = math.Cos(math.Pi / float64(w) * float64(cx) * (float64(x) + 0.5))
+				}
+				for y := 0; y < h; y++ {
+					fy := math.Cos(math.Pi / float64(h) * float64(cy) * (float64(y) + 0.5))
+					for x := 0; x < w; x++ {
+						f += channel[x+y*w] * fx[x] * fy
+					}
+				}
+				f /= float64(w * h)
+				if cx > 0 || cy > 0 {
+					ac = append(ac, f)
+					scale = math.Max(scale, math.Abs(f))
+				} else {
+					dc = f
+				}
+			}
+		}
+		if scale > 0 {
+			for i := range ac {
+				ac[i] = 0.5 + 0.5/scale*ac[i]
+			}
+		}
+		return dc, ac, scale
+	}
+
+	lDC, lAC, lScale := encodeChannel(l, maxInt(3, lx), maxInt(3, ly))
+	pDC, pAC, pScale := encodeChannel(p, 3, 3)
+	qDC, qAC, qScale := encodeChannel(q, 3, 3)
+	channels := [][]float64{lAC, pAC, qAC}
+
+	// Write the constants
+	isLandscape := w > h
+	header24 := int(jsRound(63*lDC)) | int(jsRound(31.5+31.5*pDC))<<6 | int(jsRound(31.5+31.5*qDC))<<12 | int(jsRound(31*lScale))<<18
+	header16 := int(jsRound(63*pScale))<<3 | int(jsRound(63*qScale))<<9
+	if hasAlpha {
+		header24 |= 1 << 23
+	}
+	if isLandscape {
+		header16 |= ly | 1<<15
+	} else {
+		header16 |= lx
+	}
+	hash := []byte{byte(header24), byte(header24 >> 8), byte(header24 >> 16), byte(header16), byte(header16 >> 8)}
+	if hasAlpha {
+		aDC, aAC, aScale := encodeChannel(a, 5, 5)
+		hash = append(hash, byte(int(jsRound(15*aDC))|int(jsRound(15*aScale))<<4))
+		channels = append(channels, aAC)
+	}
+
+	// Write the varying factors
+	acStart := len(hash)
+	acIndex := 0
+	for _, ac := range channels {
+		for _, f := range ac {
+			if acStart+acIndex>>1 >= len(hash) {
+				hash = append(hash, 0)
+			}
+			hash[acStart+acIndex>>1] |= byte(int(jsRound(15*f)) << uint((acIndex&1)<<2))
+			acIndex++
+		}
+	}
+
+	return hash
+}
+
+// jsRound rounds half up like JavaScript Math.round, to be compatible with the reference implementation
+func jsRound(v float64) float64 {
+	return math.Floor(v + 0.5)
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
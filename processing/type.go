@@ -1,4 +1,4 @@
-package main
+package processing
 
 import (
 	"strings"
@@ -30,33 +30,31 @@ func IsImageMimeTypeSupported(mime string) bool {
 	return bimg.IsTypeNameSupported(format)
 }
 
+// The image type aliases of the output formats
+var imageTypeNames = map[string]bimg.ImageType{
+	"jpeg": bimg.JPEG,
+	"png":  bimg.PNG,
+	"webp": bimg.WEBP,
+	"tiff": bimg.TIFF,
+	"gif":  bimg.GIF,
+	"svg":  bimg.SVG,
+	"pdf":  bimg.PDF,
+}
+
 // ImageType returns the image type based on the given image type alias.
 func ImageType(name string) bimg.ImageType {
-	ext := strings.ToLower(name)
-	if ext == "jpeg" {
-		return bimg.JPEG
-	}
-	if ext == "png" {
-		return bimg.PNG
-	}
-	if ext == "webp" {
-		return bimg.WEBP
-	}
-	if ext == "tiff" {
-		return bimg.TIFF
-	}
-	if ext == "gif" {
-		return bimg.GIF
-	}
-	if ext == "svg" {
-		return bimg.SVG
-	}
-	if ext == "pdf" {
-		return bimg.PDF
+	if t, ok := imageTypeNames[strings.ToLower(name)]; ok {
+		return t
 	}
 	return bimg.UNKNOWN
 }
 
+// IsImageTypeSaveSupported returns true if the image can be saved in the given image type alias by libvips,
+// otherwise the image is converted to JPEG.
+func IsImageTypeSaveSupported(name string) bool {
+	return bimg.IsTypeSupportedSave(ImageType(name))
+}
+
 // GetImageMimeType returns the MIME type based on the given image type code.
 func GetImageMimeType(code bimg.ImageType) string {
 	if code == bimg.PNG {
@@ -0,0 +1,151 @@
+package processing
+
+/*
+#cgo pkg-config: vips
+#include <stdlib.h>
+#include <string.h>
+#include <vips/vips.h>
+
+// thumbnary_free_buffer frees the copy of the source buffer when the image is closed.
+static void thumbnary_free_buffer(VipsImage *image, gpointer buf) {
+	g_free(buf);
+}
+
+// thumbnary_image_stats computes vips_stats and vips_hist_find of each band of the image
+// converted to 8 bit sRGB. stats must be freed by g_free, which has (bands + 1) rows of
+// min, max, sum, sum of squares, mean, deviation, xmin, ymin, xmax, ymax.
+// hist must be freed by g_free, which has 256 bins of each band.
+// The buffer is copied, since libvips may keep the image in the operation cache after this returns.
+static int thumbnary_image_stats(const void *buf, size_t len, int *bands, double **stats, unsigned int **hist) {
+	VipsImage *in, *srgb, *uchar, *matrix;
+	void *copy;
+	size_t size;
+	int i;
+
+	copy = g_malloc(len);
+	memcpy(copy, buf, len);
+	in = vips_image_new_from_buffer(copy, len, "", NULL);
+	if (in == NULL) {
+		g_free(copy);
+		return -1;
+	}
+	g_signal_connect(in, "postclose", G_CALLBACK(thumbnary_free_buffer), copy);
+
+	if (vips_colourspace(in, &srgb, VIPS_INTERPRETATION_sRGB, NULL)) {
+		g_object_unref(in);
+		return -1;
+	}
+	g_object_unref(in);
+
+	if (vips_cast(srgb, &uchar, VIPS_FORMAT_UCHAR, NULL)) {
+		g_object_unref(srgb);
+		return -1;
+	}
+	g_object_unref(srgb);
+
+	if (vips_stats(uchar, &matrix, NULL)) {
+		g_object_unref(uchar);
+		return -1;
+	}
+	*stats = (double *) vips_image_write_to_memory(matrix, &size);
+	g_object_unref(matrix);
+	if (*stats == NULL) {
+		g_object_unref(uchar);
+		return -1;
+	}
+
+	*bands = uchar->Bands;
+	*hist = g_new0(unsigned int, 256 * uchar->Bands);
+
+	for (i = 0; i < uchar->Bands; i++) {
+		VipsImage *band, *h;
+		unsigned int *bins;
+
+		if (vips_extract_band(uchar, &band, i, NULL)) {
+			break;
+		}
+		if (vips_hist_find(band, &h, NULL)) {
+			g_object_unref(band);
+			break;
+		}
+		g_object_unref(band);
+
+		bins = (unsigned int *) vips_image_write_to_memory(h, &size);
+		g_object_unref(h);
+		if (bins == NULL) {
+			break;
+		}
+		memcpy(*hist + 256 * i, bins, 256 * sizeof(unsigned int));
+		g_free(bins);
+	}
+	g_object_unref(uchar);
+
+	if (i < *bands) {
+		g_free(*stats);
+		g_free(*hist);
+		return -1;
+	}
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"unsafe"
+)
+
+// Columns of the vips_stats matrix
+const (
+	vipsStatsMin = iota
+	vipsStatsMax
+	vipsStatsSum
+	vipsStatsSum2
+	vipsStatsMean
+	vipsStatsDeviation
+	vipsStatsColumns = 10
+)
+
+// vipsImageStats returns the statistics and the histogram of each channel of the image
+// computed by libvips on 8 bit sRGB. bimg does not bind vips_stats and vips_hist_find,
+// so this is the only direct binding of libvips, which is initialized by bimg.
+func vipsImageStats(buf []byte) ([]ChannelStats, error) {
+	if len(buf) == 0 {
+		return nil, errors.New("Empty image")
+	}
+
+	var bands C.int
+	var stats *C.double
+	var hist *C.uint
+
+	ret := C.thumbnary_image_stats(unsafe.Pointer(&buf[0]), C.size_t(len(buf)), &bands, &stats, &hist)
+	if ret != 0 {
+		s := C.GoString(C.vips_error_buffer())
+		C.vips_error_clear()
+		return nil, errors.New(s)
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(stats)))
+	defer C.g_free(C.gpointer(unsafe.Pointer(hist)))
+
+	n := int(bands)
+	statsData := (*[1 << 20]C.double)(unsafe.Pointer(stats))[: (n+1)*vipsStatsColumns : (n+1)*vipsStatsColumns]
+	histData := (*[1 << 20]C.uint)(unsafe.Pointer(hist))[: n*256 : n*256]
+
+	channels := make([]ChannelStats, n)
+	for i := range channels {
+		// The first row is the statistics of all bands
+		row := statsData[(i+1)*vipsStatsColumns:]
+		channels[i] = ChannelStats{
+			Min:       float64(row[vipsStatsMin]),
+			Max:       float64(row[vipsStatsMax]),
+			Mean:      float64(row[vipsStatsMean]),
+			StdDev:    float64(row[vipsStatsDeviation]),
+			Histogram: make([]int, 256),
+		}
+		for j := 0; j < 256; j++ {
+			channels[i].Histogram[j] = int(histData[i*256+j])
+		}
+	}
+
+	return channels, nil
+}
@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Canonical URL modes of the image endpoint
+const (
+	CanonicalURLModeNone     = ""
+	CanonicalURLModeRedirect = "redirect" // 301 redirect to the canonical URL
+	CanonicalURLModeLink     = "link"     // Expose the canonical URL by Link header
+)
+
+// canonicalRequestURL returns the path and query of the request whose params are replaced with the canonical params.
+// The URL is relative to the host, since the scheme of the request is unknown behind the TLS termination.
+func canonicalRequestURL(req *http.Request, params, canonical string) string {
+	path := req.URL.EscapedPath()
+	path = strings.Replace(path, "/c!/"+params+"/", "/c!/"+canonical+"/", 1)
+
+	u := path
+	if req.URL.RawQuery != "" {
+		u += "?" + req.URL.RawQuery
+	}
+	return u
+}
+
+// publicBaseURL returns the public base URL of the server like "https://img.example.com",
+// or empty string if it is not configured, so that the URLs are relative to the host of the request.
+func publicBaseURL(o ServerOptions) string {
+	return strings.TrimSuffix(o.PublicBaseURL, "/")
+}
+
+// requestBaseURL returns the public base URL, or the scheme and host of the request like "https://example.com"
+// for the URLs which must be absolute.
+func requestBaseURL(req *http.Request, o ServerOptions) string {
+	if o.PublicBaseURL != "" {
+		return publicBaseURL(o)
+	}
+	scheme := req.URL.Scheme
+	if scheme == "" {
+		scheme = "http"
+		if req.TLS != nil {
+			scheme = "https"
+		}
+	}
+	return scheme + "://" + req.Host
+}
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCanonicalRequestURL(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/qic0bfzg/c!/h=200,w=300/image.jpg?sig=1.abc", nil)
+	expected := "/qic0bfzg/c!/w=300,h=200/image.jpg?sig=1.abc"
+	if actual := canonicalRequestURL(req, "h=200,w=300", "w=300,h=200"); actual != expected {
+		t.Errorf("Invalid canonical URL: expected %s, but actual %s", expected, actual)
+	}
+}
+
+func TestRequestBaseURL(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/iiif/3/image.jpg/info.json", nil)
+	if actual := requestBaseURL(req, ServerOptions{}); actual != "http://example.com" {
+		t.Errorf("Invalid base URL: %s", actual)
+	}
+	// The public base URL is used behind the TLS termination
+	if actual := requestBaseURL(req, ServerOptions{PublicBaseURL: "https://img.example.com/"}); actual != "https://img.example.com" {
+		t.Errorf("Invalid public base URL: %s", actual)
+	}
+	if actual := publicBaseURL(ServerOptions{}); actual != "" {
+		t.Errorf("The URLs must be relative without the public base URL: %s", actual)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/tsu1980/thumbnary/client"
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+// The URLs of the client package must be parsed and validated by the server as they are built.
+
+func TestClientParamsRoundTrip(t *testing.T) {
+	cases := []*client.Params{
+		client.NewParams(),
+		client.NewParams().NoConvert(),
+		client.NewParams().Quality(80),
+		client.NewParams().Mode(client.ModeCrop),
+		client.NewParams().Size(300, 200),
+		client.NewParams().Width(300).Quality(80),
+		client.NewParams().Width(300).Format(client.FormatDataURI).Quality(80),
+		client.NewParams().Size(300, 200).Upscale().Mode(client.ModePad).Gravity(client.GravitySmart).Background("102030"),
+		client.NewParams().Width(300).Mode(client.ModeScale).Gravity(client.GravityTopLeft).Background("FFF"),
+		client.NewParams().Width(64).Overlay("https://example.com/logo, 1.png", 10, 20).OverlayGravity(client.GravityTopRight).OverlayOpacity(0.25),
+		client.NewParams().Width(100).Monochrome().NoAnimation().Metadata(client.MetadataKeep).Sprite(4, 2).Palette(8).JSON().Format(client.FormatWebP).Quality(60),
+	}
+
+	for _, p := range cases {
+		params := p.String()
+		if actual := processing.CanonicalParams(processing.ReadParams(params, nil)); actual != params {
+			t.Errorf("The client params are not canonical: expected %s, but actual %s", params, actual)
+		}
+	}
+
+	opts := processing.ReadParams(client.NewParams().Size(300, 200).Mode(client.ModeFit).Gravity(client.GravityBottomCenter).Format(client.FormatWebP).String(), nil)
+	if opts.Width != 300 || opts.Height != 200 || opts.ResizeMode != processing.ResizeModeFit || opts.Gravity != processing.Gravity9BottomCenter || opts.OutputFormat != "webp" {
+		t.Errorf("Invalid options of the client params: %+v", opts)
+	}
+
+	opts = processing.ReadParams(client.NewParams().Overlay("https://example.com/logo, 1.png", 0, 0).String(), nil)
+	if overlay, _ := url.PathUnescape(opts.OverlayURL); overlay != "https://example.com/logo, 1.png" {
+		t.Errorf("Invalid overlay URL of the client params: %s", opts.OverlayURL)
+	}
+
+	opts = processing.ReadParams(client.NewParams().Preset("card").Width(400).String(), map[string]string{"card": "w=300,h=200,m=fit"})
+	if opts.Width != 400 || opts.Height != 200 || opts.ResizeMode != processing.ResizeModeFit {
+		t.Errorf("Invalid options of the client params with preset: %+v", opts)
+	}
+}
+
+func TestClientURLSignature(t *testing.T) {
+	testOrigin := &origin.Origin{
+		Slug:                     "jdv9ab8v",
+		URLSignatureEnabled:      true,
+		URLSignatureKey:          "secrettestnew",
+		URLSignatureKey_Previous: "secrettest",
+		URLSignatureKey_Version:  2,
+	}
+	o := ServerOptions{
+		OriginSlugDetectHostPattern: `^([a-z0-9]+)\.example\.com`,
+		OriginSlugDetectPathPattern: `^/([a-z0-9]+)/c!/`,
+		OriginRepos:                 NewMockOriginRepository(map[origin.OriginSlug]*origin.Origin{"jdv9ab8v": testOrigin}),
+	}
+
+	cases := []struct {
+		client client.Client
+		method OriginSlugDetectMethod
+		err    *processing.Error
+	}{
+		{client.Client{BaseURL: "http://jdv9ab8v.example.com", Key: "secrettestnew", KeyVersion: 2}, OriginSlugDetectMethod_Host, nil},
+		{client.Client{BaseURL: "http://example.com", OriginSlug: "jdv9ab8v", OriginMode: client.OriginInPath, Key: "secrettestnew", KeyVersion: 2}, OriginSlugDetectMethod_Path, nil},
+		{client.Client{BaseURL: "http://example.com", OriginSlug: "jdv9ab8v", OriginMode: client.OriginInQuery, Key: "secrettestnew", KeyVersion: 2}, OriginSlugDetectMethod_Query, nil},
+		{client.Client{BaseURL: "http://example.com", OriginSlug: "jdv9ab8v", OriginMode: client.OriginInSignature, Key: "secrettestnew", KeyVersion: 2}, OriginSlugDetectMethod_URLSignature, nil},
+		{client.Client{BaseURL: "http://example.com", OriginSlug: "jdv9ab8v", OriginMode: client.OriginInSignature, Key: "secrettest", KeyVersion: 1}, OriginSlugDetectMethod_URLSignature, nil},
+		{client.Client{BaseURL: "http://example.com", OriginSlug: "jdv9ab8v", OriginMode: client.OriginInQuery, Key: "secrettest", KeyVersion: 2}, OriginSlugDetectMethod_Query, &ErrURLSignatureMismatch},
+		{client.Client{BaseURL: "http://example.com", OriginSlug: "jdv9ab8v", OriginMode: client.OriginInQuery}, OriginSlugDetectMethod_Query, &ErrInvalidURLSignature},
+	}
+
+	params := client.NewParams().Size(300, 200).Overlay("https://example.com/logo.png", 10, 10)
+	for _, c := range cases {
+		u := c.client.URL(params, "photos/my cat.jpg")
+		req := httptest.NewRequest("GET", u, nil)
+
+		sigInfo, err := parseURLSignature(req)
+		if err != nil {
+			t.Errorf("Cannot parse the signature of %s: %s", u, err)
+			continue
+		}
+		imgReq := &ImageRequest{HTTPRequest: req, URLSignatureInfo: sigInfo}
+		o.OriginSlugDetectMethods = []OriginSlugDetectMethod{c.method}
+		if _, err := FindOrigin(imgReq, o); err != nil {
+			t.Errorf("Cannot find the origin of %s: %s", u, err)
+			continue
+		}
+
+		sigErr := validateURLSignature(imgReq)
+		if (sigErr == nil) != (c.err == nil) || (sigErr != nil && sigErr.Message != c.err.Message) {
+			t.Errorf("Unexpected signature validation of %s: expected %v, but actual %v", u, c.err, sigErr)
+		}
+		if !strings.Contains(req.URL.EscapedPath(), "/c!/"+params.String()+"/photos/my%20cat.jpg") {
+			t.Errorf("Invalid path of %s: %s", u, req.URL.EscapedPath())
+		}
+	}
+}
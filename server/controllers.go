@@ -0,0 +1,1157 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsu1980/thumbnary"
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+	"github.com/tsu1980/thumbnary/source"
+	"gopkg.in/h2non/bimg.v1"
+	"gopkg.in/h2non/filetype.v0"
+)
+
+// The maximum size of the JSON params in the body of POST request
+const maxJSONParamsSize = 64 * 1024
+
+type ImageRequest struct {
+	HTTPRequest      *http.Request
+	OriginSlug       origin.OriginSlug
+	Origin           *origin.Origin
+	Options          processing.ImageOptions
+	FilePath         string
+	URLSignatureInfo URLSignatureInfo
+	JSONParams       []byte // The body of POST /j!/ request
+	ImgixParams      bool   // The params are read from the query string
+}
+
+type URLSignatureInfo struct {
+	Version        int
+	SignatureValue string
+	OriginSlug     origin.OriginSlug
+}
+
+func indexController(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		ErrorReply(r, w, ErrNotFound, ServerOptions{})
+		return
+	}
+
+	body, _ := json.Marshal(thumbnary.CurrentVersions)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func healthController(w http.ResponseWriter, r *http.Request) {
+	health := GetHealthStats()
+	body, _ := json.Marshal(health)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func imageController(o ServerOptions) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		imgReq := &ImageRequest{HTTPRequest: req}
+
+		var err error
+		imgReq.URLSignatureInfo, err = parseURLSignature(req)
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+
+		if req.Method == "POST" {
+			imgReq.JSONParams, err = readJSONParamsBody(req)
+			if err != nil {
+				ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+				return
+			}
+		}
+
+		_, err = FindOrigin(imgReq, o)
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+
+		imgReq.ImgixParams = isImgixRequest(req.URL.EscapedPath(), o)
+
+		// Thumbor and imgproxy URLs have their own signatures in the path
+		marker := routeMarker(req.URL.EscapedPath(), o)
+		if imgReq.Origin.URLSignatureEnabled && marker != "th!" && marker != "ip!" {
+			err2 := validateURLSignature(imgReq)
+			if err2 != nil {
+				ErrorReply(req, w, *err2, o)
+				return
+			}
+		}
+
+		switch marker {
+		case "i!":
+			infoHandler(w, req, imgReq, o)
+		case "h!":
+			hashHandler(w, req, imgReq, o)
+		case "q!":
+			qualityHandler(w, req, imgReq, o)
+		case "s!":
+			spriteHandler(w, req, imgReq, o)
+		case "t!":
+			tileHandler(w, req, imgReq, o)
+		case "m!":
+			srcsetHandler(w, req, imgReq, o)
+		case "j!":
+			jsonParamsHandler(w, req, imgReq, o)
+		case "th!":
+			thumborHandler(w, req, imgReq, o)
+		case "ip!":
+			imgproxyHandler(w, req, imgReq, o)
+		case "":
+			// The routes without the marker
+			if isIIIFPath(req.URL.EscapedPath()) {
+				iiifHandler(w, req, imgReq, o)
+			} else if imgReq.ImgixParams {
+				imgixHandler(w, req, imgReq, o)
+			} else {
+				imageHandler(w, req, imgReq, o)
+			}
+		default:
+			imageHandler(w, req, imgReq, o)
+		}
+	}
+}
+
+// routeMarker returns the route marker (e.g. "c!", "i!"), which is the first path segment ending with "!".
+// If the origin slug is detected by the path, the marker is the segment after the origin slug.
+// The other segments belong to the file path, so they are never taken as the marker.
+func routeMarker(path string, o ServerOptions) string {
+	segments := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segments) > 1 && o.OriginSlugDetectPathPattern != "" {
+		group := regexp.MustCompile(o.OriginSlugDetectPathPattern).FindStringSubmatch(path)
+		if len(group) > 1 && group[1] == segments[0] {
+			segments = segments[1:]
+		}
+	}
+
+	if len(segments[0]) > 1 && strings.HasSuffix(segments[0], "!") {
+		return segments[0]
+	}
+	return ""
+}
+
+func imageHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	r := regexp.MustCompile("/c!/([^/]+)/(.+)")
+	// The steps of the pipeline are split by the raw path, in which the separator is not escaped
+	if raw := r.FindStringSubmatch(requestRawPath(req)); raw != nil && len(splitPipelineParams(raw[1])) > 1 {
+		pipeline, err := readPipelineParams(splitPipelineParams(raw[1]), imgReq.Origin, o)
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+		imgReq.FilePath = raw[2]
+		pipelineHandler(w, req, imgReq, pipeline, o)
+		return
+	}
+
+	values := r.FindStringSubmatch(req.URL.EscapedPath())
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", req.URL.EscapedPath())
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if err := validateParams(values[1], imgReq.Origin, o); err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	imgReq.Options = processing.ReadParams(values[1], imgReq.Origin.Presets)
+	//log.Printf("ReadParams: %#v\n", imgReq.Options)
+	imgReq.FilePath = values[2]
+
+	err := validateImageOptions(imgReq.Options, o)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	// The presets are not expanded, since the params of the preset may be changed later
+	if o.CanonicalURLMode != CanonicalURLModeNone && processing.ExpandPresetParams(values[1], imgReq.Origin.Presets) == values[1] {
+		canonical := processing.CanonicalParams(imgReq.Options)
+		canonicalURL := canonicalRequestURL(req, values[1], canonical)
+		// The signed URL cannot be redirected since the signature covers the params
+		redirect := o.CanonicalURLMode == CanonicalURLModeRedirect && imgReq.URLSignatureInfo.SignatureValue == ""
+		if canonical != "" && canonical != values[1] && redirect {
+			http.Redirect(w, req, canonicalURL, http.StatusMovedPermanently)
+			return
+		}
+		if canonical != "" {
+			w.Header().Set("Link", "<"+publicBaseURL(o)+canonicalURL+">; rel=\"canonical\"")
+		}
+	}
+
+	buf, _, err2 := fetchSourceImage(req, imgReq, o)
+	if err2 != nil {
+		ErrorReply(req, w, *err2, o)
+		return
+	}
+
+	opts := imgReq.Options
+	vary := ""
+	if opts.OutputFormat == "auto" {
+		opts.OutputFormat = determineAcceptMimeType(req.Header.Get("Accept"))
+		vary = "Accept" // Ensure caches behave correctly for negotiated content
+
+		// Keep the animation as WebP if accepted, otherwise as GIF
+		if !opts.NoAnimation && processing.IsAnimatedImage(buf) && opts.OutputFormat != "webp" {
+			opts.OutputFormat = "gif"
+		}
+	} else if opts.OutputFormat != "" && processing.ImageType(opts.OutputFormat) == 0 && processing.DataOutputFuncs[opts.OutputFormat] == nil {
+		ErrorReply(req, w, ErrOutputFormat, o)
+		return
+	}
+
+	// Fetch overlay image if necessary
+	if opts.OverlayURL != "" {
+		overlayBuf, err := fetchOverlayImage(req, opts.OverlayURL)
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+		opts.OverlayBuf = overlayBuf
+	}
+
+	opts = applyOriginImageOptions(opts, imgReq.Origin, o)
+
+	imageFunc := processing.ConvertImage
+	if fn, ok := processing.DataOutputFuncs[opts.OutputFormat]; ok {
+		imageFunc = fn
+	}
+	if req.Method == "HEAD" {
+		imageFunc = processing.InfoImage
+	}
+	image, err := imageFunc(buf, opts)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	// Expose Content-Length response header
+	w.Header().Set("Content-Length", strconv.Itoa(len(image.Body)))
+	w.Header().Set("Content-Type", image.Mime)
+	if req.Method == "HEAD" {
+		w.Header()["X-THUMBNARY-METADATA"] = []string{string(image.Body)}
+	} else {
+		if vary != "" {
+			w.Header().Set("Vary", vary)
+		}
+		w.Write(image.Body)
+	}
+}
+
+// jsonParamsHandler processes the image by the JSON params like /j!/<base64url-json>/<path>,
+// or POST /j!/<path> with the JSON body. See processing.ReadJSONParams for the format.
+func jsonParamsHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	path := req.URL.EscapedPath()
+	var blob []byte
+	if imgReq.JSONParams != nil {
+		values := regexp.MustCompile("/j!/(.+)").FindStringSubmatch(path)
+		if values == nil {
+			err := fmt.Errorf("Bad URL format: %s", path)
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+		blob = imgReq.JSONParams
+		imgReq.FilePath = values[1]
+	} else {
+		values := regexp.MustCompile("/j!/([^/]+)/(.+)").FindStringSubmatch(path)
+		if values == nil {
+			err := fmt.Errorf("Bad URL format: %s", path)
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+		var err error
+		blob, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(values[1], "="))
+		if err != nil {
+			ErrorReply(req, w, processing.NewError("Invalid base64url JSON params: "+err.Error(), processing.BadRequest), o)
+			return
+		}
+		imgReq.FilePath = values[2]
+	}
+
+	if imgReq.Origin.PresetsOnly {
+		ErrorReply(req, w, processing.NewError("JSON params are not available for the origin which allows presets only", processing.BadRequest), o)
+		return
+	}
+
+	pipeline, err := processing.ReadJSONParams(blob, isStrictParams(imgReq.Origin, o))
+	if err == nil {
+		err = validatePipeline(pipeline, o)
+	}
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	pipelineHandler(w, req, imgReq, pipeline, o)
+}
+
+// readJSONParamsBody reads the JSON params from the body of POST request
+func readJSONParamsBody(req *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxJSONParamsSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxJSONParamsSize {
+		return nil, fmt.Errorf("JSON params are too large (max=%d bytes)", maxJSONParamsSize)
+	}
+	if len(body) == 0 {
+		return nil, fmt.Errorf("Empty JSON params")
+	}
+	return body, nil
+}
+
+// pipelineHandler processes the image by the validated steps of the pipeline
+// like /c!/<params>|<params>|<params>/<path>. Only the last step determines the output format.
+// HEAD request responds the info of the result image of the pipeline.
+func pipelineHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, pipeline []processing.ImageOptions, o ServerOptions) {
+	var err error
+	last := len(pipeline) - 1
+	imgReq.Options = pipeline[last]
+
+	buf, _, err2 := fetchSourceImage(req, imgReq, o)
+	if err2 != nil {
+		ErrorReply(req, w, *err2, o)
+		return
+	}
+
+	vary := ""
+	if f := pipeline[last].OutputFormat; f == "auto" {
+		pipeline[last].OutputFormat = determineAcceptMimeType(req.Header.Get("Accept"))
+		vary = "Accept" // Ensure caches behave correctly for negotiated content
+	} else if f != "" && processing.ImageType(f) == 0 && processing.DataOutputFuncs[f] == nil {
+		ErrorReply(req, w, ErrOutputFormat, o)
+		return
+	}
+
+	for i := range pipeline {
+		if pipeline[i].OverlayURL != "" {
+			pipeline[i].OverlayBuf, err = fetchOverlayImage(req, pipeline[i].OverlayURL)
+			if err != nil {
+				ErrorReply(req, w, processing.NewError(fmt.Sprintf("Step %d: %s", i+1, err), processing.BadRequest), o)
+				return
+			}
+		}
+		pipeline[i] = applyOriginImageOptions(pipeline[i], imgReq.Origin, o)
+	}
+
+	var image processing.Image
+	if req.Method == "HEAD" {
+		// The info is of the result image, or the image which the data output is computed from
+		if processing.DataOutputFuncs[pipeline[last].OutputFormat] != nil {
+			pipeline[last].OutputFormat = "png"
+		}
+		image, err = processing.PipelineImage(buf, pipeline, o.MaxPipelineMP)
+		if err == nil {
+			image, err = processing.InfoImage(image.Body, pipeline[last])
+		}
+	} else {
+		image, err = processing.PipelineImage(buf, pipeline, o.MaxPipelineMP)
+	}
+	if err != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	w.Header().Set("Content-Length", strconv.Itoa(len(image.Body)))
+	w.Header().Set("Content-Type", image.Mime)
+	if req.Method == "HEAD" {
+		w.Header()["X-THUMBNARY-METADATA"] = []string{string(image.Body)}
+	} else {
+		if vary != "" {
+			w.Header().Set("Vary", vary)
+		}
+		w.Write(image.Body)
+	}
+}
+
+// fetchOverlayImage fetches the overlay image from the escaped URL
+func fetchOverlayImage(req *http.Request, overlayURL string) ([]byte, error) {
+	var overlaySource = source.GetHttpSource()
+	urlUnescaped, err := url.PathUnescape(overlayURL)
+	if err != nil {
+		return nil, err
+	}
+	url, err := url.Parse(urlUnescaped)
+	if err != nil {
+		return nil, err
+	}
+
+	//log.Printf("FetchImage overlay image: %#v", url.String())
+	return overlaySource.FetchImage(url, req)
+}
+
+// applyOriginImageOptions sets the options which are configured per origin or server, not by URL params.
+func applyOriginImageOptions(opts processing.ImageOptions, origin *origin.Origin, o ServerOptions) processing.ImageOptions {
+	opts.MaxAnimationFrames = origin.MaxAnimationFrames
+	opts.MaxAnimationMP = origin.MaxAnimationMP
+	opts.OutputICC = o.OutputICC
+	if origin.OutputICC != "" {
+		opts.OutputICC = origin.OutputICC
+	}
+	if opts.MetadataMode == processing.MetadataModeDefault {
+		opts.MetadataMode = processing.ParseMetadataMode(origin.MetadataMode)
+	}
+	return opts
+}
+
+func infoHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	r := regexp.MustCompile("/i!/(.+)")
+	values := r.FindStringSubmatch(req.URL.EscapedPath())
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", req.URL.EscapedPath())
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	imgReq.Options = processing.ImageOptionsNoConvert
+	imgReq.FilePath = values[1]
+
+	buf, mimeType, err := fetchSourceImage(req, imgReq, o)
+	if err != nil {
+		ErrorReply(req, w, *err, o)
+		return
+	}
+
+	image, err2 := processing.DetailImage(buf, mimeType, imgReq.Origin.ExposeGPSMetadata)
+	if err2 != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err2.Error(), processing.BadRequest), o)
+		return
+	}
+
+	writeDataReply(w, req, image)
+}
+
+// hashHandler responds the perceptual hashes of the image.
+// When the second path is given like /h!/<path>/h!/<path2>, it responds the Hamming distances of the two images.
+func hashHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	r := regexp.MustCompile("/h!/(.+?)(?:/h!/(.+))?$")
+	values := r.FindStringSubmatch(req.URL.EscapedPath())
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", req.URL.EscapedPath())
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	imgReq.Options = processing.ImageOptionsNoConvert
+	imgReq.FilePath = values[1]
+
+	buf, _, err := fetchSourceImage(req, imgReq, o)
+	if err != nil {
+		ErrorReply(req, w, *err, o)
+		return
+	}
+
+	var image processing.Image
+	var err2 error
+	if values[2] == "" {
+		image, err2 = processing.PerceptualHashImage(buf)
+	} else {
+		targetReq := *imgReq
+		targetReq.FilePath = values[2]
+		targetBuf, _, err := fetchSourceImage(req, &targetReq, o)
+		if err != nil {
+			ErrorReply(req, w, *err, o)
+			return
+		}
+		image, err2 = processing.ComparePerceptualHashImage(buf, targetBuf)
+	}
+	if err2 != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err2.Error(), processing.BadRequest), o)
+		return
+	}
+
+	writeDataReply(w, req, image)
+}
+
+// qualityHandler converts the image like /c!/ and responds the quality loss and the byte savings
+// of the output compared to the source image.
+func qualityHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	r := regexp.MustCompile("/q!/([^/]+)/(.+)")
+	values := r.FindStringSubmatch(req.URL.EscapedPath())
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", req.URL.EscapedPath())
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if err := validateParams(values[1], imgReq.Origin, o); err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	imgReq.Options = processing.ReadParams(values[1], imgReq.Origin.Presets)
+	imgReq.FilePath = values[2]
+
+	err := validateImageOptions(imgReq.Options, o)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	if imgReq.Options.OutputFormat == "auto" || processing.DataOutputFuncs[imgReq.Options.OutputFormat] != nil {
+		ErrorReply(req, w, ErrOutputFormat, o)
+		return
+	}
+
+	buf, _, err2 := fetchSourceImage(req, imgReq, o)
+	if err2 != nil {
+		ErrorReply(req, w, *err2, o)
+		return
+	}
+
+	opts := applyOriginImageOptions(imgReq.Options, imgReq.Origin, o)
+	image, err := processing.QualityImage(buf, opts)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	writeDataReply(w, req, image)
+}
+
+// spriteHandler composites the images into a sprite sheet like /s!/<params>/<path1>/s!/<path2>/s!/<path3>.
+// The tile map is exposed by the response header, or responded as JSON by json=true param.
+func spriteHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	r := regexp.MustCompile("/s!/([^/]+)/(.+)")
+	values := r.FindStringSubmatch(req.URL.EscapedPath())
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", req.URL.EscapedPath())
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if err := validateParams(values[1], imgReq.Origin, o); err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	imgReq.Options = processing.ReadParams(values[1], imgReq.Origin.Presets)
+	paths := strings.Split(values[2], "/s!/")
+
+	err := processing.ValidateSpriteOptions(imgReq.Options, len(paths), o.MaxOutputMP)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	if f := imgReq.Options.OutputFormat; f != "" && processing.ImageType(f) == bimg.UNKNOWN {
+		ErrorReply(req, w, ErrOutputFormat, o)
+		return
+	}
+
+	// Fetch the source images concurrently
+	bufs := make([][]byte, len(paths))
+	errs := make([]*processing.Error, len(paths))
+	processing.ForEachConcurrently(len(paths), processing.SpriteConcurrency, func(i int) {
+		tileReq := *imgReq
+		tileReq.FilePath = paths[i]
+		bufs[i], _, errs[i] = fetchSourceImage(req, &tileReq, o)
+	})
+	for _, err := range errs {
+		if err != nil {
+			ErrorReply(req, w, *err, o)
+			return
+		}
+	}
+
+	// The overlay is composed on each tile
+	opts := imgReq.Options
+	if opts.OverlayURL != "" {
+		overlayBuf, err := fetchOverlayImage(req, opts.OverlayURL)
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+		opts.OverlayBuf = overlayBuf
+	}
+
+	opts = applyOriginImageOptions(opts, imgReq.Origin, o)
+	image, sprite, err := processing.SpriteImage(bufs, paths, opts)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if opts.JSONResponse {
+		writeDataReply(w, req, processing.Image{Body: sprite.JSON(), Mime: "application/json"})
+		return
+	}
+	w.Header()["X-THUMBNARY-SPRITE"] = []string{string(sprite.JSON())}
+	writeDataReply(w, req, image)
+}
+
+// thumborHandler processes the image by Thumbor URL like /th!/unsafe/300x200/smart/<path>.
+func thumborHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	path := req.URL.EscapedPath()
+	thumborReq, err := parseThumborPath(path[strings.Index(path, "/th!/")+len("/th!/"):])
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	if err := validateThumborSignature(thumborReq, imgReq.Origin); err != nil {
+		ErrorReply(req, w, *err, o)
+		return
+	}
+	if imgReq.Origin.PresetsOnly {
+		ErrorReply(req, w, processing.NewError("Thumbor URL is not available for the origin which allows presets only", processing.BadRequest), o)
+		return
+	}
+
+	imgReq.FilePath = thumborReq.Image
+	mappedImageHandler(w, req, imgReq, o, func(width, height int) (processing.ImageOptions, error) {
+		return ThumborImageOptions(thumborReq, width, height)
+	})
+}
+
+// imgproxyHandler processes the image by imgproxy URL like /ip!/<signature>/rs:fill:300:200/plain/<path>@webp.
+// The source is the path of the image in the origin, as well as the other routes.
+// The absolute source URL like plain/https://... is rejected, since the image is always fetched from the origin.
+func imgproxyHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	path := req.URL.EscapedPath()
+	imgproxyReq, err := parseImgproxyPath(path[strings.Index(path, "/ip!/")+len("/ip!/"):])
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	if err := validateImgproxySignature(imgproxyReq, imgReq.Origin, o); err != nil {
+		ErrorReply(req, w, *err, o)
+		return
+	}
+	if imgReq.Origin.PresetsOnly {
+		ErrorReply(req, w, processing.NewError("imgproxy URL is not available for the origin which allows presets only", processing.BadRequest), o)
+		return
+	}
+
+	imgReq.FilePath = imgproxyReq.Source
+	mappedImageHandler(w, req, imgReq, o, func(width, height int) (processing.ImageOptions, error) {
+		return ImgproxyImageOptions(imgproxyReq, width, height, time.Now())
+	})
+}
+
+// imgixHandler processes the image by the imgix params of the query string like /path/to/image.jpg?w=300&fit=crop.
+func imgixHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	if imgReq.Origin.PresetsOnly {
+		ErrorReply(req, w, processing.NewError("imgix params are not available for the origin which allows presets only", processing.BadRequest), o)
+		return
+	}
+
+	imgReq.FilePath = strings.TrimPrefix(req.URL.EscapedPath(), "/")
+	// The path may be prefixed by the origin slug
+	if o.OriginSlugDetectPathPattern != "" {
+		if slug, _ := OriginSlugDetectFunc_Path(o, imgReq); slug == imgReq.OriginSlug {
+			imgReq.FilePath = strings.TrimPrefix(imgReq.FilePath, string(slug)+"/")
+		}
+	}
+	mappedImageHandler(w, req, imgReq, o, func(width, height int) (processing.ImageOptions, error) {
+		return ImgixImageOptions(req.URL.Query(), width, height)
+	})
+}
+
+// mappedImageHandler processes the image by the options which are mapped from the URL of the other image server.
+// The mapping depends on the size of the source image.
+func mappedImageHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions, mapOptions func(width, height int) (processing.ImageOptions, error)) {
+	imgReq.Options = processing.ImageOptionsNoConvert
+
+	buf, _, err2 := fetchSourceImage(req, imgReq, o)
+	if err2 != nil {
+		ErrorReply(req, w, *err2, o)
+		return
+	}
+
+	width, height, err := processing.OrientedImageSize(buf)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	opts, err := mapOptions(width, height)
+	if err != nil {
+		if e, ok := err.(processing.Error); ok {
+			ErrorReply(req, w, e, o)
+		} else {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		}
+		return
+	}
+	if err := validateImageOptions(opts, o); err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if opts.OverlayURL != "" {
+		overlayBuf, err := fetchOverlayImage(req, opts.OverlayURL)
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+		opts.OverlayBuf = overlayBuf
+	}
+
+	if opts.OutputFormat == "auto" {
+		opts.OutputFormat = determineAcceptMimeType(req.Header.Get("Accept"))
+		w.Header().Set("Vary", "Accept") // Ensure caches behave correctly for negotiated content
+
+		// Keep the animation as WebP if accepted, otherwise as GIF
+		if !opts.NoAnimation && processing.IsAnimatedImage(buf) && opts.OutputFormat != "webp" {
+			opts.OutputFormat = "gif"
+		}
+	}
+
+	opts = applyOriginImageOptions(opts, imgReq.Origin, o)
+
+	imageFunc := processing.ConvertImage
+	if fn, ok := processing.DataOutputFuncs[opts.OutputFormat]; ok {
+		imageFunc = fn
+	}
+	if req.Method == "HEAD" {
+		imageFunc = processing.InfoImage
+	}
+	image, err := imageFunc(buf, opts)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	writeDataReply(w, req, image)
+}
+
+// iiifHandler serves IIIF Image API 3.0 requests like /iiif/3/{identifier}/{region}/{size}/{rotation}/{quality}.{format}
+// and /iiif/3/{identifier}/info.json. The identifier is the file path, whose slashes are escaped as %2F.
+func iiifHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	values := iiifPathPattern.FindStringSubmatch(req.URL.EscapedPath())
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", req.URL.EscapedPath())
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	// The base URI of the image must redirect to info.json
+	if values[2] == "" {
+		http.Redirect(w, req, strings.TrimSuffix(req.URL.EscapedPath(), "/")+"/info.json", http.StatusSeeOther)
+		return
+	}
+
+	var iiifReq IIIFImageRequest
+	if values[2] != "info.json" {
+		var err error
+		iiifReq, err = parseIIIFImageRequest(values[2])
+		if err != nil {
+			ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+			return
+		}
+	}
+
+	imgReq.Options = processing.ImageOptionsNoConvert
+	imgReq.FilePath = strings.NewReplacer("%2F", "/", "%2f", "/").Replace(values[1])
+
+	buf, _, err := fetchSourceImage(req, imgReq, o)
+	if err != nil {
+		ErrorReply(req, w, *err, o)
+		return
+	}
+
+	width, height, err2 := processing.OrientedImageSize(buf)
+	if err2 != nil {
+		ErrorReply(req, w, processing.NewError(err2.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if values[2] == "info.json" {
+		// The query like ?origin= is kept to find the origin of the image
+		id := requestBaseURL(req, o) + strings.TrimSuffix(req.URL.EscapedPath(), "/info.json")
+		if req.URL.RawQuery != "" {
+			id += "?" + req.URL.RawQuery
+		}
+
+		mime := "application/json"
+		if strings.Contains(req.Header.Get("Accept"), "application/ld+json") {
+			mime = "application/ld+json;profile=\"" + iiifContext + "\""
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		writeDataReply(w, req, processing.Image{Body: NewIIIFInfo(id, width, height, o), Mime: mime})
+		return
+	}
+
+	opts, err2 := IIIFImageOptions(iiifReq, width, height, o)
+	if err2 != nil {
+		if e, ok := err2.(processing.Error); ok {
+			ErrorReply(req, w, e, o)
+		} else {
+			ErrorReply(req, w, processing.NewError(err2.Error(), processing.BadRequest), o)
+		}
+		return
+	}
+	if err := validateImageOptions(opts, o); err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	opts = applyOriginImageOptions(opts, imgReq.Origin, o)
+	image, err2 := processing.ConvertImage(buf, opts)
+	if err2 != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err2.Error(), processing.BadRequest), o)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Link", "<"+iiifContext+">;rel=\"profile\"")
+	writeDataReply(w, req, image)
+}
+
+// tileHandler serves the deep zoom tile pyramid of the image like /t!/{z}/{x}/{y}/<path>,
+// and its descriptor like /t!/<path>.dzi
+func tileHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	path := req.URL.EscapedPath()
+	tile := regexp.MustCompile(`/t!/(\d+)/(\d+)/(\d+)/(.+)`).FindStringSubmatch(path)
+	dzi := regexp.MustCompile(`/t!/(.+)\.dzi$`).FindStringSubmatch(path)
+	switch {
+	case tile != nil:
+		imgReq.FilePath = tile[4]
+	case dzi != nil:
+		imgReq.FilePath = dzi[1]
+	default:
+		err := fmt.Errorf("Bad URL format: %s", path)
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	imgReq.Options = processing.ImageOptionsNoConvert
+
+	buf, err := fetchTileSourceImage(req, imgReq, o)
+	if err != nil {
+		ErrorReply(req, w, *err, o)
+		return
+	}
+
+	var image processing.Image
+	var err2 error
+	if tile != nil {
+		z, _ := strconv.Atoi(tile[1])
+		x, _ := strconv.Atoi(tile[2])
+		y, _ := strconv.Atoi(tile[3])
+		opts := applyOriginImageOptions(processing.ImageOptions{}, imgReq.Origin, o)
+		image, err2 = processing.TileImage(buf, z, x, y, opts)
+	} else {
+		image, err2 = processing.DeepZoomDescriptorImage(buf)
+	}
+	if err2 != nil {
+		ErrorReply(req, w, processing.NewError("Error while processing the image: "+err2.Error(), processing.BadRequest), o)
+		return
+	}
+
+	writeDataReply(w, req, image)
+}
+
+// fetchTileSourceImage fetches the source image of the tiles, or reuses the cached one,
+// since a viewer requests many tiles of the same image at once.
+// The image fetched by the forwarded credentials of the caller is never cached, so that it is not served to the other callers.
+func fetchTileSourceImage(req *http.Request, imgReq *ImageRequest, o ServerOptions) ([]byte, *processing.Error) {
+	if forwardsAuthorization(req, o) {
+		buf, _, err := fetchSourceImage(req, imgReq, o)
+		return buf, err
+	}
+
+	key := string(imgReq.OriginSlug) + ":" + imgReq.FilePath
+	if buf, ok := tileSourceCache.Get(key); ok {
+		return buf, nil
+	}
+
+	buf, _, err := fetchSourceImage(req, imgReq, o)
+	if err != nil {
+		return nil, err
+	}
+	tileSourceCache.Add(key, buf)
+	return buf, nil
+}
+
+// forwardsAuthorization returns true if the authorization header of the request is forwarded to the image source.
+func forwardsAuthorization(req *http.Request, o ServerOptions) bool {
+	return o.AuthForwarding && o.Authorization == "" &&
+		(req.Header.Get("X-Forward-Authorization") != "" || req.Header.Get("Authorization") != "")
+}
+
+// srcsetHandler responds the srcset manifest of the image like /m!/widths=320:640,dprs=1:2/<params>/<path>.
+// The URLs of the variants are signed by the current key of the origin, so the manifest request itself
+// must be signed when the origin requires URL signatures.
+// The <picture> element is responded by html=true query, with optional sizes and alt queries.
+func srcsetHandler(w http.ResponseWriter, req *http.Request, imgReq *ImageRequest, o ServerOptions) {
+	path := req.URL.EscapedPath()
+	r := regexp.MustCompile("/m!/([^/]+)/([^/]+)/(.+)")
+	values := r.FindStringSubmatch(path)
+	if values == nil {
+		err := fmt.Errorf("Bad URL format: %s", path)
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	so, err := parseSrcsetOptions(values[1])
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	// The variants add the size params to the template
+	if imgReq.Origin.PresetsOnly {
+		ErrorReply(req, w, processing.NewError("Srcset manifest is not available for the origin which allows presets only", processing.BadRequest), o)
+		return
+	}
+	if err := validateParams(values[2], imgReq.Origin, o); err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+	template := processing.ExpandPresetParams(values[2], imgReq.Origin.Presets)
+	imgReq.FilePath = values[3]
+
+	// The URLs are relative to the host unless the public base URL is configured
+	host := publicBaseURL(o)
+	// The path may be prefixed by the origin slug
+	prefix := path[:strings.Index(path, "/m!/")]
+
+	query := req.URL.Query()
+	for _, key := range []string{"sig", "html", "sizes", "alt"} {
+		query.Del(key)
+	}
+
+	urlFunc := func(params string) string {
+		variantPath := prefix + "/c!/" + params + "/" + imgReq.FilePath
+		q := url.Values{}
+		for k, v := range query {
+			q[k] = v
+		}
+		origin := imgReq.Origin
+		if origin.URLSignatureEnabled {
+			q.Set("sig", CreateURLSignatureString(origin.URLSignatureKey_Version, variantPath, origin.URLSignatureKey, imgReq.URLSignatureInfo.OriginSlug))
+		}
+		u := host + variantPath
+		if len(q) > 0 {
+			u += "?" + q.Encode()
+		}
+		return u
+	}
+
+	manifest, err := NewSrcsetManifest(template, so, urlFunc, o)
+	if err != nil {
+		ErrorReply(req, w, processing.NewError(err.Error(), processing.BadRequest), o)
+		return
+	}
+
+	if processing.ParseBool(req.URL.Query().Get("html")) {
+		body := manifest.HTML(req.URL.Query().Get("sizes"), req.URL.Query().Get("alt"))
+		writeDataReply(w, req, processing.Image{Body: body, Mime: "text/html; charset=utf-8"})
+		return
+	}
+	writeDataReply(w, req, processing.Image{Body: manifest.JSON(), Mime: "application/json"})
+}
+
+// writeDataReply writes the data computed from the image (e.g. JSON) as the response.
+func writeDataReply(w http.ResponseWriter, req *http.Request, image processing.Image) {
+	w.Header().Set("Content-Length", strconv.Itoa(len(image.Body)))
+	w.Header().Set("Content-Type", image.Mime)
+	if req.Method != "HEAD" {
+		w.Write(image.Body)
+	}
+}
+
+// fetchSourceImage fetches the source image of the request through the origin's image source
+// and returns it with its MIME type inferred from the body.
+func fetchSourceImage(req *http.Request, imgReq *ImageRequest, o ServerOptions) ([]byte, string, *processing.Error) {
+	imageSource := source.GetSource(imgReq.Origin.SourceType)
+	if imageSource == nil {
+		return nil, "", &ErrMissingImageSource
+	}
+	var isURLSignaturePresent = imgReq.URLSignatureInfo.SignatureValue != ""
+	var isExternalHTTPSource = false
+	if o.AllowExternalHTTPSource &&
+		isURLSignaturePresent &&
+		(strings.HasPrefix(imgReq.FilePath, "http%3A%2F%2F") || strings.HasPrefix(imgReq.FilePath, "https%3A%2F%2F")) {
+		imageSource = source.GetSource(origin.SourceTypeHTTP)
+		isExternalHTTPSource = true
+	}
+	if !isExternalHTTPSource && hasParentSegment(imgReq.FilePath) {
+		return nil, "", &ErrInvalidFilePath
+	}
+
+	buf, err := imageSource.GetImage(req, imgReq.Origin, imgReq.FilePath, isExternalHTTPSource)
+	if err != nil {
+		e := processing.NewError(err.Error(), processing.BadRequest)
+		return nil, "", &e
+	}
+
+	if len(buf) == 0 {
+		return nil, "", &ErrEmptyBody
+	}
+
+	// Infer the body MIME type via mimesniff algorithm
+	mimeType := http.DetectContentType(buf)
+
+	// If cannot infer the type, infer it via magic numbers
+	if mimeType == "application/octet-stream" {
+		kind, err := filetype.Get(buf)
+		if err == nil && kind.MIME.Value != "" {
+			mimeType = kind.MIME.Value
+		}
+	}
+
+	// Infer text/plain responses as potential SVG image
+	if strings.Contains(mimeType, "text/plain") && len(buf) > 8 {
+		if bimg.IsSVGImage(buf) {
+			mimeType = "image/svg+xml"
+		}
+	}
+
+	// Finally check if image MIME type is supported
+	if processing.IsImageMimeTypeSupported(mimeType) == false {
+		return nil, "", &ErrUnsupportedMedia
+	}
+
+	return buf, mimeType, nil
+}
+
+// hasParentSegment reports whether the escaped file path has ".." segment,
+// which would escape the path prefix of the origin.
+func hasParentSegment(filePath string) bool {
+	if unescaped, err := url.PathUnescape(filePath); err == nil {
+		filePath = unescaped
+	}
+	for _, segment := range strings.Split(strings.Replace(filePath, "\\", "/", -1), "/") {
+		if segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// version := "1"
+// value := BASE64URL(HMAC-SHA-256(SigningKey, Path))
+// originSlug := "ks8vm" + "-"	// Optional
+// signature := originSlug + version + "." + value
+//
+// CreateURLSignatureString(1, "/c!/w=300/testdata/large.jpg", "secrettext", "")
+func CreateURLSignatureString(version int, path string, key string, originSlug origin.OriginSlug) string {
+	var b strings.Builder
+
+	if originSlug != "" {
+		b.WriteString(string(originSlug) + "-")
+	}
+
+	b.WriteString(strconv.Itoa(version) + ".")
+
+	sigVal := CalcURLSignatureValue(path, key)
+	b.WriteString(sigVal)
+
+	return b.String()
+}
+
+func CalcURLSignatureValue(path string, key string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func parseURLSignature(req *http.Request) (URLSignatureInfo, error) {
+	sigVal := req.URL.Query().Get("sig")
+	if sigVal == "" {
+		return URLSignatureInfo{}, nil
+	}
+
+	// parse URL signature string
+	r := regexp.MustCompile(`([a-z0-9]+-)?(\d+)\.([A-Za-z0-9-_=]+)`)
+	m := r.FindStringSubmatch(sigVal)
+	mlen := len(m)
+	if m == nil || (mlen != 4 && mlen != 3) {
+		return URLSignatureInfo{}, fmt.Errorf("Bad URL signature format: %s", sigVal)
+	}
+
+	sigInfo := URLSignatureInfo{}
+
+	if mlen == 4 {
+		d, _ := strconv.Atoi(m[2])
+		sigInfo.Version = d
+		sigInfo.SignatureValue = m[3]
+		sigInfo.OriginSlug = origin.OriginSlug(strings.TrimSuffix(m[1], "-"))
+	} else {
+		d, _ := strconv.Atoi(m[1])
+		sigInfo.Version = d
+		sigInfo.SignatureValue = m[2]
+	}
+
+	return sigInfo, nil
+}
+
+func validateURLSignature(imgReq *ImageRequest) *processing.Error {
+	if imgReq.URLSignatureInfo.Version < 1 || imgReq.URLSignatureInfo.SignatureValue == "" {
+		return &ErrInvalidURLSignature
+	}
+
+	// Compute expected URL signature
+	var sigKey string
+	if imgReq.URLSignatureInfo.Version == imgReq.Origin.URLSignatureKey_Version {
+		sigKey = imgReq.Origin.URLSignatureKey
+	} else if imgReq.URLSignatureInfo.Version == (imgReq.Origin.URLSignatureKey_Version - 1) {
+		sigKey = imgReq.Origin.URLSignatureKey_Previous
+	} else {
+		return &ErrURLSignatureExpired
+	}
+	sigValExpected := CalcURLSignatureValue(urlSignaturePath(imgReq), sigKey)
+
+	if strings.Compare(imgReq.URLSignatureInfo.SignatureValue, sigValExpected) != 0 {
+		return &ErrURLSignatureMismatch
+	}
+
+	return nil
+}
+
+// urlSignaturePath returns the signed path of the request.
+// The JSON params of POST request are signed as the equivalent /j!/<base64url-json>/<path> URL.
+// The imgix params are signed with the path as the query string except the signature, in the order given.
+// The path is signed as it is sent, so that the pipeline separator "|" is signed as is.
+func urlSignaturePath(imgReq *ImageRequest) string {
+	path := requestRawPath(imgReq.HTTPRequest)
+	if imgReq.JSONParams != nil {
+		blob := base64.RawURLEncoding.EncodeToString(imgReq.JSONParams)
+		path = strings.Replace(path, "/j!/", "/j!/"+blob+"/", 1)
+	}
+	if imgReq.ImgixParams {
+		var params []string
+		for _, param := range strings.Split(imgReq.HTTPRequest.URL.RawQuery, "&") {
+			if param != "" && !strings.HasPrefix(param, "sig=") {
+				params = append(params, param)
+			}
+		}
+		if len(params) > 0 {
+			path += "?" + strings.Join(params, "&")
+		}
+	}
+	return path
+}
+
+func determineAcceptMimeType(accept string) string {
+	for _, v := range strings.Split(accept, ",") {
+		mediatype, _, _ := mime.ParseMediaType(v)
+		if mediatype == "image/webp" {
+			return "webp"
+		} else if mediatype == "image/png" {
+			return "png"
+		} else if mediatype == "image/jpeg" {
+			return "jpeg"
+		}
+	}
+	// default
+	return ""
+}
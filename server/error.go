@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/tsu1980/thumbnary/processing"
+	"gopkg.in/h2non/bimg.v1"
+)
+
+var (
+	ErrNotFound             = processing.NewError("Not found", processing.NotFound)
+	ErrInvalidApiKey        = processing.NewError("Invalid or missing API key", processing.Unauthorized)
+	ErrMethodNotAllowed     = processing.NewError("Method not allowed", processing.NotAllowed)
+	ErrUnsupportedMedia     = processing.NewError("Unsupported media type", processing.Unsupported)
+	ErrOutputFormat         = processing.NewError("Unsupported output image format", processing.BadRequest)
+	ErrEmptyBody            = processing.NewError("Empty image", processing.BadRequest)
+	ErrMissingParamFile     = processing.NewError("Missing required param: file", processing.BadRequest)
+	ErrInvalidFilePath      = processing.NewError("Invalid file path", processing.BadRequest)
+	ErrInvalidImageURL      = processing.NewError("Invalid image URL", processing.BadRequest)
+	ErrMissingImageSource   = processing.NewError("Cannot process the image due to missing or invalid params", processing.BadRequest)
+	ErrNotImplemented       = processing.NewError("Not implemented endpoint", processing.NotImplemented)
+	ErrInvalidURLSignature  = processing.NewError("Invalid URL signature", processing.BadRequest)
+	ErrURLSignatureMismatch = processing.NewError("URL signature mismatch", processing.Forbidden)
+	ErrURLSignatureExpired  = processing.NewError("URL signature expired", processing.Forbidden)
+)
+
+func replyWithPlaceholder(req *http.Request, w http.ResponseWriter, err processing.Error, o ServerOptions) error {
+	image := o.PlaceholderImage
+
+	// Resize placeholder to expected output
+	buf, _err := bimg.Resize(o.PlaceholderImage, bimg.Options{
+		Force:   true,
+		Crop:    true,
+		Enlarge: true,
+		Width:   processing.ParseInt(req.URL.Query().Get("width")),
+		Height:  processing.ParseInt(req.URL.Query().Get("height")),
+		Type:    processing.ImageType(req.URL.Query().Get("type")),
+	})
+
+	if _err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("{\"error\":\"%s\", \"code\": %d}", _err.Error(), processing.BadRequest)))
+		return _err
+	}
+
+	// Use final response body image
+	image = buf
+
+	// Placeholder image response
+	w.Header().Set("Content-Type", processing.GetImageMimeType(bimg.DetermineImageType(image)))
+	w.Header().Set("Error", string(err.JSON()))
+	w.WriteHeader(err.HTTPCode())
+	w.Write(image)
+	return err
+}
+
+func ErrorReply(req *http.Request, w http.ResponseWriter, err processing.Error, o ServerOptions) error {
+	// Reply with placeholder if required
+	if o.EnablePlaceholder || o.Placeholder != "" {
+		return replyWithPlaceholder(req, w, err, o)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.HTTPCode())
+	w.Write(err.JSON())
+	return err
+}
@@ -1,9 +1,10 @@
-package main
+package server
 
 import (
-	"math"
 	"runtime"
 	"time"
+
+	"github.com/tsu1980/thumbnary/processing"
 )
 
 var start = time.Now()
@@ -46,14 +47,5 @@ func GetUptime() int64 {
 }
 
 func toMegaBytes(bytes uint64) float64 {
-	return toFixed(float64(bytes)/MB, 2)
-}
-
-func round(num float64) int {
-	return int(num + math.Copysign(0.5, num))
-}
-
-func toFixed(num float64, precision int) float64 {
-	output := math.Pow(10, float64(precision))
-	return float64(round(num*output)) / output
+	return processing.ToFixed(float64(bytes)/MB, 2)
 }
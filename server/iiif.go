@@ -0,0 +1,287 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+const (
+	iiifContext  = "http://iiif.io/api/image/3/context.json"
+	iiifProtocol = "http://iiif.io/api/image"
+)
+
+// The IIIF route may be prefixed by the origin slug, but not by the route of the other endpoints
+var iiifPathPattern = regexp.MustCompile(`^(?:/[^/!]+)?/iiif/3/([^/]+)(?:/(.*))?$`)
+
+var iiifFormats = map[string]string{
+	"jpg":  "jpeg",
+	"png":  "png",
+	"webp": "webp",
+	"gif":  "gif",
+	"tif":  "tiff",
+}
+
+// IIIFInfo represents info.json of IIIF Image API 3.0
+type IIIFInfo struct {
+	Context        string   `json:"@context"`
+	ID             string   `json:"id"`
+	Type           string   `json:"type"`
+	Protocol       string   `json:"protocol"`
+	Profile        string   `json:"profile"`
+	Width          int      `json:"width"`
+	Height         int      `json:"height"`
+	MaxArea        int      `json:"maxArea,omitempty"`
+	ExtraQualities []string `json:"extraQualities"`
+	ExtraFormats   []string `json:"extraFormats"`
+	ExtraFeatures  []string `json:"extraFeatures"`
+}
+
+// IIIFImageRequest represents the parameters of IIIF image request
+type IIIFImageRequest struct {
+	Region   string
+	Size     string
+	Rotation string
+	Quality  string
+	Format   string
+}
+
+// isIIIFPath returns true if the path is IIIF Image API route ([/{origin slug}]/iiif/3/{identifier}/...)
+func isIIIFPath(path string) bool {
+	return iiifPathPattern.MatchString(path)
+}
+
+// NewIIIFInfo returns info.json of the image which has the given size.
+func NewIIIFInfo(id string, width, height int, o ServerOptions) []byte {
+	info := IIIFInfo{
+		Context:        iiifContext,
+		ID:             id,
+		Type:           "ImageService3",
+		Protocol:       iiifProtocol,
+		Profile:        "level1",
+		Width:          width,
+		Height:         height,
+		MaxArea:        o.MaxOutputMP * 1000000,
+		ExtraQualities: []string{"color", "gray"},
+		ExtraFormats:   iiifExtraFormats(),
+		ExtraFeatures:  []string{"mirroring", "regionByPct", "rotationBy90s", "sizeByConfinedWh", "sizeByPct", "sizeUpscaling"},
+	}
+
+	buf, _ := json.Marshal(info)
+	return buf
+}
+
+// iiifExtraFormats returns the formats other than jpg, which libvips can save.
+func iiifExtraFormats() []string {
+	formats := []string{}
+	for _, ext := range []string{"png", "webp", "gif", "tif"} {
+		if processing.IsImageTypeSaveSupported(iiifFormats[ext]) {
+			formats = append(formats, ext)
+		}
+	}
+	return formats
+}
+
+// parseIIIFImageRequest parses {region}/{size}/{rotation}/{quality}.{format}
+func parseIIIFImageRequest(path string) (IIIFImageRequest, error) {
+	parts := strings.Split(path, "/")
+	if len(parts) != 4 {
+		return IIIFImageRequest{}, processing.NewError("Invalid IIIF image request: "+path, processing.BadRequest)
+	}
+
+	dot := strings.LastIndex(parts[3], ".")
+	if dot < 0 {
+		return IIIFImageRequest{}, processing.NewError("Missing IIIF format: "+path, processing.BadRequest)
+	}
+
+	return IIIFImageRequest{
+		Region:   parts[0],
+		Size:     parts[1],
+		Rotation: parts[2],
+		Quality:  parts[3][:dot],
+		Format:   parts[3][dot+1:],
+	}, nil
+}
+
+// IIIFImageOptions maps the IIIF image request onto ImageOptions for the image which has the given size.
+func IIIFImageOptions(r IIIFImageRequest, width, height int, o ServerOptions) (processing.ImageOptions, error) {
+	opts := processing.ImageOptions{ResizeMode: processing.ResizeModeScale, Force: true}
+
+	x, y, rw, rh, err := parseIIIFRegion(r.Region, width, height)
+	if err != nil {
+		return opts, err
+	}
+	if x != 0 || y != 0 || rw != width || rh != height {
+		opts.Clip = []int{x, y, x + rw, y + rh}
+	}
+
+	opts.Width, opts.Height, opts.Upscale, err = parseIIIFSize(r.Size, rw, rh, o.MaxOutputMP*1000000)
+	if err != nil {
+		return opts, err
+	}
+
+	rotation := strings.TrimPrefix(r.Rotation, "!")
+	mirror := rotation != r.Rotation
+	degree, err := strconv.ParseFloat(rotation, 64)
+	if err != nil || degree < 0 || degree > 360 {
+		return opts, processing.NewError("Invalid IIIF rotation: "+r.Rotation, processing.BadRequest)
+	}
+	if math.Mod(degree, 90) != 0 {
+		return opts, processing.NewError("Arbitrary IIIF rotation is not supported: "+r.Rotation, processing.NotImplemented)
+	}
+	opts.Rotate = int(degree) % 360
+	if mirror {
+		// IIIF mirrors before the rotation, but libvips mirrors after the rotation
+		opts.Flop = true
+		opts.Rotate = (360 - opts.Rotate) % 360
+	}
+	if opts.Rotate == 90 || opts.Rotate == 270 {
+		// libvips resizes after the rotation
+		opts.Width, opts.Height = opts.Height, opts.Width
+	}
+
+	switch r.Quality {
+	case "default", "color":
+	case "gray":
+		opts.Monochrome = true
+	case "bitonal":
+		return opts, processing.NewError("IIIF quality is not supported: "+r.Quality, processing.NotImplemented)
+	default:
+		return opts, processing.NewError("Invalid IIIF quality: "+r.Quality, processing.BadRequest)
+	}
+
+	format, ok := iiifFormats[r.Format]
+	if !ok {
+		return opts, processing.NewError("IIIF format is not supported: "+r.Format, processing.NotImplemented)
+	}
+	// The image must not be converted to JPEG silently, since the format is in the URL
+	if !processing.IsImageTypeSaveSupported(format) {
+		return opts, processing.NewError("IIIF format cannot be saved: "+r.Format, processing.BadRequest)
+	}
+	opts.OutputFormat = format
+
+	return opts, nil
+}
+
+// parseIIIFRegion returns the region in pixels: full, square, x,y,w,h or pct:x,y,w,h
+func parseIIIFRegion(region string, width, height int) (int, int, int, int, error) {
+	switch region {
+	case "full":
+		return 0, 0, width, height, nil
+	case "square":
+		side := width
+		if height < side {
+			side = height
+		}
+		return (width - side) / 2, (height - side) / 2, side, side, nil
+	}
+
+	invalid := processing.NewError("Invalid IIIF region: "+region, processing.BadRequest)
+	pct := strings.HasPrefix(region, "pct:")
+	values := strings.Split(strings.TrimPrefix(region, "pct:"), ",")
+	if len(values) != 4 {
+		return 0, 0, 0, 0, invalid
+	}
+
+	var rect [4]int
+	for i, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || (!pct && f != math.Trunc(f)) {
+			return 0, 0, 0, 0, invalid
+		}
+		if pct {
+			base := width
+			if i%2 == 1 {
+				base = height
+			}
+			f = f * float64(base) / 100
+		}
+		rect[i] = int(math.Floor(f + 0.5))
+	}
+
+	x, y, w, h := rect[0], rect[1], rect[2], rect[3]
+	if w == 0 || h == 0 || x >= width || y >= height {
+		return 0, 0, 0, 0, invalid
+	}
+
+	// The region beyond the image is cropped
+	if x+w > width {
+		w = width - x
+	}
+	if y+h > height {
+		h = height - y
+	}
+	return x, y, w, h, nil
+}
+
+// parseIIIFSize returns the output size and whether upscaling is allowed:
+// max, w,  ,h  pct:n  w,h  !w,h  and the upscaling forms prefixed by ^.
+func parseIIIFSize(size string, rw, rh, maxArea int) (int, int, bool, error) {
+	invalid := processing.NewError("Invalid IIIF size: "+size, processing.BadRequest)
+	upscale := strings.HasPrefix(size, "^")
+	size = strings.TrimPrefix(size, "^")
+
+	var w, h float64
+	switch {
+	case size == "max":
+		w, h = float64(rw), float64(rh)
+		if maxArea > 0 && rw*rh > maxArea {
+			scale := math.Sqrt(float64(maxArea) / float64(rw*rh))
+			w, h = math.Floor(w*scale), math.Floor(h*scale)
+		}
+	case strings.HasPrefix(size, "pct:"):
+		n, err := strconv.ParseFloat(size[4:], 64)
+		if err != nil || n <= 0 {
+			return 0, 0, false, invalid
+		}
+		w, h = float64(rw)*n/100, float64(rh)*n/100
+	default:
+		confined := strings.HasPrefix(size, "!")
+		values := strings.Split(strings.TrimPrefix(size, "!"), ",")
+		if len(values) != 2 {
+			return 0, 0, false, invalid
+		}
+		sw, errW := strconv.Atoi(values[0])
+		sh, errH := strconv.Atoi(values[1])
+
+		switch {
+		case confined:
+			if errW != nil || errH != nil || sw <= 0 || sh <= 0 {
+				return 0, 0, false, invalid
+			}
+			scale := math.Min(float64(sw)/float64(rw), float64(sh)/float64(rh))
+			w, h = float64(rw)*scale, float64(rh)*scale
+		case values[0] != "" && values[1] != "":
+			if errW != nil || errH != nil || sw <= 0 || sh <= 0 {
+				return 0, 0, false, invalid
+			}
+			w, h = float64(sw), float64(sh)
+		case values[0] != "":
+			if errW != nil || sw <= 0 {
+				return 0, 0, false, invalid
+			}
+			w, h = float64(sw), float64(rh)*float64(sw)/float64(rw)
+		case values[1] != "":
+			if errH != nil || sh <= 0 {
+				return 0, 0, false, invalid
+			}
+			w, h = float64(rw)*float64(sh)/float64(rh), float64(sh)
+		default:
+			return 0, 0, false, invalid
+		}
+	}
+
+	width, height := int(math.Max(1, math.Floor(w+0.5))), int(math.Max(1, math.Floor(h+0.5)))
+	if !upscale && (width > rw || height > rh) {
+		return 0, 0, false, processing.NewError(fmt.Sprintf("IIIF size(%dx%d) is larger than the region(%dx%d) without ^", width, height, rw, rh), processing.BadRequest)
+	}
+	if maxArea > 0 && width*height > maxArea {
+		return 0, 0, false, processing.NewError(fmt.Sprintf("The output image area(%dx%d) is exceed maximum area(%d)", width, height, maxArea), processing.BadRequest)
+	}
+	return width, height, upscale, nil
+}
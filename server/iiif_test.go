@@ -0,0 +1,153 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+func TestParseIIIFRegion(t *testing.T) {
+	cases := []struct {
+		region     string
+		x, y, w, h int
+		err        bool
+	}{
+		{"full", 0, 0, 1920, 1080, false},
+		{"square", 420, 0, 1080, 1080, false},
+		{"100,50,300,200", 100, 50, 300, 200, false},
+		{"1800,1000,300,200", 1800, 1000, 120, 80, false},
+		{"pct:50,50,50,50", 960, 540, 960, 540, false},
+		{"pct:10.5,0,10,100", 202, 0, 192, 1080, false},
+		{"2000,0,100,100", 0, 0, 0, 0, true},
+		{"0,0,0,100", 0, 0, 0, 0, true},
+		{"0,0,100", 0, 0, 0, 0, true},
+		{"1.5,0,100,100", 0, 0, 0, 0, true},
+		{"-1,0,100,100", 0, 0, 0, 0, true},
+	}
+
+	for _, c := range cases {
+		x, y, w, h, err := parseIIIFRegion(c.region, 1920, 1080)
+		if (err != nil) != c.err {
+			t.Errorf("Unexpected error: (region=%s) (err=%v)", c.region, err)
+			continue
+		}
+		if x != c.x || y != c.y || w != c.w || h != c.h {
+			t.Errorf("Invalid region: (region=%s) (got=%d,%d,%d,%d)", c.region, x, y, w, h)
+		}
+	}
+}
+
+func TestParseIIIFSize(t *testing.T) {
+	cases := []struct {
+		size    string
+		maxArea int
+		w, h    int
+		upscale bool
+		err     bool
+	}{
+		{"max", 0, 400, 300, false, false},
+		{"max", 30000, 200, 150, false, false},
+		{"^max", 0, 400, 300, true, false},
+		{"200,", 0, 200, 150, false, false},
+		{",150", 0, 200, 150, false, false},
+		{"pct:50", 0, 200, 150, false, false},
+		{"200,200", 0, 200, 200, false, false},
+		{"!200,200", 0, 200, 150, false, false},
+		{"^800,", 0, 800, 600, true, false},
+		{"^!800,800", 0, 800, 600, true, false},
+		{"800,", 0, 0, 0, false, true},
+		{"pct:200", 0, 0, 0, false, true},
+		{"^800,600", 30000, 0, 0, false, true},
+		{",", 0, 0, 0, false, true},
+		{"0,100", 0, 0, 0, false, true},
+		{"full", 0, 0, 0, false, true},
+	}
+
+	for _, c := range cases {
+		w, h, upscale, err := parseIIIFSize(c.size, 400, 300, c.maxArea)
+		if (err != nil) != c.err {
+			t.Errorf("Unexpected error: (size=%s) (err=%v)", c.size, err)
+			continue
+		}
+		if w != c.w || h != c.h || upscale != c.upscale {
+			t.Errorf("Invalid size: (size=%s) (got=%dx%d upscale=%v)", c.size, w, h, upscale)
+		}
+	}
+}
+
+func TestIIIFImageOptions(t *testing.T) {
+	cases := []struct {
+		path string
+		opts processing.ImageOptions
+		code uint8
+	}{
+		{"full/max/0/default.jpg", processing.ImageOptions{ResizeMode: processing.ResizeModeScale, Force: true, Width: 400, Height: 300, OutputFormat: "jpeg"}, 0},
+		{"0,0,200,100/100,/90/gray.png", processing.ImageOptions{ResizeMode: processing.ResizeModeScale, Force: true, Clip: []int{0, 0, 200, 100}, Width: 50, Height: 100, Rotate: 90, Monochrome: true, OutputFormat: "png"}, 0},
+		{"full/max/!90/color.webp", processing.ImageOptions{ResizeMode: processing.ResizeModeScale, Force: true, Width: 300, Height: 400, Rotate: 270, Flop: true, OutputFormat: "webp"}, 0},
+		{"full/max/!0/default.tif", processing.ImageOptions{ResizeMode: processing.ResizeModeScale, Force: true, Width: 400, Height: 300, Flop: true, OutputFormat: "tiff"}, 0},
+		{"full/max/45/default.jpg", processing.ImageOptions{}, processing.NotImplemented},
+		{"full/max/0/bitonal.jpg", processing.ImageOptions{}, processing.NotImplemented},
+		{"full/max/0/default.jp2", processing.ImageOptions{}, processing.NotImplemented},
+		{"full/max/0/native.jpg", processing.ImageOptions{}, processing.BadRequest},
+		{"full/max/400/default.jpg", processing.ImageOptions{}, processing.BadRequest},
+	}
+
+	for _, c := range cases {
+		r, err := parseIIIFImageRequest(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts, err := IIIFImageOptions(r, 400, 300, ServerOptions{})
+		if c.code != 0 {
+			if e, ok := err.(processing.Error); !ok || e.Code != c.code {
+				t.Errorf("Unexpected error: (path=%s) (err=%v)", c.path, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error: (path=%s) (err=%v)", c.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(opts, c.opts) {
+			t.Errorf("Invalid options: (path=%s) (got=%+v)", c.path, opts)
+		}
+	}
+}
+
+func TestParseIIIFImageRequest(t *testing.T) {
+	r, err := parseIIIFImageRequest("full/max/0/default.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := IIIFImageRequest{Region: "full", Size: "max", Rotation: "0", Quality: "default", Format: "jpg"}
+	if r != expected {
+		t.Errorf("Invalid request: %+v", r)
+	}
+
+	for _, path := range []string{"full/max/0", "full/max/0/default", "full/max/0/default.jpg/x"} {
+		if _, err := parseIIIFImageRequest(path); err == nil {
+			t.Errorf("Expected error: %s", path)
+		}
+	}
+}
+
+func TestIsIIIFPath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected bool
+	}{
+		{"/iiif/3/testdata%2Flarge.jpg/info.json", true},
+		{"/qic0bfzg/iiif/3/testdata%2Flarge.jpg/full/max/0/default.jpg", true},
+		{"/iiif/3/large.jpg", true},
+		{"/c!/w=300/photos/iiif/3/large.jpg", false},
+		{"/qic0bfzg/c!/w=300/iiif/3/large.jpg", false},
+		{"/c!/iiif/3/large.jpg", false},
+	}
+
+	for _, c := range cases {
+		if actual := isIIIFPath(c.path); actual != c.expected {
+			t.Errorf("Invalid IIIF path detection of %s: expected %t, but actual %t", c.path, c.expected, actual)
+		}
+	}
+}
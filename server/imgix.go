@@ -0,0 +1,214 @@
+package server
+
+import (
+	"encoding/base64"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+// The quality of auto=compress if q is not given
+const imgixCompressQuality = 45
+
+var imgixFormats = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"pjpg": "jpeg",
+	"png":  "png",
+	"webp": "webp",
+	"gif":  "gif",
+	"tiff": "tiff",
+}
+
+// isImgixRequest returns true if the params of the request are read from the query string like
+// /path/to/image.jpg?w=300&fit=crop, that is the path has no route marker.
+func isImgixRequest(path string, o ServerOptions) bool {
+	return o.ImgixParams && routeMarker(path, o) == "" && !isIIIFPath(path)
+}
+
+// ImgixImageOptions maps the imgix params of the query string onto ImageOptions for the image which has the given size.
+// Unknown params are ignored as well as imgix does.
+func ImgixImageOptions(query url.Values, width, height int) (processing.ImageOptions, error) {
+	opts := processing.ImageOptions{
+		Gravity:        processing.Gravity9MiddleCenter,
+		OverlayGravity: processing.Gravity9MiddleCenter,
+	}
+	invalid := func(key string) error {
+		return processing.NewError("Invalid imgix param: "+key+"="+query.Get(key), processing.BadRequest)
+	}
+
+	// The size less than 1 is the ratio to the size of the image
+	size := func(key string, orig int) (int, error) {
+		if query.Get(key) == "" {
+			return 0, nil
+		}
+		f, err := strconv.ParseFloat(query.Get(key), 64)
+		if err != nil || f <= 0 {
+			return 0, invalid(key)
+		}
+		if f < 1 {
+			f *= float64(orig)
+		}
+		return int(math.Floor(f + 0.5)), nil
+	}
+	var err error
+	if opts.Width, err = size("w", width); err != nil {
+		return opts, err
+	}
+	if opts.Height, err = size("h", height); err != nil {
+		return opts, err
+	}
+
+	if v := query.Get("dpr"); v != "" {
+		dpr, err := strconv.ParseFloat(v, 64)
+		if err != nil || dpr <= 0 || dpr > 5 {
+			return opts, invalid("dpr")
+		}
+		opts.Width = int(math.Floor(float64(opts.Width)*dpr + 0.5))
+		opts.Height = int(math.Floor(float64(opts.Height)*dpr + 0.5))
+	}
+
+	if v := query.Get("q"); v != "" {
+		q, err := strconv.Atoi(v)
+		if err != nil || q < 0 || q > 100 {
+			return opts, invalid("q")
+		}
+		opts.Quality = q
+	}
+
+	if v := query.Get("fm"); v != "" {
+		format, ok := imgixFormats[strings.ToLower(v)]
+		if !ok {
+			return opts, processing.NewError("imgix format is not supported: "+v, processing.NotImplemented)
+		}
+		opts.OutputFormat = format
+	}
+
+	// Unsupported values of auto are ignored, since those are best effort enhancements
+	for _, v := range strings.Split(query.Get("auto"), ",") {
+		switch strings.TrimSpace(v) {
+		case "format":
+			if opts.OutputFormat == "" {
+				opts.OutputFormat = "auto"
+			}
+		case "compress":
+			if opts.Quality == 0 {
+				opts.Quality = imgixCompressQuality
+			}
+		}
+	}
+
+	if v := query.Get("bg"); v != "" {
+		bg, ok := parseImgixColor(v)
+		if !ok {
+			return opts, invalid("bg")
+		}
+		opts.Background = bg
+	}
+
+	gravity, err := parseImgixCrop(query.Get("crop"))
+	if err != nil {
+		return opts, err
+	}
+	opts.Gravity = gravity
+
+	fit := strings.ToLower(query.Get("fit"))
+	if fit == "" {
+		fit = "clip"
+	}
+	switch {
+	case opts.Width == 0 && opts.Height == 0:
+		// Keep the size of the image
+		opts.ResizeMode = processing.ResizeModeScale
+		opts.Width, opts.Height = width, height
+	case opts.Width == 0 || opts.Height == 0:
+		opts.ResizeMode = processing.ResizeModeScale
+		opts.Upscale = fit != "max" && fit != "min" && fit != "fillmax"
+	default:
+		switch fit {
+		case "clip", "max":
+			opts.ResizeMode = processing.ResizeModeFit
+			opts.Upscale = fit == "clip"
+		case "crop", "min":
+			opts.ResizeMode = processing.ResizeModeCrop
+			opts.Upscale = fit == "crop"
+		case "scale":
+			opts.ResizeMode = processing.ResizeModeScale
+			opts.Upscale = true
+		case "fill", "fillmax":
+			opts.ResizeMode = processing.ResizeModePad
+			opts.Upscale = fit == "fill"
+		default:
+			return opts, processing.NewError("imgix fit is not supported: "+fit, processing.NotImplemented)
+		}
+	}
+
+	mark := query.Get("mark")
+	if v := query.Get("mark64"); v != "" {
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(v, "="))
+		if err != nil {
+			return opts, invalid("mark64")
+		}
+		mark = string(decoded)
+	}
+	if mark != "" {
+		opts.OverlayURL = url.PathEscape(mark)
+		offset := func(key string) (int, error) {
+			n, err := strconv.Atoi(query.Get(key))
+			if query.Get(key) != "" && (err != nil || n < 0) {
+				return 0, invalid(key)
+			}
+			return n, nil
+		}
+		if opts.OverlayX, err = offset("markx"); err != nil {
+			return opts, err
+		}
+		if opts.OverlayY, err = offset("marky"); err != nil {
+			return opts, err
+		}
+		if v := query.Get("markalpha"); v != "" {
+			alpha, err := strconv.Atoi(v)
+			if err != nil || alpha < 0 || alpha > 100 {
+				return opts, invalid("markalpha")
+			}
+			opts.OverlayOpacity = float32(alpha) / 100
+		}
+	}
+
+	return opts, nil
+}
+
+// parseImgixCrop returns the gravity of the crop like "top,left".
+// Face detection is not supported, the content aware crop is used instead.
+func parseImgixCrop(crop string) (processing.Gravity9, error) {
+	valign, halign := "middle", "center"
+	for _, v := range strings.Split(crop, ",") {
+		switch strings.TrimSpace(v) {
+		case "":
+		case "top", "bottom":
+			valign = strings.TrimSpace(v)
+		case "left", "right":
+			halign = strings.TrimSpace(v)
+		case "faces", "entropy", "edges":
+			return processing.Gravity9Smart, nil
+		default:
+			return processing.Gravity9MiddleCenter, processing.NewError("imgix crop is not supported: "+v, processing.NotImplemented)
+		}
+	}
+	return processing.AlignGravities[valign+"/"+halign], nil
+}
+
+// parseImgixColor parses the color like "fff", "ffffff" or ARGB like "8fff", "80ffffff". The alpha is ignored.
+func parseImgixColor(color string) ([]uint8, bool) {
+	switch len(color) {
+	case 4, 8:
+		color = color[len(color)/4:]
+	}
+	if !processing.HexColorPattern.MatchString(color) {
+		return nil, false
+	}
+	return processing.ParseHexColor(color), true
+}
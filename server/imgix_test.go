@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+func TestImgixImageOptions(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected processing.ImageOptions
+	}{
+		{"w=300&h=200", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeFit, Upscale: true}},
+		{"w=300&h=200&fit=crop&crop=top,left", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Gravity: processing.Gravity9TopLeft}},
+		{"w=300&h=200&fit=crop&crop=faces", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Gravity: processing.Gravity9Smart}},
+		{"w=300&h=200&fit=min", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop}},
+		{"w=300&h=200&fit=max", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeFit}},
+		{"w=300&h=200&fit=scale", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeScale, Upscale: true}},
+		{"w=300&h=200&fit=fill&bg=80ff0000", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModePad, Upscale: true, Background: []uint8{255, 0, 0}}},
+		{"w=300&dpr=2", processing.ImageOptions{Width: 600, ResizeMode: processing.ResizeModeScale, Upscale: true}},
+		{"w=0.5", processing.ImageOptions{Width: 500, ResizeMode: processing.ResizeModeScale, Upscale: true}},
+		{"fm=png&q=60", processing.ImageOptions{Width: 1000, Height: 500, ResizeMode: processing.ResizeModeScale, OutputFormat: "png", Quality: 60}},
+		{"w=300&auto=format,compress", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Upscale: true, OutputFormat: "auto", Quality: 45}},
+		{"w=300&auto=format,enhance&fm=webp&q=80", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Upscale: true, OutputFormat: "webp", Quality: 80}},
+		{
+			"w=300&mark=https://example.com/logo.png&markx=10&marky=20&markalpha=50",
+			processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Upscale: true, OverlayURL: "https:%2F%2Fexample.com%2Flogo.png", OverlayX: 10, OverlayY: 20, OverlayOpacity: 0.5},
+		},
+		{"w=300&mark64=bG9nby5wbmc", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Upscale: true, OverlayURL: "logo.png"}},
+		{"w=300&origin=qic0bfzg&sig=1.abc&txt=hello", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Upscale: true}},
+	}
+
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		actual, err := ImgixImageOptions(query, 1000, 500)
+		if err != nil {
+			t.Errorf("Cannot map %s: %s", c.query, err)
+			continue
+		}
+		if c.expected.Gravity == 0 {
+			c.expected.Gravity = processing.Gravity9MiddleCenter
+		}
+		c.expected.OverlayGravity = processing.Gravity9MiddleCenter
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Invalid options of %s: expected %+v, but actual %+v", c.query, c.expected, actual)
+		}
+	}
+
+	for _, q := range []string{
+		"w=abc",
+		"w=-300",
+		"w=300&dpr=10",
+		"w=300&q=101",
+		"w=300&fm=avif",
+		"w=300&bg=red",
+		"w=300&h=200&fit=facearea",
+		"w=300&crop=focalpoint",
+		"w=300&mark=logo.png&markalpha=200",
+	} {
+		query, _ := url.ParseQuery(q)
+		if _, err := ImgixImageOptions(query, 1000, 500); err == nil {
+			t.Errorf("Invalid imgix params must be rejected: %s", q)
+		}
+	}
+}
+
+func TestIsImgixRequest(t *testing.T) {
+	enabled := ServerOptions{ImgixParams: true}
+	cases := []struct {
+		path     string
+		o        ServerOptions
+		expected bool
+	}{
+		{"/path/to/image.jpg", enabled, true},
+		{"/path/to/image.jpg", ServerOptions{}, false},
+		{"/c!/w=300/image.jpg", enabled, false},
+		{"/iiif/3/image.jpg/info.json", enabled, false},
+	}
+
+	for _, c := range cases {
+		if actual := isImgixRequest(c.path, c.o); actual != c.expected {
+			t.Errorf("Invalid imgix request detection of %s: expected %t, but actual %t", c.path, c.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+var imgproxyGravities = map[string]processing.Gravity9{
+	"nowe": processing.Gravity9TopLeft,
+	"no":   processing.Gravity9TopCenter,
+	"noea": processing.Gravity9TopRight,
+	"we":   processing.Gravity9MiddleLeft,
+	"ce":   processing.Gravity9MiddleCenter,
+	"ea":   processing.Gravity9MiddleRight,
+	"sowe": processing.Gravity9BottomLeft,
+	"so":   processing.Gravity9BottomCenter,
+	"soea": processing.Gravity9BottomRight,
+	"sm":   processing.Gravity9Smart,
+}
+
+var imgproxyFormats = map[string]string{
+	"jpg":  "jpeg",
+	"jpeg": "jpeg",
+	"png":  "png",
+	"webp": "webp",
+	"gif":  "gif",
+	"tiff": "tiff",
+}
+
+// The short names of the processing options
+var imgproxyOptionNames = map[string]string{
+	"rs":  "resize",
+	"s":   "size",
+	"rt":  "resizing_type",
+	"w":   "width",
+	"h":   "height",
+	"el":  "enlarge",
+	"ex":  "extend",
+	"g":   "gravity",
+	"q":   "quality",
+	"bg":  "background",
+	"bl":  "blur",
+	"rot": "rotate",
+	"sm":  "strip_metadata",
+	"f":   "format",
+	"ext": "format",
+	"cb":  "cachebuster",
+	"exp": "expires",
+}
+
+// ImgproxyRequest represents the parsed imgproxy URL:
+// /<signature>/<option>:<arg>:.../plain/<source>@<extension> or /<signature>/<option>:<arg>:.../<base64 source>.<extension>
+type ImgproxyRequest struct {
+	Signature  string
+	SignedPath string // The part of the path after the signature, including the leading slash
+	Options    []ImgproxyOption
+	Source     string
+	Extension  string
+}
+
+// ImgproxyOption represents the processing option like "rs:fill:300:200"
+type ImgproxyOption struct {
+	Name string // Full name of the option
+	Args []string
+}
+
+// parseImgproxyPath parses the imgproxy URL path which follows the route marker.
+func parseImgproxyPath(path string) (ImgproxyRequest, error) {
+	r := ImgproxyRequest{}
+
+	i := strings.Index(path, "/")
+	if i <= 0 {
+		return r, processing.NewError("Invalid imgproxy URL: "+path, processing.BadRequest)
+	}
+	r.Signature = path[:i]
+	r.SignedPath = path[i:]
+
+	segments := strings.Split(path[i+1:], "/")
+	for len(segments) > 0 && strings.Contains(segments[0], ":") {
+		args := strings.Split(segments[0], ":")
+		name := args[0]
+		if full, ok := imgproxyOptionNames[name]; ok {
+			name = full
+		}
+		r.Options = append(r.Options, ImgproxyOption{Name: name, Args: args[1:]})
+		segments = segments[1:]
+	}
+
+	if len(segments) > 0 && segments[0] == "plain" {
+		source := strings.Join(segments[1:], "/")
+		if i := strings.LastIndex(source, "@"); i >= 0 {
+			source, r.Extension = source[:i], source[i+1:]
+		}
+		r.Source = strings.NewReplacer("%2F", "/", "%2f", "/").Replace(source)
+	} else {
+		// The base64 encoded source may be split by slashes
+		source := strings.Join(segments, "")
+		if i := strings.LastIndex(source, "."); i >= 0 {
+			source, r.Extension = source[:i], source[i+1:]
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(source, "="))
+		if err != nil {
+			return r, processing.NewError("Invalid imgproxy source: "+source, processing.BadRequest)
+		}
+		r.Source = string(decoded)
+	}
+
+	if r.Source == "" {
+		return r, processing.NewError("Missing imgproxy source: "+path, processing.BadRequest)
+	}
+	// The source is the path in the origin, the absolute URL of imgproxy is not supported
+	if unescaped, err := url.PathUnescape(r.Source); strings.Contains(r.Source, "://") || (err == nil && strings.Contains(unescaped, "://")) {
+		return r, processing.NewError("Absolute imgproxy source URL is not supported, use the path of the image in the origin: "+r.Source, processing.BadRequest)
+	}
+	if hasParentSegment(r.Source) {
+		return r, processing.NewError("Invalid imgproxy source: "+r.Source, processing.BadRequest)
+	}
+	return r, nil
+}
+
+// validateImgproxySignature checks the signature (URL-safe base64 of HMAC-SHA256 of the salt and the path)
+// with the hex encoded key and salt of the server. Any signature is accepted if the key is not configured,
+// unless the origin requires the URL signature.
+func validateImgproxySignature(r ImgproxyRequest, origin *origin.Origin, o ServerOptions) *processing.Error {
+	if o.URLSignatureKey == "" {
+		if origin.URLSignatureEnabled {
+			return &ErrInvalidURLSignature
+		}
+		return nil
+	}
+
+	expected, err := CalcImgproxySignatureValue(r.SignedPath, o.URLSignatureKey, o.URLSignatureSalt)
+	if err != nil {
+		e := processing.NewError(err.Error(), processing.InternalError)
+		return &e
+	}
+	if !hmac.Equal([]byte(r.Signature), []byte(expected)) {
+		return &ErrURLSignatureMismatch
+	}
+	return nil
+}
+
+// CalcImgproxySignatureValue returns the imgproxy signature of the path with the hex encoded key and salt.
+func CalcImgproxySignatureValue(path, hexKey, hexSalt string) (string, error) {
+	key, err := hex.DecodeString(hexKey)
+	if err != nil {
+		return "", processing.NewError("Invalid URL signature key: "+err.Error(), processing.InternalError)
+	}
+	salt, err := hex.DecodeString(hexSalt)
+	if err != nil {
+		return "", processing.NewError("Invalid URL signature salt: "+err.Error(), processing.InternalError)
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(salt)
+	mac.Write([]byte(path))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// ImgproxyImageOptions maps the imgproxy request onto ImageOptions for the image which has the given size.
+func ImgproxyImageOptions(r ImgproxyRequest, width, height int, now time.Time) (processing.ImageOptions, error) {
+	opts := processing.ImageOptions{
+		Gravity:        processing.Gravity9MiddleCenter,
+		OverlayGravity: processing.Gravity9MiddleCenter,
+	}
+	resizingType, extend := "fit", false
+
+	for _, option := range r.Options {
+		invalid := processing.NewError("Invalid imgproxy option: "+option.Name+":"+strings.Join(option.Args, ":"), processing.BadRequest)
+		args := option.Args
+		arg := imgproxyArg(args, 0)
+
+		switch option.Name {
+		case "resize", "size":
+			if option.Name == "resize" {
+				if arg != "" {
+					resizingType = arg
+				}
+				args = imgproxyArgs(args, 1)
+			}
+			if !imgproxyIntArg(args, 0, &opts.Width) || !imgproxyIntArg(args, 1, &opts.Height) {
+				return opts, invalid
+			}
+			imgproxyBoolArg(args, 2, &opts.Upscale)
+			imgproxyBoolArg(args, 3, &extend)
+		case "resizing_type":
+			resizingType = arg
+		case "width":
+			if !imgproxyIntArg(args, 0, &opts.Width) {
+				return opts, invalid
+			}
+		case "height":
+			if !imgproxyIntArg(args, 0, &opts.Height) {
+				return opts, invalid
+			}
+		case "enlarge":
+			imgproxyBoolArg(args, 0, &opts.Upscale)
+		case "extend":
+			imgproxyBoolArg(args, 0, &extend)
+		case "gravity":
+			g, ok := imgproxyGravities[arg]
+			if !ok {
+				return opts, processing.NewError("imgproxy gravity is not supported: "+arg, processing.NotImplemented)
+			}
+			opts.Gravity = g
+		case "quality":
+			if !imgproxyIntArg(args, 0, &opts.Quality) || opts.Quality > 100 {
+				return opts, invalid
+			}
+		case "background":
+			bg, ok := parseImgproxyColor(args)
+			if !ok {
+				return opts, invalid
+			}
+			opts.Background = bg
+		case "blur":
+			sigma, err := strconv.ParseFloat(arg, 64)
+			if err != nil || sigma < 0 {
+				return opts, invalid
+			}
+			opts.BlurSigma = sigma
+		case "rotate":
+			if !imgproxyIntArg(args, 0, &opts.Rotate) || opts.Rotate%90 != 0 {
+				return opts, processing.NewError("Arbitrary imgproxy rotation is not supported: "+arg, processing.NotImplemented)
+			}
+			opts.Rotate %= 360
+		case "strip_metadata":
+			strip := true
+			imgproxyBoolArg(args, 0, &strip)
+			opts.MetadataMode = processing.MetadataModeKeep
+			if strip {
+				opts.MetadataMode = processing.MetadataModeStrip
+			}
+		case "format":
+			r.Extension = arg
+		case "cachebuster":
+			// Only changes the URL
+		case "expires":
+			expires, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return opts, invalid
+			}
+			if now.Unix() > expires {
+				return opts, processing.NewError("URL expired", processing.Forbidden)
+			}
+		default:
+			return opts, processing.NewError("imgproxy option is not supported: "+option.Name, processing.NotImplemented)
+		}
+	}
+
+	if r.Extension != "" {
+		format, ok := imgproxyFormats[strings.ToLower(r.Extension)]
+		if !ok {
+			return opts, processing.NewError("imgproxy format is not supported: "+r.Extension, processing.NotImplemented)
+		}
+		opts.OutputFormat = format
+	}
+
+	if resizingType == "auto" {
+		// Fill if the orientations of the image and the result are the same, otherwise fit
+		resizingType = "fit"
+		if opts.Width > 0 && opts.Height > 0 && (opts.Width > opts.Height) == (width > height) {
+			resizingType = "fill"
+		}
+	}
+
+	switch {
+	case opts.Width == 0 && opts.Height == 0:
+		// Keep the size of the image
+		opts.ResizeMode = processing.ResizeModeScale
+		opts.Width, opts.Height = width, height
+		if opts.Rotate == 90 || opts.Rotate == 270 {
+			opts.Width, opts.Height = height, width
+		}
+	case opts.Width == 0 || opts.Height == 0:
+		opts.ResizeMode = processing.ResizeModeScale
+	default:
+		switch resizingType {
+		case "fit":
+			opts.ResizeMode = processing.ResizeModeFit
+			if extend {
+				opts.ResizeMode = processing.ResizeModePad
+			}
+		case "fill", "fill-down":
+			opts.ResizeMode = processing.ResizeModeCrop
+		case "force":
+			opts.ResizeMode = processing.ResizeModeScale
+		default:
+			return opts, processing.NewError("imgproxy resizing type is not supported: "+resizingType, processing.NotImplemented)
+		}
+	}
+
+	return opts, nil
+}
+
+// parseImgproxyColor parses the background color like "255:255:255" or "ffffff".
+func parseImgproxyColor(args []string) ([]uint8, bool) {
+	if len(args) == 1 && processing.HexColorPattern.MatchString(args[0]) {
+		return processing.ParseHexColor(args[0]), true
+	}
+	if len(args) != 3 {
+		return nil, false
+	}
+
+	color := make([]uint8, 3)
+	for i, arg := range args {
+		n, err := strconv.ParseUint(arg, 10, 8)
+		if err != nil {
+			return nil, false
+		}
+		color[i] = uint8(n)
+	}
+	return color, true
+}
+
+func imgproxyArgs(args []string, from int) []string {
+	if from < len(args) {
+		return args[from:]
+	}
+	return nil
+}
+
+func imgproxyArg(args []string, i int) string {
+	if i < len(args) {
+		return args[i]
+	}
+	return ""
+}
+
+// imgproxyIntArg sets the non-negative integer argument. The empty argument keeps the current value.
+func imgproxyIntArg(args []string, i int, value *int) bool {
+	if imgproxyArg(args, i) == "" {
+		return true
+	}
+	n, err := strconv.Atoi(args[i])
+	if err != nil || n < 0 {
+		return false
+	}
+	*value = n
+	return true
+}
+
+// imgproxyBoolArg sets the boolean argument like "1", "t" or "true". The empty argument keeps the current value.
+func imgproxyBoolArg(args []string, i int, value *bool) {
+	if arg := imgproxyArg(args, i); arg != "" {
+		*value = arg == "1" || arg == "t" || arg == "true"
+	}
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+const (
+	testImgproxyKey  = "943b421c9eb07c830af81030552c86009268de4e532ba2ee2eab8247c6da0881"
+	testImgproxySalt = "520f986b998545b4785e0defbc4f3c1203f22de2374a3d53cb7a7fe9fea309c5"
+)
+
+func TestParseImgproxyPath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected ImgproxyRequest
+	}{
+		{
+			"sig/rs:fill:300:200/g:sm/q:80/plain/path/to/image.jpg@webp",
+			ImgproxyRequest{
+				Signature: "sig", SignedPath: "/rs:fill:300:200/g:sm/q:80/plain/path/to/image.jpg@webp",
+				Options: []ImgproxyOption{
+					{Name: "resize", Args: []string{"fill", "300", "200"}},
+					{Name: "gravity", Args: []string{"sm"}},
+					{Name: "quality", Args: []string{"80"}},
+				},
+				Source: "path/to/image.jpg", Extension: "webp",
+			},
+		},
+		{
+			"_/w:300/plain/path%2Fto%2Fimage.jpg",
+			ImgproxyRequest{
+				Signature: "_", SignedPath: "/w:300/plain/path%2Fto%2Fimage.jpg",
+				Options: []ImgproxyOption{{Name: "width", Args: []string{"300"}}},
+				Source:  "path/to/image.jpg",
+			},
+		},
+		{
+			"_/width:300/dGVzdGRhdGEv/bGFyZ2UuanBn.png",
+			ImgproxyRequest{
+				Signature: "_", SignedPath: "/width:300/dGVzdGRhdGEv/bGFyZ2UuanBn.png",
+				Options: []ImgproxyOption{{Name: "width", Args: []string{"300"}}},
+				Source:  "testdata/large.jpg", Extension: "png",
+			},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := parseImgproxyPath(c.path)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", c.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Invalid imgproxy request of %s: expected %+v, but actual %+v", c.path, c.expected, actual)
+		}
+	}
+
+	for _, path := range []string{
+		"sig", "sig/w:300/plain/", "sig/w:300/not+base64",
+		"_/w:300/plain/..%2F..%2FtenantB%2Fx.jpg", "_/w:300/plain/photos/../../x.jpg", "_/w:300/Li4vLi4vdGVuYW50Qi94LmpwZw",
+		"_/w:300/plain/https://example.com/x.jpg", "_/w:300/plain/https%3A%2F%2Fexample.com%2Fx.jpg", "_/w:300/aHR0cHM6Ly9leGFtcGxlLmNvbS94LmpwZw",
+	} {
+		if _, err := parseImgproxyPath(path); err == nil {
+			t.Errorf("Invalid imgproxy URL must be rejected: %s", path)
+		}
+	}
+}
+
+func TestImgproxyImageOptions(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cases := []struct {
+		path     string
+		expected processing.ImageOptions
+	}{
+		{"_/rs:fill:300:200/g:sm/q:80/plain/a.jpg@webp", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Gravity: processing.Gravity9Smart, Quality: 80, OutputFormat: "webp"}},
+		{"_/rs:fit:300:200:1/plain/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeFit, Upscale: true}},
+		{"_/rs:fit:300:200:0:1/bg:255:0:0/plain/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModePad, Background: []uint8{255, 0, 0}}},
+		{"_/s:300:200/rt:force/plain/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeScale}},
+		{"_/rs:auto:300:200/plain/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop}},
+		{"_/rs:auto:200:300/plain/a.jpg", processing.ImageOptions{Width: 200, Height: 300, ResizeMode: processing.ResizeModeFit}},
+		{"_/w:300/g:soea/plain/a.jpg", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Gravity: processing.Gravity9BottomRight}},
+		{"_/plain/a.jpg@png", processing.ImageOptions{Width: 1000, Height: 500, ResizeMode: processing.ResizeModeScale, OutputFormat: "png"}},
+		{"_/rot:90/plain/a.jpg", processing.ImageOptions{Width: 500, Height: 1000, ResizeMode: processing.ResizeModeScale, Rotate: 90}},
+		{"_/w:300/bl:2.5/sm:1/cb:abc/exp:1700000000/f:jpg/plain/a.jpg", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, BlurSigma: 2.5, MetadataMode: processing.MetadataModeStrip, OutputFormat: "jpeg"}},
+		{"_/w:300/sm:0/bg:fff/plain/a.jpg", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, MetadataMode: processing.MetadataModeKeep, Background: []uint8{255, 255, 255}}},
+	}
+
+	for _, c := range cases {
+		r, err := parseImgproxyPath(c.path)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", c.path, err)
+			continue
+		}
+		actual, err := ImgproxyImageOptions(r, 1000, 500, now)
+		if err != nil {
+			t.Errorf("Cannot map %s: %s", c.path, err)
+			continue
+		}
+		if c.expected.Gravity == 0 {
+			c.expected.Gravity = processing.Gravity9MiddleCenter
+		}
+		c.expected.OverlayGravity = processing.Gravity9MiddleCenter
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Invalid options of %s: expected %+v, but actual %+v", c.path, c.expected, actual)
+		}
+	}
+
+	for _, path := range []string{
+		"_/rs:fill:abc:200/plain/a.jpg",
+		"_/q:101/plain/a.jpg",
+		"_/g:fp:0.5:0.5/plain/a.jpg",
+		"_/rot:45/plain/a.jpg",
+		"_/bg:1:2/plain/a.jpg",
+		"_/exp:1500000000/plain/a.jpg",
+		"_/wm:0.5/plain/a.jpg",
+		"_/rs:crop:300:200/plain/a.jpg",
+		"_/w:300/plain/a.jpg@avif",
+	} {
+		r, err := parseImgproxyPath(path)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", path, err)
+			continue
+		}
+		if _, err := ImgproxyImageOptions(r, 1000, 500, now); err == nil {
+			t.Errorf("Unsupported imgproxy request must be rejected: %s", path)
+		}
+	}
+}
+
+func TestValidateImgproxySignature(t *testing.T) {
+	path := "/rs:fill:320:180/plain/testdata/large.jpg@png"
+	expected := "r24i7Iy7lPFtcP9lWiAr7BbEaittYuFpqWKSfSSXnsQ"
+	if actual, err := CalcImgproxySignatureValue(path, testImgproxyKey, testImgproxySalt); err != nil || actual != expected {
+		t.Errorf("Invalid signature: expected %s, but actual %s (err=%v)", expected, actual, err)
+	}
+	if _, err := CalcImgproxySignatureValue(path, "xyz", testImgproxySalt); err == nil {
+		t.Error("Invalid key must be rejected")
+	}
+
+	signed := ServerOptions{URLSignatureKey: testImgproxyKey, URLSignatureSalt: testImgproxySalt}
+	cases := []struct {
+		signature string
+		origin    *origin.Origin
+		o         ServerOptions
+		valid     bool
+	}{
+		{expected, &origin.Origin{}, signed, true},
+		{expected, &origin.Origin{URLSignatureEnabled: true}, signed, true},
+		{"_", &origin.Origin{}, signed, false},
+		{"_", &origin.Origin{}, ServerOptions{}, true},
+		{"_", &origin.Origin{URLSignatureEnabled: true}, ServerOptions{}, false},
+	}
+
+	for _, c := range cases {
+		err := validateImgproxySignature(ImgproxyRequest{Signature: c.signature, SignedPath: path}, c.origin, c.o)
+		if (err == nil) != c.valid {
+			t.Errorf("Invalid signature validation of %s: expected valid=%t, but actual %v", c.signature, c.valid, err)
+		}
+	}
+}
@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"fmt"
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/rs/cors"
+	"github.com/tsu1980/thumbnary"
 	"gopkg.in/h2non/bimg.v1"
 	"gopkg.in/throttled/throttled.v2"
 	"gopkg.in/throttled/throttled.v2/store/memstore"
@@ -63,7 +64,9 @@ func throttle(next http.Handler, o ServerOptions) http.Handler {
 
 func validate(next http.Handler, o ServerOptions) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != "GET" && r.Method != "HEAD" {
+		// POST is allowed only for the JSON params
+		allowPost := r.Method == "POST" && routeMarker(r.URL.EscapedPath(), o) == "j!"
+		if r.Method != "GET" && r.Method != "HEAD" && !allowPost {
 			ErrorReply(r, w, ErrMethodNotAllowed, o)
 			return
 		}
@@ -90,7 +93,7 @@ func authorizeClient(next http.Handler, o ServerOptions) http.Handler {
 
 func defaultHeaders(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Server", fmt.Sprintf("thumbnary %s (bimg %s)", Version, bimg.Version))
+		w.Header().Set("Server", fmt.Sprintf("thumbnary %s (bimg %s)", thumbnary.Version, bimg.Version))
 		next.ServeHTTP(w, r)
 	})
 }
@@ -1,44 +1,16 @@
-package main
+package server
 
 import (
 	"fmt"
 	"net"
 	"regexp"
-)
-
-type OriginRepositoryType string
-type OriginSlug string
-
-type Origin struct {
-	Slug                     OriginSlug
-	SourceType               ImageSourceType
-	Scheme                   string
-	Host                     string
-	PathPrefix               string
-	URLSignatureEnabled      bool
-	URLSignatureKey          string
-	URLSignatureKey_Previous string
-	URLSignatureKey_Version  int
-	AllowExternalHTTPSource  bool
-}
-
-type OriginRepository interface {
-	Open() error
-	Close()
-	Get(originSlug OriginSlug) (*Origin, error)
-}
+	"strings"
 
-func NewOriginRepository(ort OriginRepositoryType, o ServerOptions) (OriginRepository, error) {
-	switch ort {
-	case OriginRepositoryTypeMySQL:
-		return &MySQLOriginRepository{Options: o}, nil
-	default:
-		return nil, fmt.Errorf("Unknown repository type: (type=%s)", ort)
-	}
-}
+	"github.com/tsu1980/thumbnary/origin"
+)
 
 type OriginSlugDetectMethod string
-type OriginSlugDetectFunc func(ServerOptions, *ImageRequest) (OriginSlug, error)
+type OriginSlugDetectFunc func(ServerOptions, *ImageRequest) (origin.OriginSlug, error)
 
 const OriginSlugDetectMethod_Host OriginSlugDetectMethod = "host"
 const OriginSlugDetectMethod_Path OriginSlugDetectMethod = "path"
@@ -50,7 +22,7 @@ const OriginSlugHTTPHeaderName string = "X-THUMBNARY-ORIGIN-SLUG"
 
 var originSlugDetectMethodMap = make(map[OriginSlugDetectMethod]OriginSlugDetectFunc)
 
-func OriginSlugDetectFunc_Host(o ServerOptions, imgReq *ImageRequest) (OriginSlug, error) {
+func OriginSlugDetectFunc_Host(o ServerOptions, imgReq *ImageRequest) (origin.OriginSlug, error) {
 	host, _, err := net.SplitHostPort(imgReq.HTTPRequest.Host)
 	if err != nil {
 		host = imgReq.HTTPRequest.Host
@@ -62,7 +34,7 @@ func OriginSlugDetectFunc_Host(o ServerOptions, imgReq *ImageRequest) (OriginSlu
 		return "", fmt.Errorf("Cannot extract origin slug: (host=%s)", host)
 	}
 
-	var originSlug = OriginSlug(group[1])
+	var originSlug = origin.OriginSlug(group[1])
 	if originSlug == "" {
 		return "", fmt.Errorf("Origin slug is empty: (host=%s)", host)
 	}
@@ -70,14 +42,14 @@ func OriginSlugDetectFunc_Host(o ServerOptions, imgReq *ImageRequest) (OriginSlu
 	return originSlug, nil
 }
 
-func OriginSlugDetectFunc_Path(o ServerOptions, imgReq *ImageRequest) (OriginSlug, error) {
+func OriginSlugDetectFunc_Path(o ServerOptions, imgReq *ImageRequest) (origin.OriginSlug, error) {
 	r := regexp.MustCompile(o.OriginSlugDetectPathPattern)
 	group := r.FindStringSubmatch(imgReq.HTTPRequest.URL.Path)
 	if group == nil {
 		return "", fmt.Errorf("Cannot extract origin slug: (path=%s)", imgReq.HTTPRequest.URL.Path)
 	}
 
-	var originSlug = OriginSlug(group[1])
+	var originSlug = origin.OriginSlug(group[1])
 	if originSlug == "" {
 		return "", fmt.Errorf("Origin slug is empty: (path=%s)", imgReq.HTTPRequest.URL.Path)
 	}
@@ -85,30 +57,30 @@ func OriginSlugDetectFunc_Path(o ServerOptions, imgReq *ImageRequest) (OriginSlu
 	return originSlug, nil
 }
 
-func OriginSlugDetectFunc_Query(o ServerOptions, imgReq *ImageRequest) (OriginSlug, error) {
+func OriginSlugDetectFunc_Query(o ServerOptions, imgReq *ImageRequest) (origin.OriginSlug, error) {
 	originSlug := imgReq.HTTPRequest.URL.Query().Get("origin")
 	if originSlug == "" {
 		return "", fmt.Errorf("origin query string not specified: (URL=%s)", imgReq.HTTPRequest.URL.String())
 	}
-	return (OriginSlug)(originSlug), nil
+	return (origin.OriginSlug)(originSlug), nil
 }
 
-func OriginSlugDetectFunc_Header(o ServerOptions, imgReq *ImageRequest) (OriginSlug, error) {
+func OriginSlugDetectFunc_Header(o ServerOptions, imgReq *ImageRequest) (origin.OriginSlug, error) {
 	originSlug := imgReq.HTTPRequest.Header.Get(OriginSlugHTTPHeaderName)
 	if originSlug == "" {
 		return "", fmt.Errorf("Origin slug in HTTP header is not specified: (Header=%+v)", imgReq.HTTPRequest.Header)
 	}
-	return (OriginSlug)(originSlug), nil
+	return (origin.OriginSlug)(originSlug), nil
 }
 
-func OriginSlugDetectFunc_URLSignature(o ServerOptions, imgReq *ImageRequest) (OriginSlug, error) {
+func OriginSlugDetectFunc_URLSignature(o ServerOptions, imgReq *ImageRequest) (origin.OriginSlug, error) {
 	if imgReq.URLSignatureInfo.OriginSlug == "" {
 		return "", fmt.Errorf("Origin slug in URL signature is not specified: (URL=%s)", imgReq.HTTPRequest.URL.String())
 	}
 	return imgReq.URLSignatureInfo.OriginSlug, nil
 }
 
-func FindOrigin(imgReq *ImageRequest, o ServerOptions) (*Origin, error) {
+func FindOrigin(imgReq *ImageRequest, o ServerOptions) (*origin.Origin, error) {
 	for _, method := range o.OriginSlugDetectMethods {
 		methodFunc, _ := originSlugDetectMethodMap[method]
 
@@ -130,6 +102,39 @@ func FindOrigin(imgReq *ImageRequest, o ServerOptions) (*Origin, error) {
 	return nil, fmt.Errorf("Cannot detect origin slug: (methods=%+v) (req=%+v)", o.OriginSlugDetectMethods, imgReq)
 }
 
+// ParseOriginSlugDetectMethods sets the origin slug detect methods of the comma separated list like "header,query".
+func ParseOriginSlugDetectMethods(o *ServerOptions, input string) error {
+	methods := make([]OriginSlugDetectMethod, 0, 5)
+	for _, val := range strings.Split(input, ",") {
+		val = strings.ToLower(strings.TrimSpace(val))
+		_, ok := originSlugDetectMethodMap[(OriginSlugDetectMethod)(val)]
+		if !ok {
+			return fmt.Errorf("Unknown origin slug detect method(%s)", val)
+		}
+		method := (OriginSlugDetectMethod)(val)
+
+		switch method {
+		case OriginSlugDetectMethod_Host:
+			if o.OriginSlugDetectHostPattern == "" {
+				return fmt.Errorf("Missing required params: origin slug detect host pattern")
+			}
+		case OriginSlugDetectMethod_Path:
+			if o.OriginSlugDetectPathPattern == "" {
+				return fmt.Errorf("Missing required params: origin slug detect path pattern")
+			}
+		}
+
+		methods = append(methods, method)
+	}
+
+	if len(methods) == 0 {
+		return fmt.Errorf("origin slug detect methods empty")
+	}
+
+	o.OriginSlugDetectMethods = methods
+	return nil
+}
+
 func init() {
 	originSlugDetectMethodMap[OriginSlugDetectMethod_Host] = OriginSlugDetectFunc_Host
 	originSlugDetectMethodMap[OriginSlugDetectMethod_Path] = OriginSlugDetectFunc_Path
@@ -1,15 +1,18 @@
-package main
+package server
 
 import (
 	"net/http"
+	"reflect"
 	"testing"
+
+	"github.com/tsu1980/thumbnary/origin"
 )
 
 func TestOriginSlugDetect_Host(t *testing.T) {
 	opts := ServerOptions{
 		OriginSlugDetectHostPattern: `([a-z0-9]+)\.example\.test`,
 	}
-	var originSlugExpected OriginSlug = "klj8a"
+	var originSlugExpected origin.OriginSlug = "klj8a"
 	req, _ := http.NewRequest("GET", "http://klj8a.example.test/", nil)
 	req.Host = req.URL.Host
 	imgReq := &ImageRequest{
@@ -30,7 +33,7 @@ func TestOriginSlugDetect_Path(t *testing.T) {
 	opts := ServerOptions{
 		OriginSlugDetectPathPattern: `^/([a-z0-9]+)/c!/`,
 	}
-	var originSlugExpected OriginSlug = "klj8a"
+	var originSlugExpected origin.OriginSlug = "klj8a"
 	req, _ := http.NewRequest("GET", "http://example.test/klj8a/c!/w=10/abc.jpg", nil)
 	imgReq := &ImageRequest{
 		HTTPRequest: req,
@@ -48,7 +51,7 @@ func TestOriginSlugDetect_Path(t *testing.T) {
 
 func TestOriginSlugDetect_Query(t *testing.T) {
 	opts := ServerOptions{}
-	var originSlugExpected OriginSlug = "klj8a"
+	var originSlugExpected origin.OriginSlug = "klj8a"
 	req, _ := http.NewRequest("GET", "http://example.test/c!/w=10/abc.jpg?origin=klj8a", nil)
 	imgReq := &ImageRequest{
 		HTTPRequest: req,
@@ -66,7 +69,7 @@ func TestOriginSlugDetect_Query(t *testing.T) {
 
 func TestOriginSlugDetect_Header(t *testing.T) {
 	opts := ServerOptions{}
-	var originSlugExpected OriginSlug = "klj8a"
+	var originSlugExpected origin.OriginSlug = "klj8a"
 	req, _ := http.NewRequest("GET", "http://example.test/c!/w=10/abc.jpg", nil)
 	req.Header.Set(OriginSlugHTTPHeaderName, "klj8a")
 	imgReq := &ImageRequest{
@@ -85,7 +88,7 @@ func TestOriginSlugDetect_Header(t *testing.T) {
 
 func TestOriginSlugDetect_URLSignature(t *testing.T) {
 	opts := ServerOptions{}
-	var originSlugExpected OriginSlug = "klj8a"
+	var originSlugExpected origin.OriginSlug = "klj8a"
 	req, _ := http.NewRequest("GET", "http://example.test/c!/w=10/abc.jpg?sig=klj8a-1.yiKX5u2kw6wp9zDgbrt2iOIi8IsoRIpw8fVgVc0yrNg", nil)
 	sigInfo, err := parseURLSignature(req)
 	imgReq := &ImageRequest{
@@ -102,3 +105,31 @@ func TestOriginSlugDetect_URLSignature(t *testing.T) {
 		t.Errorf("Expected to '%s', but actual '%s'. (req=%+v)", originSlugExpected, originSlug, req)
 	}
 }
+
+func TestParseOriginSlugDetectMethods(t *testing.T) {
+	cases := []struct {
+		input    string
+		opts     ServerOptions
+		expected []OriginSlugDetectMethod
+		valid    bool
+	}{
+		{"header,query", ServerOptions{}, []OriginSlugDetectMethod{"header", "query"}, true},
+		{" Header , QUERY", ServerOptions{}, []OriginSlugDetectMethod{"header", "query"}, true},
+		{"path", ServerOptions{OriginSlugDetectPathPattern: `^/([a-z0-9]+)/`}, []OriginSlugDetectMethod{"path"}, true},
+		{"path", ServerOptions{}, nil, false},
+		{"host", ServerOptions{}, nil, false},
+		{"cookie", ServerOptions{}, nil, false},
+	}
+
+	for _, c := range cases {
+		opts := c.opts
+		err := ParseOriginSlugDetectMethods(&opts, c.input)
+		if (err == nil) != c.valid {
+			t.Errorf("Unexpected result of %s: %v", c.input, err)
+			continue
+		}
+		if c.valid && !reflect.DeepEqual(opts.OriginSlugDetectMethods, c.expected) {
+			t.Errorf("Invalid methods of %s: expected %v, but actual %v", c.input, c.expected, opts.OriginSlugDetectMethods)
+		}
+	}
+}
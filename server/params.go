@@ -0,0 +1,39 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+func validateImageOptions(opts processing.ImageOptions, o ServerOptions) error {
+	outputArea := opts.Width * opts.Height
+	if o.MaxOutputMP > 0 && outputArea > (o.MaxOutputMP*1000000) {
+		return fmt.Errorf("The output image area(%dx%d) is exceed maximum area(%dMP)", opts.Width, opts.Height, o.MaxOutputMP)
+	}
+	return processing.ValidateOperations(opts)
+}
+
+// validateParams validates the presets of the params, and each param in strict mode.
+func validateParams(inputParamsStr string, origin *origin.Origin, o ServerOptions) error {
+	if err := processing.ValidatePresetParams(inputParamsStr, origin.Presets, origin.PresetsOnly); err != nil {
+		return err
+	}
+	if isStrictParams(origin, o) {
+		return processing.ValidateStrictParams(inputParamsStr, origin.Presets)
+	}
+	return nil
+}
+
+// isStrictParams returns true if the params of the origin are validated strictly.
+// The origin overrides the server default by "strict" or "lenient".
+func isStrictParams(origin *origin.Origin, o ServerOptions) bool {
+	switch origin.StrictParams {
+	case "strict":
+		return true
+	case "lenient":
+		return false
+	}
+	return o.StrictParams
+}
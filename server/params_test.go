@@ -0,0 +1,104 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+func TestValidateImageOptions(t *testing.T) {
+	tests := []struct {
+		description string
+		serverOpts  ServerOptions
+		imgOpts     processing.ImageOptions
+		valid       bool
+	}{
+		{
+			description: "Max output MP not set, output image area is 4MP, should be valid",
+			serverOpts: ServerOptions{
+				MaxOutputMP: 0,
+			},
+			imgOpts: processing.ImageOptions{
+				Width:  2000,
+				Height: 2000,
+			},
+			valid: true,
+		},
+		{
+			description: "Max output restrict to 4MP, output image area is 4MP, should be valid",
+			serverOpts: ServerOptions{
+				MaxOutputMP: 4,
+			},
+			imgOpts: processing.ImageOptions{
+				Width:  2000,
+				Height: 2000,
+			},
+			valid: true,
+		},
+		{
+			description: "Max output restrict to 4MP, output image area is 4.002MP, should not be valid",
+			serverOpts: ServerOptions{
+				MaxOutputMP: 4,
+			},
+			imgOpts: processing.ImageOptions{
+				Width:  2001,
+				Height: 2000,
+			},
+			valid: false,
+		},
+		{
+			description: "Max output restrict to 4MP, output image area is 3.998MP, should be valid",
+			serverOpts: ServerOptions{
+				MaxOutputMP: 4,
+			},
+			imgOpts: processing.ImageOptions{
+				Width:  1999,
+				Height: 2000,
+			},
+			valid: true,
+		},
+		{
+			description: "Copyright metadata mode with WebP output, should be valid",
+			imgOpts: processing.ImageOptions{
+				MetadataMode: processing.MetadataModeCopyright,
+				OutputFormat: "webp",
+			},
+			valid: true,
+		},
+		{
+			description: "Copyright metadata mode with GIF output, should not be valid",
+			imgOpts: processing.ImageOptions{
+				MetadataMode: processing.MetadataModeCopyright,
+				OutputFormat: "gif",
+			},
+			valid: false,
+		},
+	}
+
+	for _, tc := range tests {
+		err := validateImageOptions(tc.imgOpts, tc.serverOpts)
+		if (err == nil) != tc.valid {
+			t.Errorf("Test %#v failed: %v\n", tc.description, err)
+		}
+	}
+}
+
+func TestIsStrictParams(t *testing.T) {
+	cases := []struct {
+		origin   string
+		server   bool
+		expected bool
+	}{
+		{"", false, false},
+		{"", true, true},
+		{"strict", false, true},
+		{"lenient", true, false},
+	}
+
+	for _, test := range cases {
+		if strict := isStrictParams(&origin.Origin{StrictParams: test.origin}, ServerOptions{StrictParams: test.server}); strict != test.expected {
+			t.Errorf("Invalid strict mode: (origin=%s) (server=%t)", test.origin, test.server)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+// Steps of the operation pipeline are separated by "|" like "w=800,h=600,m=crop|l=<url>,lx=10|w=300,f=webp"
+const pipelineSeparator = "|"
+
+// splitPipelineParams splits the params by the literal "|", the escaped "%7C" belongs to the params like l=.
+func splitPipelineParams(inputParamsStr string) []string {
+	return strings.Split(inputParamsStr, pipelineSeparator)
+}
+
+// requestRawPath returns the path of the request as it is sent by the client.
+// EscapedPath cannot be used to split the pipeline, since it escapes the literal "|" to "%7C".
+func requestRawPath(req *http.Request) string {
+	if strings.HasPrefix(req.RequestURI, "/") {
+		if i := strings.Index(req.RequestURI, "?"); i >= 0 {
+			return req.RequestURI[:i]
+		}
+		return req.RequestURI
+	}
+	if req.URL.RawPath != "" {
+		return req.URL.RawPath
+	}
+	return req.URL.EscapedPath()
+}
+
+// readPipelineParams parses and validates each step of the pipeline.
+// The error reports the step number which starts from 1.
+func readPipelineParams(steps []string, origin *origin.Origin, o ServerOptions) ([]processing.ImageOptions, error) {
+	pipeline := make([]processing.ImageOptions, len(steps))
+	for i, step := range steps {
+		if step == "" || step == "none" {
+			return nil, fmt.Errorf("Step %d: Empty step", i+1)
+		}
+		if err := validateParams(step, origin, o); err != nil {
+			return nil, fmt.Errorf("Step %d: %s", i+1, err)
+		}
+		pipeline[i] = processing.ReadParams(step, origin.Presets)
+	}
+
+	if err := validatePipeline(pipeline, o); err != nil {
+		return nil, err
+	}
+	return pipeline, nil
+}
+
+// validatePipeline validates the options of each step of the pipeline.
+func validatePipeline(pipeline []processing.ImageOptions, o ServerOptions) error {
+	if o.MaxPipelineSteps > 0 && len(pipeline) > o.MaxPipelineSteps {
+		return fmt.Errorf("Too many pipeline steps: %d (max=%d)", len(pipeline), o.MaxPipelineSteps)
+	}
+
+	for i, opts := range pipeline {
+		if err := validatePipelineStep(opts, i == len(pipeline)-1, len(pipeline) > 1, o); err != nil {
+			return fmt.Errorf("Step %d: %s", i+1, err)
+		}
+	}
+	return nil
+}
+
+func validatePipelineStep(opts processing.ImageOptions, last bool, multi bool, o ServerOptions) error {
+	if err := validateImageOptions(opts, o); err != nil {
+		return err
+	}
+	if area := opts.Width * opts.Height; o.MaxPipelineMP > 0 && area > o.MaxPipelineMP*1000000 {
+		return fmt.Errorf("The image area(%dx%d) is exceed maximum area(%dMP)", opts.Width, opts.Height, o.MaxPipelineMP)
+	}
+
+	if !last {
+		// Intermediate images are always lossless PNG
+		if opts.OutputFormat != "" || opts.Quality != 0 {
+			return fmt.Errorf("Output format and quality are allowed only in the last step")
+		}
+		if opts.MetadataMode != processing.MetadataModeDefault {
+			return fmt.Errorf("Metadata mode is allowed only in the last step")
+		}
+	} else if multi && opts.MetadataMode == processing.MetadataModeKeep {
+		return fmt.Errorf("Metadata mode keep is not supported in pipeline")
+	}
+	return nil
+}
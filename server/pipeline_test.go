@@ -0,0 +1,61 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tsu1980/thumbnary/origin"
+)
+
+func TestSplitPipelineParams(t *testing.T) {
+	cases := []struct {
+		value    string
+		expected []string
+	}{
+		{"w=300", []string{"w=300"}},
+		{"w=800,h=600|w=300", []string{"w=800,h=600", "w=300"}},
+		{"w=800%7Cmono=true%7cw=300", []string{"w=800%7Cmono=true%7cw=300"}},
+		{"w=800|l=https%3A%2F%2Fexample.com%2Fa%7Cb.png,lx=10|w=300", []string{"w=800", "l=https%3A%2F%2Fexample.com%2Fa%7Cb.png,lx=10", "w=300"}},
+	}
+
+	for _, test := range cases {
+		if steps := splitPipelineParams(test.value); !reflect.DeepEqual(steps, test.expected) {
+			t.Errorf("Invalid steps: %s (got=%v)", test.value, steps)
+		}
+	}
+}
+
+func TestReadPipelineParams(t *testing.T) {
+	origin := &origin.Origin{Presets: map[string]string{"card": "w=300,h=200"}}
+	o := ServerOptions{MaxPipelineSteps: 3, MaxPipelineMP: 4}
+
+	cases := []struct {
+		value string
+		err   string
+	}{
+		{"w=800,h=600|w=300,f=webp", ""},
+		{"card|mono=true|q=80,meta=copyright", ""},
+		{"w=800|w=400|w=200|w=100", "Too many pipeline steps"},
+		{"w=800||w=300", "Step 2: Empty step"},
+		{"w=800,f=png|w=300", "Step 1: Output format"},
+		{"w=800,q=90|w=300", "Step 1: Output format"},
+		{"w=800,meta=copyright|w=300", "Step 1: Metadata mode"},
+		{"w=800|w=300,meta=keep", "Step 2: Metadata mode keep"},
+		{"w=800|p=banner", "Step 2: Unknown preset"},
+		{"w=3000,h=2000|w=300", "Step 1: The image area"},
+	}
+
+	for _, test := range cases {
+		pipeline, err := readPipelineParams(splitPipelineParams(test.value), origin, o)
+		if test.err == "" {
+			if err != nil || len(pipeline) != len(splitPipelineParams(test.value)) {
+				t.Errorf("Unexpected error: %s (err=%v)", test.value, err)
+			}
+			continue
+		}
+		if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("Invalid error: %s (expected=%s) (err=%v)", test.value, test.err, err)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/source"
+)
+
+// The limits of the operation pipeline applied if not set
+const (
+	DefaultMaxPipelineSteps = 8
+	DefaultMaxPipelineMP    = 50
+)
+
+type ServerOptions struct {
+	Port                        int
+	Burst                       int
+	Concurrency                 int
+	HTTPCacheTTL                int
+	HTTPReadTimeout             int
+	HTTPWriteTimeout            int
+	MaxAllowedSize              int
+	MaxOutputMP                 int
+	MaxPipelineSteps            int
+	MaxPipelineMP               int
+	StrictParams                bool
+	CanonicalURLMode            string
+	PublicBaseURL               string
+	ImgixParams                 bool
+	CORS                        bool
+	AuthForwarding              bool
+	EnablePlaceholder           bool
+	EnableURLSignature          bool
+	OriginSlugDetectMethods     []OriginSlugDetectMethod
+	OriginSlugDetectHostPattern string
+	OriginSlugDetectPathPattern string
+	RedisURL                    string
+	RedisChannelPrefix          string
+	DBDriverName                string
+	DBDataSourceName            string
+	DBTlsKeyName                string
+	DBTlsServerHostName         string
+	DBTlsServerCAPem            string
+	DBTlsClientCertPem          string
+	DBTlsClientKeyPem           string
+	OriginTableName             string
+	PresetTableName             string
+	URLSignatureKey             string
+	URLSignatureSalt            string
+	Address                     string
+	APIKey                      string
+	CertFile                    string
+	KeyFile                     string
+	Authorization               string
+	Placeholder                 string
+	OutputICC                   string
+	PlaceholderImage            []byte
+	OriginRepos                 origin.OriginRepository
+	AllowExternalHTTPSource     bool
+}
+
+// NewHandler returns the HTTP handler of the image server configured by the options,
+// so that the image server can be embedded in another server.
+// The image sources are loaded, and the origin repository is created and opened unless it is given.
+// The pipeline limits are set to the defaults unless they are given.
+func NewHandler(o ServerOptions) (http.Handler, error) {
+	if o.MaxPipelineSteps == 0 {
+		o.MaxPipelineSteps = DefaultMaxPipelineSteps
+	}
+	if o.MaxPipelineMP == 0 {
+		o.MaxPipelineMP = DefaultMaxPipelineMP
+	}
+	if o.EnablePlaceholder && len(o.PlaceholderImage) == 0 {
+		// Expose default placeholder
+		o.PlaceholderImage = placeholder
+	}
+
+	// Load image source providers
+	source.LoadSources(sourceConfig(o))
+
+	if o.OriginRepos == nil {
+		repos, err := origin.NewOriginRepository(origin.OriginRepositoryTypeMySQL, originOptions(o))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create origin repository: %s", err)
+		}
+		if err := repos.Open(); err != nil {
+			return nil, fmt.Errorf("failed to open origin repository: %s", err)
+		}
+		o.OriginRepos = repos
+	}
+
+	return NewHTTPHandler(o), nil
+}
+
+// sourceConfig returns the config of the image sources.
+func sourceConfig(o ServerOptions) source.SourceConfig {
+	return source.SourceConfig{
+		AuthForwarding: o.AuthForwarding,
+		Authorization:  o.Authorization,
+		MaxAllowedSize: o.MaxAllowedSize,
+	}
+}
+
+// originOptions returns the options of the origin repository.
+func originOptions(o ServerOptions) origin.Options {
+	return origin.Options{
+		RedisURL:            o.RedisURL,
+		RedisChannelPrefix:  o.RedisChannelPrefix,
+		DBDriverName:        o.DBDriverName,
+		DBDataSourceName:    o.DBDataSourceName,
+		DBTlsKeyName:        o.DBTlsKeyName,
+		DBTlsServerHostName: o.DBTlsServerHostName,
+		DBTlsServerCAPem:    o.DBTlsServerCAPem,
+		DBTlsClientCertPem:  o.DBTlsClientCertPem,
+		DBTlsClientKeyPem:   o.DBTlsClientKeyPem,
+		OriginTableName:     o.OriginTableName,
+		PresetTableName:     o.PresetTableName,
+	}
+}
+
+// Server starts the image server and blocks until SIGINT or SIGTERM is received.
+func Server(o ServerOptions) error {
+	addr := o.Address + ":" + strconv.Itoa(o.Port)
+	h, err := NewHandler(o)
+	if err != nil {
+		return err
+	}
+	handler := NewLog(h, os.Stdout)
+
+	server := &http.Server{
+		Addr:           addr,
+		Handler:        handler,
+		MaxHeaderBytes: 1 << 20,
+		ReadTimeout:    time.Duration(o.HTTPReadTimeout) * time.Second,
+		WriteTimeout:   time.Duration(o.HTTPWriteTimeout) * time.Second,
+	}
+
+	listenAndServe(server, o)
+	return nil
+}
+
+func listenAndServe(s *http.Server, o ServerOptions) {
+	go func() {
+		var err error
+		if o.CertFile != "" && o.KeyFile != "" {
+			err = s.ListenAndServeTLS(o.CertFile, o.KeyFile)
+		} else {
+			err = s.ListenAndServe()
+		}
+
+		if err != nil {
+			log.Fatalf("cannot start the server: %s", err)
+		}
+	}()
+
+	// Wait for SIGINT/SIGTERM signals
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	<-sigCh
+
+	log.Printf("Shutdown start..")
+	ctx, _ := context.WithTimeout(context.Background(), 30*time.Second)
+	if err := s.Shutdown(ctx); err != nil {
+		log.Printf("Failed to shutdown %v", err)
+	}
+	log.Printf("Server gracefully stopped.")
+}
+
+type MyHttpHandler struct {
+	Options ServerOptions
+}
+
+func NewHTTPHandler(o ServerOptions) *MyHttpHandler {
+	return &MyHttpHandler{Options: o}
+}
+
+func (h *MyHttpHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" {
+		Middleware(indexController, h.Options).ServeHTTP(w, r)
+	} else if r.URL.Path == "/health" {
+		Middleware(healthController, h.Options).ServeHTTP(w, r)
+	} else {
+		ImageMiddleware(h.Options).ServeHTTP(w, r)
+	}
+}
@@ -0,0 +1,1725 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+	"github.com/tsu1980/thumbnary/source"
+	bimg "gopkg.in/h2non/bimg.v1"
+)
+
+func TestIndex(t *testing.T) {
+	ts := testServer(indexController)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: %s", res.Status)
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(body), "thumbnary") == false {
+		t.Fatalf("Invalid body response: %s", body)
+	}
+}
+
+func TestCrop(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/c!/w=300/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	if res.Header.Get("Content-Length") == "" {
+		t.Fatal("Empty content length response")
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 1080)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestFit(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/c!/w=300,h=300,m=fit/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 168)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestTypeAuto(t *testing.T) {
+	cases := []struct {
+		acceptHeader string
+		expected     string
+	}{
+		{"", "jpeg"},
+		{"image/webp,*/*", "webp"},
+		{"image/png,*/*", "png"},
+		{"image/webp;q=0.8,image/jpeg", "webp"},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,image/webp,image/apng,*/*;q=0.8", "webp"}, // Chrome
+	}
+
+	for _, test := range cases {
+		opts := ServerOptions{
+			OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		}
+		opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+			w.Write(buf)
+		}))
+		defer td()
+
+		fn := ImageMiddleware(opts)
+		ts := httptest.NewServer(fn)
+		url := ts.URL + "/c!/w=300,f=auto/testdata/large.jpg?origin=qic0bfzg"
+		defer ts.Close()
+
+		req, _ := http.NewRequest("GET", url, nil)
+		req.Header.Add("Content-Type", "image/jpeg")
+		req.Header.Add("Accept", test.acceptHeader)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != 200 {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+
+		if res.Header.Get("Content-Length") == "" {
+			t.Fatal("Empty content length response")
+		}
+
+		image, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(image) == 0 {
+			t.Fatalf("Empty response body")
+		}
+
+		err = assertSize(image, 300, 1080)
+		if err != nil {
+			t.Error(err)
+		}
+
+		if bimg.DetermineImageTypeName(image) != test.expected {
+			t.Fatalf("Image type expected to '%s', but actual '%s'. (req=%+v)", test.expected, bimg.DetermineImageTypeName(image), req)
+		}
+
+		if res.Header.Get("Vary") != "Accept" {
+			t.Fatal("Vary header not set correctly")
+		}
+	}
+}
+
+func TestInfo(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/i!/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	var detail processing.ImageDetail
+	if err := json.NewDecoder(res.Body).Decode(&detail); err != nil {
+		t.Fatal(err)
+	}
+	if detail.Width != 1920 || detail.Height != 1080 || detail.Mime != "image/jpeg" {
+		t.Errorf("Invalid image detail: %+v", detail)
+	}
+}
+
+func TestColorManagement(t *testing.T) {
+	// The source is solid red of magenta and yellow inks, with the embedded CMYK profile
+	src, _ := ioutil.ReadFile("../testdata/cmyk.jpg")
+	if profile := processing.ExtractICCProfile(src); processing.ICCProfileColorSpace(profile) != "CMYK" || processing.ICCProfileDescription(profile) != "Thumbnary Test CMYK" {
+		t.Fatal("Invalid CMYK profile embedded in the source")
+	}
+
+	// sRGB profile installed by colord-data package, see Dockerfile
+	outputICC := "/usr/share/color/icc/colord/sRGB.icc"
+	if _, err := os.Stat(outputICC); err != nil {
+		t.Skipf("Output ICC profile not found: %s", outputICC)
+	}
+
+	info, err := processing.InfoImage(src, processing.ImageOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var detail processing.ImageInfo
+	json.Unmarshal(info.Body, &detail)
+	if detail.Source.Space != "cmyk" || detail.Source.ProfileName != "Thumbnary Test CMYK" {
+		t.Errorf("Invalid source colour profile: %+v", detail.Source)
+	}
+
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		OutputICC:               outputICC,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write(src)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/c!/w=32/testdata/cmyk.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	buf, _ := ioutil.ReadAll(res.Body)
+
+	meta, err := bimg.Metadata(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Type != "jpeg" || meta.Space != "srgb" || meta.Channels != 3 {
+		t.Errorf("Invalid output colour space: %+v", meta)
+	}
+	if cs := processing.ICCProfileColorSpace(processing.ExtractICCProfile(buf)); cs == "CMYK" {
+		t.Error("CMYK profile should not be kept in the output image")
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(buf))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, g, b, _ := img.At(16, 16).RGBA(); r>>8 < 200 || g>>8 > 60 || b>>8 > 60 {
+		t.Errorf("Invalid output colour: expected red, but actual (%d,%d,%d)", r>>8, g>>8, b>>8)
+	}
+}
+
+func TestOutputJSON(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		HTTPCacheTTL:            3600,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/c!/w=300,f=json,pal=4/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+	if res.Header.Get("Cache-Control") != getCacheControl(3600) {
+		t.Fatalf("Invalid cache control: %s", res.Header.Get("Cache-Control"))
+	}
+
+	var info processing.ImageJSON
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 300 || len(info.Palette) == 0 || len(info.Palette) > 4 {
+		t.Errorf("Invalid image JSON: %+v", info)
+	}
+}
+
+func TestPerceptualHashCompare(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile(path.Join("..", req.URL.Path))
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/h!/testdata/large.jpg/h!/testdata/thumbnary.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	var result processing.ImagePerceptualHashComparison
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Source.PHash == result.Target.PHash || result.Distance.PHash == 0 {
+		t.Errorf("Different images must have different hashes: %+v", result)
+	}
+}
+
+func TestQuality(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/q!/w=300,h=200,q=50/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	var quality processing.ImageQuality
+	if err := json.NewDecoder(res.Body).Decode(&quality); err != nil {
+		t.Fatal(err)
+	}
+	if quality.Width != 300 || quality.Height != 200 || quality.Mime != "image/jpeg" || quality.SSIM <= 0 || quality.SavedBytes <= 0 {
+		t.Errorf("Invalid quality: %+v", quality)
+	}
+
+	// The data output cannot be compared with the source image
+	url = ts.URL + "/q!/w=300,f=blurhash/testdata/large.jpg?origin=qic0bfzg"
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 400 {
+		t.Errorf("Invalid response status: (url=%+v) (res=%+v)", url, res)
+	}
+}
+
+func TestSprite(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile(path.Join("..", req.URL.Path))
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/s!/w=60,h=60,cols=3/testdata/large.jpg/s!/testdata/thumbnary.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertSize(image, 120, 60); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(res.Header.Get("X-THUMBNARY-SPRITE"), `"path":"testdata/thumbnary.jpg","x":60`) {
+		t.Errorf("Invalid sprite map header: %s", res.Header.Get("X-THUMBNARY-SPRITE"))
+	}
+
+	// The overlay is fetched once and composed on each tile
+	overlayFetched := 0
+	tsOverlay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		overlayFetched++
+		buf, _ := ioutil.ReadFile("../testdata/thumbnary.jpg")
+		w.Write(buf)
+	}))
+	defer tsOverlay.Close()
+
+	url = ts.URL + "/s!/w=60,h=60,l=" + strings.NewReplacer(":", "%3A", "/", "%2F").Replace(tsOverlay.URL+"/logo.jpg") + "/testdata/large.jpg/s!/testdata/thumbnary.jpg?origin=qic0bfzg"
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if overlayFetched != 1 {
+		t.Errorf("The overlay must be fetched once, but fetched %d times", overlayFetched)
+	}
+}
+
+func TestIIIF(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile(path.Join("..", req.URL.Path))
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	url := ts.URL + "/iiif/3/testdata%2Flarge.jpg/info.json?origin=qic0bfzg"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	var info IIIFInfo
+	if err := json.NewDecoder(res.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	if info.Width != 1920 || info.Height != 1080 || info.Type != "ImageService3" || !strings.HasSuffix(info.ID, "/iiif/3/testdata%2Flarge.jpg?origin=qic0bfzg") {
+		t.Errorf("Invalid info.json: %+v", info)
+	}
+
+	url = ts.URL + "/iiif/3/testdata%2Flarge.jpg/0,0,960,1080/200,/0/default.png?origin=qic0bfzg"
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = assertSize(image, 200, 225)
+	if err != nil {
+		t.Error(err)
+	}
+
+	url = ts.URL + "/iiif/3/testdata%2Flarge.jpg/full/max/45/default.jpg?origin=qic0bfzg"
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != http.StatusNotImplemented {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+}
+
+func TestIIIFPathInImagePath(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	// The file path which contains the IIIF route is served by the image endpoint
+	url := ts.URL + "/c!/w=320,h=180,m=scale/photos/iiif/3/large.jpg?origin=qic0bfzg"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	image, _ := ioutil.ReadAll(res.Body)
+	if err := assertSize(image, 320, 180); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestIIIFParentPath(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	paths := []string{
+		"/iiif/3/..%2F..%2Fsecret.jpg/info.json",
+		"/iiif/3/photos%2F..%2F..%2Fsecret.jpg/full/max/0/default.jpg",
+		"/iiif/3/%2E%2E%2Fsecret.jpg/info.json",
+		"/c!/w=300/photos/..%2F..%2Fsecret.jpg",
+	}
+	for _, path := range paths {
+		res, err := http.Get(ts.URL + path + "?origin=qic0bfzg")
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != 400 {
+			t.Errorf("Invalid response status of %s: %d", path, res.StatusCode)
+		}
+	}
+}
+
+func TestHasParentSegment(t *testing.T) {
+	cases := []struct {
+		filePath string
+		expected bool
+	}{
+		{"photos/large.jpg", false},
+		{"photos/..large.jpg", false},
+		{"../large.jpg", true},
+		{"photos/../../large.jpg", true},
+		{"..%2Flarge.jpg", true},
+		{"%2e%2e/large.jpg", true},
+		{"photos\\..\\large.jpg", true},
+		{"photos/..", true},
+	}
+
+	for _, c := range cases {
+		if actual := hasParentSegment(c.filePath); actual != c.expected {
+			t.Errorf("Invalid parent segment of %s: expected %t, but actual %t", c.filePath, c.expected, actual)
+		}
+	}
+}
+
+func TestTile(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		HTTPCacheTTL:            3600,
+	}
+	fetched := 0
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fetched++
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+	tileSourceCache.Purge()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	url := ts.URL + "/t!/testdata/large.jpg.dzi?origin=qic0bfzg"
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "application/xml" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	for _, tile := range []string{"11/0/0", "11/1/0", "10/3/2"} {
+		url = ts.URL + "/t!/" + tile + "/testdata/large.jpg?origin=qic0bfzg"
+		res, err = http.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != 200 {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+		if res.Header.Get("Cache-Control") != getCacheControl(3600) {
+			t.Fatalf("Invalid cache control: %s", res.Header.Get("Cache-Control"))
+		}
+	}
+
+	if fetched != 1 {
+		t.Errorf("The source image must be fetched once, but fetched %d times", fetched)
+	}
+}
+
+func TestTileAuthForwarding(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		AuthForwarding:          true,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+	tileSourceCache.Purge()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	url := ts.URL + "/t!/testdata/large.jpg.dzi?origin=qic0bfzg"
+	req, _ := http.NewRequest("GET", url, nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	// The source image fetched by the credentials is not served to the caller without them
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode == 200 {
+		t.Errorf("The source image must not be served from the cache without the credentials")
+	}
+}
+
+func TestSrcset(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	// The manifest is signed by the previous key, the variants are signed by the current key
+	path := "/m!/widths=100:200/q=80/testdata/large.jpg"
+	url := ts.URL + path + "?origin=sigver2&sig=" + CreateURLSignatureString(1, path, "secrettest", "")
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	var manifest SrcsetManifest
+	if err := json.NewDecoder(res.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Sources) != 1 || len(manifest.Sources[0].Candidates) != 2 {
+		t.Fatalf("Invalid manifest: %+v", manifest)
+	}
+
+	candidate := manifest.Sources[0].Candidates[1]
+	if !strings.Contains(candidate.URL, "sig=2.") || candidate.Descriptor != "200w" {
+		t.Fatalf("Invalid candidate: %+v", candidate)
+	}
+	// The URLs are relative to the host without the public base URL
+	if !strings.HasPrefix(candidate.URL, "/c!/") {
+		t.Fatalf("Invalid candidate URL: %s", candidate.URL)
+	}
+	res, err = http.Get(ts.URL + candidate.URL)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", candidate.URL, res, BodyAsString(res))
+	}
+
+	res, err = http.Get(ts.URL + path + "?origin=sigver2")
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode == 200 {
+		t.Fatal("The manifest request must be signed")
+	}
+
+	// The variants are not signed if the origin does not enable the URL signature, even if it has the key
+	opts.PublicBaseURL = "https://img.example.com"
+	ts2 := httptest.NewServer(ImageMiddleware(opts))
+	defer ts2.Close()
+	res, err = http.Get(ts2.URL + path + "?origin=sigoff1")
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (res=%+v) (body=%s)", res, BodyAsString(res))
+	}
+	manifest = SrcsetManifest{}
+	if err := json.NewDecoder(res.Body).Decode(&manifest); err != nil {
+		t.Fatal(err)
+	}
+	candidate = manifest.Sources[0].Candidates[0]
+	if candidate.URL != "https://img.example.com/c!/q=80,w=100/testdata/large.jpg?origin=sigoff1" {
+		t.Errorf("Invalid candidate URL with public base URL: %s", candidate.URL)
+	}
+}
+
+func TestPreset(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	cases := []struct {
+		path   string
+		status int
+	}{
+		{"/c!/card/testdata/large.jpg", 200},
+		{"/c!/p=card/testdata/large.jpg", 200},
+		{"/c!/p=card,w=400/testdata/large.jpg", 400},
+		{"/c!/w=300/testdata/large.jpg", 400},
+		{"/c!/p=banner/testdata/large.jpg", 400},
+	}
+
+	for _, test := range cases {
+		url := ts.URL + test.path + "?origin=presets1"
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+		if test.status != 200 {
+			continue
+		}
+
+		image, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertSize(image, 300, 200); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		MaxPipelineSteps:        4,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	// The separator is sent as is, since http.Get escapes "|" to "%7C"
+	pipelineGet := func(method, path string) (*http.Response, error) {
+		req, err := http.NewRequest(method, ts.URL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.URL.Opaque = path
+		return http.DefaultClient.Do(req)
+	}
+
+	url := "/c!/w=800,h=600|mono=true|w=300,m=scale,f=png/testdata/large.jpg?origin=qic0bfzg"
+	res, err := pipelineGet("GET", url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertSize(image, 300, 225); err != nil {
+		t.Error(err)
+	}
+
+	url = "/c!/w=800,f=png|w=300/testdata/large.jpg?origin=qic0bfzg"
+	res, err = pipelineGet("GET", url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 400 || !strings.Contains(BodyAsString(res), "Step 1") {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v)", url, res)
+	}
+
+	// The info of HEAD request is of the result image
+	url = "/c!/w=800,h=600|w=300,m=scale,f=png/testdata/large.jpg?origin=qic0bfzg"
+	res, err = pipelineGet("HEAD", url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	var info processing.ImageInfo
+	if err := json.Unmarshal([]byte(res.Header.Get("X-THUMBNARY-METADATA")), &info); err != nil {
+		t.Fatalf("Invalid metadata: %s", err)
+	}
+	if res.StatusCode != 200 || info.Source.Width != 300 || info.Source.Height != 225 || info.Source.Type != "png" {
+		t.Errorf("Invalid info of the pipeline: (res=%+v) (info=%+v)", res, info)
+	}
+
+	// The escaped separator is not split
+	url = "/c!/w=800%7Cw=300/testdata/large.jpg?origin=qic0bfzg"
+	res, err = pipelineGet("GET", url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode == 200 {
+		if image, _ := ioutil.ReadAll(res.Body); assertSize(image, 300, 169) == nil {
+			t.Errorf("The escaped separator must not be split: %s", url)
+		}
+	}
+}
+
+func TestJSONParams(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	params := `{"steps":[{"w":800,"h":600},{"w":300,"m":"scale","f":"png"}]}`
+	blob := base64.RawURLEncoding.EncodeToString([]byte(params))
+	signedPath := "/j!/" + blob + "/testdata/large.jpg"
+	sig := CreateURLSignatureString(1, signedPath, "secrettest", "")
+
+	res, err := http.Get(ts.URL + signedPath + "?origin=jdv9ab8v&sig=" + sig)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (res=%+v) (body=%s)", res, BodyAsString(res))
+	}
+	image, _ := ioutil.ReadAll(res.Body)
+	if err := assertSize(image, 300, 225); err != nil {
+		t.Error(err)
+	}
+
+	// POST request is signed as the equivalent GET request
+	url := ts.URL + "/j!/testdata/large.jpg?origin=jdv9ab8v&sig=" + sig
+	res, err = http.Post(url, "application/json", strings.NewReader(params))
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (res=%+v) (body=%s)", res, BodyAsString(res))
+	}
+	if res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("Invalid content type: %s", res.Header.Get("Content-Type"))
+	}
+
+	res, err = http.Post(url, "application/json", strings.NewReader(`{"w":100}`))
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode == 200 {
+		t.Fatal("The signature must cover the JSON params")
+	}
+
+	res, err = http.Post(ts.URL+"/c!/w=100/testdata/large.jpg?origin=qic0bfzg", "application/json", strings.NewReader(params))
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("Invalid response status: %d", res.StatusCode)
+	}
+}
+
+func TestStrictParams(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	cases := []struct {
+		path   string
+		origin string
+		status int
+		body   string
+	}{
+		{"/c!/w=300/testdata/large.jpg", "strict1", 200, ""},
+		{"/c!/w=300,zoom=2/testdata/large.jpg", "qic0bfzg", 200, ""},
+		{"/c!/w=abc,zoom=2/testdata/large.jpg", "strict1", 400, "w=abc (expected non-negative integer), zoom=2 (expected a known param name)"},
+		{"/c!/w=300%7Cq=0/testdata/large.jpg", "strict1", 400, "w=300%7Cq=0 (expected non-negative integer)"},
+	}
+
+	for _, test := range cases {
+		url := ts.URL + test.path + "?origin=" + test.origin
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+		if body := BodyAsString(res); test.body != "" && !strings.Contains(body, test.body) {
+			t.Errorf("Invalid error message: (url=%s) (body=%s)", url, body)
+		}
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		CanonicalURLMode:        CanonicalURLModeRedirect,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	signedPath := "/c!/h=200,w=300/testdata/large.jpg"
+	cases := []struct {
+		path      string
+		query     string
+		status    int
+		canonical string
+	}{
+		{"/c!/w=300,h=200/testdata/large.jpg", "?origin=qic0bfzg", 200, "/c!/w=300,h=200/testdata/large.jpg?origin=qic0bfzg"},
+		{"/c!/h=200,w=300,q=80/testdata/large.jpg", "?origin=qic0bfzg", 301, "/c!/w=300,h=200/testdata/large.jpg?origin=qic0bfzg"},
+		{"/c!/w=300,h=200,m=CROP,g=5,anim=true/testdata/large.jpg", "?origin=qic0bfzg", 301, "/c!/w=300,h=200/testdata/large.jpg?origin=qic0bfzg"},
+		{"/c!/card/testdata/large.jpg", "?origin=presets1", 200, ""},
+		{signedPath, "?origin=jdv9ab8v&sig=" + CreateURLSignatureString(1, signedPath, "secrettest", ""), 200, "/c!/w=300,h=200/testdata/large.jpg?origin=jdv9ab8v&sig="},
+	}
+
+	for _, test := range cases {
+		url := ts.URL + test.path + test.query
+		res, err := client.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+
+		if test.status == 301 {
+			if location := res.Header.Get("Location"); location != test.canonical {
+				t.Errorf("Invalid redirect location: (url=%s) (location=%s)", url, location)
+			}
+			continue
+		}
+		link := res.Header.Get("Link")
+		if test.canonical == "" {
+			if link != "" {
+				t.Errorf("Unexpected Link header: (url=%s) (link=%s)", url, link)
+			}
+			continue
+		}
+		if !strings.HasPrefix(link, "<"+test.canonical) || !strings.HasSuffix(link, `>; rel="canonical"`) {
+			t.Errorf("Invalid Link header: (url=%s) (link=%s)", url, link)
+		}
+	}
+
+	// The Link header is absolute with the public base URL
+	opts.PublicBaseURL = "https://img.example.com"
+	ts2 := httptest.NewServer(ImageMiddleware(opts))
+	defer ts2.Close()
+	res, err := client.Get(ts2.URL + cases[0].path + cases[0].query)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if link := res.Header.Get("Link"); link != `<https://img.example.com`+cases[0].canonical+`>; rel="canonical"` {
+		t.Errorf("Invalid Link header with public base URL: %s", link)
+	}
+}
+
+func TestThumbor(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile(path.Join("..", req.URL.Path))
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	signedPath := "fit-in/320x320/testdata/large.jpg"
+	cases := []struct {
+		path   string
+		origin string
+		status int
+		width  int
+		height int
+	}{
+		{"/th!/unsafe/300x200/testdata/large.jpg", "qic0bfzg", 200, 300, 200},
+		{"/th!/unsafe/320x0/filters:format(png)/testdata/large.jpg", "qic0bfzg", 200, 320, 180},
+		{"/th!/" + CalcThumborSignatureValue(signedPath, "secrettest") + "/" + signedPath, "jdv9ab8v", 200, 320, 180},
+		{"/th!/unsafe/" + signedPath, "jdv9ab8v", 400, 0, 0},
+		{"/th!/" + CalcThumborSignatureValue(signedPath, "wrong") + "/" + signedPath, "jdv9ab8v", 403, 0, 0},
+		{"/th!/unsafe/300x200/testdata/large.jpg", "presets1", 400, 0, 0},
+		{"/th!/unsafe/trim/300x200/testdata/large.jpg", "qic0bfzg", 501, 0, 0},
+	}
+
+	for _, test := range cases {
+		url := ts.URL + test.path + "?origin=" + test.origin
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+		if test.status != 200 {
+			continue
+		}
+
+		image, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertSize(image, test.width, test.height); err != nil {
+			t.Errorf("%s: %s", url, err)
+		}
+	}
+}
+
+func TestImgproxy(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		URLSignatureKey:         testImgproxyKey,
+		URLSignatureSalt:        testImgproxySalt,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile(path.Join("..", req.URL.Path))
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	sign := func(path string) string {
+		sig, _ := CalcImgproxySignatureValue(path, testImgproxyKey, testImgproxySalt)
+		return "/ip!/" + sig + path
+	}
+
+	cases := []struct {
+		path   string
+		origin string
+		status int
+		mime   string
+		width  int
+		height int
+	}{
+		{sign("/rs:fill:300:200/plain/testdata/large.jpg@png"), "qic0bfzg", 200, "image/png", 300, 200},
+		{sign("/rs:fit:320:320/dGVzdGRhdGEv/bGFyZ2UuanBn"), "jdv9ab8v", 200, "image/jpeg", 320, 180},
+		{"/ip!/unsafe/rs:fill:300:200/plain/testdata/large.jpg", "qic0bfzg", 403, "", 0, 0},
+		{sign("/rs:fill:300:200/plain/testdata/large.jpg"), "presets1", 400, "", 0, 0},
+		{sign("/wm:0.5/plain/testdata/large.jpg"), "qic0bfzg", 501, "", 0, 0},
+	}
+
+	for _, test := range cases {
+		url := ts.URL + test.path + "?origin=" + test.origin
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+		if test.status != 200 {
+			continue
+		}
+		if res.Header.Get("Content-Type") != test.mime {
+			t.Errorf("Invalid content type: (url=%s) (type=%s)", url, res.Header.Get("Content-Type"))
+		}
+
+		image, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertSize(image, test.width, test.height); err != nil {
+			t.Errorf("%s: %s", url, err)
+		}
+	}
+}
+
+func TestImgixParams(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		ImgixParams:             true,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile(path.Join("..", req.URL.Path))
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	defer ts.Close()
+
+	signedQuery := "origin=jdv9ab8v&w=320&h=180&fit=crop"
+	sig := CreateURLSignatureString(1, "/testdata/large.jpg?"+signedQuery, "secrettest", "")
+	cases := []struct {
+		path   string
+		status int
+		mime   string
+		width  int
+		height int
+	}{
+		{"/testdata/large.jpg?origin=qic0bfzg&w=300&h=200&fit=crop&fm=png", 200, "image/png", 300, 200},
+		{"/testdata/large.jpg?origin=qic0bfzg&w=320&h=320", 200, "image/jpeg", 320, 180},
+		{"/c!/w=300,h=200/testdata/large.jpg?origin=qic0bfzg", 200, "image/jpeg", 300, 200},
+		{"/testdata/large.jpg?" + signedQuery + "&sig=" + sig, 200, "image/jpeg", 320, 180},
+		{"/testdata/large.jpg?origin=jdv9ab8v&w=640&h=360&fit=crop&sig=" + sig, 403, "", 0, 0},
+		{"/testdata/large.jpg?origin=qic0bfzg&w=300&fit=facearea&h=200", 501, "", 0, 0},
+		{"/testdata/large.jpg?origin=presets1&w=300", 400, "", 0, 0},
+	}
+
+	for _, test := range cases {
+		url := ts.URL + test.path
+		res, err := http.Get(url)
+		if err != nil {
+			t.Fatal("Cannot perform the request")
+		}
+		if res.StatusCode != test.status {
+			t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+		}
+		if test.status != 200 {
+			continue
+		}
+		if res.Header.Get("Content-Type") != test.mime {
+			t.Errorf("Invalid content type: (url=%s) (type=%s)", url, res.Header.Get("Content-Type"))
+		}
+
+		image, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertSize(image, test.width, test.height); err != nil {
+			t.Errorf("%s: %s", url, err)
+		}
+	}
+
+	// The negotiated format varies by Accept header
+	req, _ := http.NewRequest("GET", ts.URL+"/testdata/large.jpg?origin=qic0bfzg&w=300&auto=format", nil)
+	req.Header.Set("Accept", "image/webp,*/*")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 || res.Header.Get("Vary") != "Accept" {
+		t.Errorf("Invalid negotiated response: (res=%+v)", res)
+	}
+}
+
+func TestRouteMarker(t *testing.T) {
+	pathOpts := ServerOptions{OriginSlugDetectPathPattern: `^/([a-z0-9]+)/`}
+	cases := []struct {
+		path     string
+		opts     ServerOptions
+		expected string
+	}{
+		{"/c!/w=300/image.jpg", ServerOptions{}, "c!"},
+		{"/i!/image.jpg", ServerOptions{}, "i!"},
+		{"/qic0bfzg/i!/image.jpg", pathOpts, "i!"},
+		{"/h!/a.jpg/h!/b.jpg", ServerOptions{}, "h!"},
+		{"/image.jpg", ServerOptions{}, ""},
+		{"/photos/foo!/image.jpg", ServerOptions{}, ""},
+		{"/qic0bfzg/i!/image.jpg", ServerOptions{}, ""},
+		{"/qic0bfzg/photos/foo!/image.jpg", pathOpts, ""},
+		{"/iiif/3/foo!/full/max/0/default.jpg", ServerOptions{}, ""},
+	}
+
+	for _, c := range cases {
+		if actual := routeMarker(c.path, c.opts); actual != c.expected {
+			t.Errorf("Invalid route marker of %s: expected %s, but actual %s", c.path, c.expected, actual)
+		}
+	}
+}
+
+func setupTestSourceServer(opts ServerOptions, httpFunc http.HandlerFunc) (ServerOptions, func()) {
+	source.LoadSources(sourceConfig(opts))
+
+	tsImage := httptest.NewServer(httpFunc)
+
+	tsImageURL, _ := url.Parse(tsImage.URL)
+
+	originMap := map[origin.OriginSlug]*origin.Origin{
+		"qic0bfzg": &origin.Origin{
+			Slug:       "qic0bfzg",
+			SourceType: origin.SourceTypeHTTP,
+			Scheme:     tsImageURL.Scheme,
+			Host:       tsImageURL.Host,
+			PathPrefix: "/",
+		},
+		"jdv9ab8v": &origin.Origin{
+			Slug:                    "jdv9ab8v",
+			SourceType:              origin.SourceTypeHTTP,
+			Scheme:                  tsImageURL.Scheme,
+			Host:                    tsImageURL.Host,
+			PathPrefix:              "/",
+			URLSignatureEnabled:     true,
+			URLSignatureKey:         "secrettest",
+			URLSignatureKey_Version: 1,
+		},
+		"presets1": &origin.Origin{
+			Slug:        "presets1",
+			SourceType:  origin.SourceTypeHTTP,
+			Scheme:      tsImageURL.Scheme,
+			Host:        tsImageURL.Host,
+			PathPrefix:  "/",
+			PresetsOnly: true,
+			Presets:     map[string]string{"card": "w=300,h=200,m=crop"},
+		},
+		"strict1": &origin.Origin{
+			Slug:         "strict1",
+			SourceType:   origin.SourceTypeHTTP,
+			Scheme:       tsImageURL.Scheme,
+			Host:         tsImageURL.Host,
+			PathPrefix:   "/",
+			StrictParams: "strict",
+		},
+		"sigver2": &origin.Origin{
+			Slug:                     "sigver2",
+			SourceType:               origin.SourceTypeHTTP,
+			Scheme:                   tsImageURL.Scheme,
+			Host:                     tsImageURL.Host,
+			PathPrefix:               "/",
+			URLSignatureEnabled:      true,
+			URLSignatureKey:          "secrettestnew",
+			URLSignatureKey_Previous: "secrettest",
+			URLSignatureKey_Version:  2,
+		},
+		"sigoff1": &origin.Origin{
+			Slug:                    "sigoff1",
+			SourceType:              origin.SourceTypeHTTP,
+			Scheme:                  tsImageURL.Scheme,
+			Host:                    tsImageURL.Host,
+			PathPrefix:              "/",
+			URLSignatureKey:         "secrettest",
+			URLSignatureKey_Version: 1,
+		},
+	}
+	opts.OriginRepos = NewMockOriginRepository(originMap)
+
+	return opts, func() {
+		tsImage.Close()
+	}
+}
+
+func TestRemoteHTTPSource(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/c!/w=200,h=200/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 200, 200)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestNewHandler(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		EnablePlaceholder:       true,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	handler, err := NewHandler(opts)
+	if err != nil {
+		t.Fatalf("Cannot create the handler: %s", err)
+	}
+	if len(handler.(*MyHttpHandler).Options.PlaceholderImage) == 0 {
+		t.Error("The default placeholder must be exposed")
+	}
+	if o := handler.(*MyHttpHandler).Options; o.MaxPipelineSteps != DefaultMaxPipelineSteps || o.MaxPipelineMP != DefaultMaxPipelineMP {
+		t.Errorf("The default pipeline limits must be set: %d steps, %dMP", o.MaxPipelineSteps, o.MaxPipelineMP)
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL + "/health")
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status of health: %s", res.Status)
+	}
+
+	url := ts.URL + "/c!/w=200,h=200/testdata/large.jpg?origin=qic0bfzg"
+	res, err = http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+	image, _ := ioutil.ReadAll(res.Body)
+	if err := assertSize(image, 200, 200); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInvalidRemoteHTTPSource(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(400)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	source.LoadSources(sourceConfig(opts))
+
+	ts := httptest.NewServer(fn)
+	url := ts.URL + "/c!/w=200,h=200/testdata/large.jpg?origin=qic0bfzg"
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Request failed")
+	}
+	if res.StatusCode != 400 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+}
+
+func TestURLSignature(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	path := "/c!/w=300/testdata/large.jpg"
+	sig := CreateURLSignatureString(1, path, "secrettest", "")
+	url := ts.URL + path + "?origin=jdv9ab8v" + "&sig=" + sig
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	if res.Header.Get("Content-Length") == "" {
+		t.Fatal("Empty content length response")
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 1080)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestURLSignatureVersion2(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	path := "/c!/w=300/testdata/large.jpg"
+	sig := CreateURLSignatureString(2, path, "secrettestnew", "")
+	url := ts.URL + path + "?origin=sigver2" + "&sig=" + sig
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	if res.Header.Get("Content-Length") == "" {
+		t.Fatal("Empty content length response")
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 1080)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestURLSignatureVersion2Previous(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	path := "/c!/w=300/testdata/large.jpg"
+	sig := CreateURLSignatureString(1, path, "secrettest", "")
+	url := ts.URL + path + "?origin=sigver2" + "&sig=" + sig
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	if res.Header.Get("Content-Length") == "" {
+		t.Fatal("Empty content length response")
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 300, 1080)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func TestExternalHTTPSource(t *testing.T) {
+	opts := ServerOptions{
+		OriginSlugDetectMethods: []OriginSlugDetectMethod{"query"},
+		AllowExternalHTTPSource: true,
+	}
+	opts, td := setupTestSourceServer(opts, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.String() != "/testdata/large.jpg" {
+			t.Fatal("Bad request: " + req.URL.String())
+		}
+		buf, _ := ioutil.ReadFile("../testdata/large.jpg")
+		w.Write(buf)
+	}))
+	defer td()
+
+	fn := ImageMiddleware(opts)
+	ts := httptest.NewServer(fn)
+	tsImageOrigin, _ := opts.OriginRepos.Get("jdv9ab8v")
+	urlFilePath := url.QueryEscape(tsImageOrigin.Scheme + "://" + tsImageOrigin.Host + "/testdata/large.jpg")
+	path := "/c!/w=200,h=200/" + urlFilePath
+	sig := CreateURLSignatureString(1, path, "secrettest", "")
+	url := ts.URL + path + "?origin=jdv9ab8v" + "&sig=" + sig
+	defer ts.Close()
+
+	res, err := http.Get(url)
+	if err != nil {
+		t.Fatal("Cannot perform the request")
+	}
+	if res.StatusCode != 200 {
+		t.Fatalf("Invalid response status: (url=%+v) (res=%+v) (body=%s)", url, res, BodyAsString(res))
+	}
+
+	image, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(image) == 0 {
+		t.Fatalf("Empty response body")
+	}
+
+	err = assertSize(image, 200, 200)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if bimg.DetermineImageTypeName(image) != "jpeg" {
+		t.Fatalf("Invalid image type")
+	}
+}
+
+func testServer(fn func(w http.ResponseWriter, r *http.Request)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(fn))
+}
+
+func readFile(file string) io.Reader {
+	buf, _ := os.Open(path.Join("../testdata", file))
+	return buf
+}
+
+func assertSize(buf []byte, width, height int) error {
+	size, err := bimg.NewImage(buf).Size()
+	if err != nil {
+		return err
+	}
+	if size.Width != width || size.Height != height {
+		return fmt.Errorf("Invalid image size: exprected %dx%d, but actual %dx%d", width, height, size.Width, size.Height)
+	}
+	return nil
+}
+
+func BodyAsString(res *http.Response) string {
+	contentLength, _ := strconv.Atoi(res.Header.Get("Content-Length"))
+	body := make([]byte, contentLength)
+	len, _ := res.Body.Read(body)
+	return string(body[:len])
+}
+
+type MockOriginRepository struct {
+	Origins map[origin.OriginSlug]*origin.Origin
+}
+
+func NewMockOriginRepository(origins map[origin.OriginSlug]*origin.Origin) origin.OriginRepository {
+	return &MockOriginRepository{Origins: origins}
+}
+
+func (repo *MockOriginRepository) Open() error {
+	return nil
+}
+
+func (repo *MockOriginRepository) Close() {
+}
+
+func (repo *MockOriginRepository) Get(originSlug origin.OriginSlug) (*origin.Origin, error) {
+	origin, ok := repo.Origins[originSlug]
+	if !ok {
+		return nil, fmt.Errorf("Origin not found: (originSlug=%s)", originSlug)
+	}
+
+	return origin, nil
+}
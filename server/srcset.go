@@ -0,0 +1,256 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+const maxSrcsetCandidates = 16
+
+// SrcsetOptions represents the variants of the srcset manifest,
+// e.g. widths=320:640:1280,dprs=1:2,formats=webp:jpeg
+type SrcsetOptions struct {
+	Widths  []int
+	DPRs    []float64
+	Formats []string
+}
+
+// SrcsetManifest represents the srcset of each format.
+// The last source is the fallback for the <img> element.
+type SrcsetManifest struct {
+	Src     string         `json:"src"`
+	Sources []SrcsetSource `json:"sources"`
+}
+
+type SrcsetSource struct {
+	Type       string            `json:"type,omitempty"`
+	Srcset     string            `json:"srcset"`
+	Candidates []SrcsetCandidate `json:"candidates"`
+}
+
+type SrcsetCandidate struct {
+	URL        string `json:"url"`
+	Descriptor string `json:"descriptor"`
+}
+
+// srcsetParam represents a key and value of /c!/ params, whose order is kept.
+type srcsetParam struct {
+	Key   string
+	Value string
+}
+
+func parseSrcsetOptions(s string) (SrcsetOptions, error) {
+	var so SrcsetOptions
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return so, fmt.Errorf("Invalid srcset param: %s", pair)
+		}
+
+		for _, v := range strings.Split(kv[1], ":") {
+			switch kv[0] {
+			case "widths":
+				w, err := strconv.Atoi(v)
+				if err != nil || w <= 0 {
+					return so, fmt.Errorf("Invalid srcset width: %s", v)
+				}
+				so.Widths = append(so.Widths, w)
+			case "dprs":
+				d, err := strconv.ParseFloat(v, 64)
+				if err != nil || d <= 0 || d > 4 {
+					return so, fmt.Errorf("Invalid srcset DPR: %s", v)
+				}
+				so.DPRs = append(so.DPRs, d)
+			case "formats":
+				if processing.ImageType(v) == 0 {
+					return so, fmt.Errorf("Invalid srcset format: %s", v)
+				}
+				so.Formats = append(so.Formats, v)
+			default:
+				return so, fmt.Errorf("Unknown srcset param: %s", kv[0])
+			}
+		}
+	}
+
+	if len(so.Widths) == 0 && len(so.DPRs) == 0 {
+		return so, fmt.Errorf("Either widths or dprs is required")
+	}
+	if n := len(so.Widths); n*len(so.DPRs) > maxSrcsetCandidates || n > maxSrcsetCandidates || len(so.DPRs) > maxSrcsetCandidates {
+		return so, fmt.Errorf("Too many srcset candidates (max=%d)", maxSrcsetCandidates)
+	}
+	return so, nil
+}
+
+func parseSrcsetTemplate(template string) []srcsetParam {
+	var params []srcsetParam
+	for _, pair := range strings.Split(template, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) == 2 && kv[0] != "" {
+			params = append(params, srcsetParam{kv[0], kv[1]})
+		}
+	}
+	return params
+}
+
+// formatSrcsetTemplate builds /c!/ params from the template, the given keys are overwritten.
+func formatSrcsetTemplate(params []srcsetParam, overrides map[string]string) string {
+	var b strings.Builder
+	written := make(map[string]bool)
+	for _, p := range params {
+		v := p.Value
+		if o, ok := overrides[p.Key]; ok {
+			v = o
+			written[p.Key] = true
+		}
+		if b.Len() > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString(p.Key + "=" + v)
+	}
+	for _, key := range []string{"w", "h", "f"} {
+		if v, ok := overrides[key]; ok && !written[key] {
+			if b.Len() > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(key + "=" + v)
+		}
+	}
+	return b.String()
+}
+
+// SrcsetVariants returns /c!/ params and srcset descriptors of the variants of the template for the format.
+// Widths produce width descriptors (the widths are multiplied by the DPRs), otherwise the template size
+// is multiplied by the DPRs and produces pixel density descriptors.
+func SrcsetVariants(template string, so SrcsetOptions, format string) ([]string, []string, error) {
+	params := parseSrcsetTemplate(template)
+	var tw, th int
+	for _, p := range params {
+		switch p.Key {
+		case "w":
+			tw = processing.ParseInt(p.Value)
+		case "h":
+			th = processing.ParseInt(p.Value)
+		}
+	}
+
+	dprs := so.DPRs
+	if len(dprs) == 0 {
+		dprs = []float64{1}
+	}
+
+	var variants, descriptors []string
+	seen := make(map[string]bool)
+	add := func(w, h int, descriptor string) {
+		if seen[descriptor] {
+			return
+		}
+		seen[descriptor] = true
+
+		overrides := make(map[string]string)
+		if w > 0 {
+			overrides["w"] = strconv.Itoa(w)
+		}
+		if h > 0 {
+			overrides["h"] = strconv.Itoa(h)
+		}
+		if format != "" {
+			overrides["f"] = format
+		}
+		variants = append(variants, formatSrcsetTemplate(params, overrides))
+		descriptors = append(descriptors, descriptor)
+	}
+
+	if len(so.Widths) > 0 {
+		for _, width := range so.Widths {
+			for _, dpr := range dprs {
+				w := int(math.Floor(float64(width)*dpr + 0.5))
+				h := 0
+				if tw > 0 && th > 0 {
+					// Keep the aspect ratio of the template
+					h = int(math.Floor(float64(th)*float64(w)/float64(tw) + 0.5))
+				}
+				add(w, h, strconv.Itoa(w)+"w")
+			}
+		}
+	} else {
+		if tw == 0 && th == 0 {
+			return nil, nil, fmt.Errorf("The template must have w or h params for dprs")
+		}
+		for _, dpr := range dprs {
+			w := int(math.Floor(float64(tw)*dpr + 0.5))
+			h := int(math.Floor(float64(th)*dpr + 0.5))
+			add(w, h, strconv.FormatFloat(dpr, 'f', -1, 64)+"x")
+		}
+	}
+
+	return variants, descriptors, nil
+}
+
+// NewSrcsetManifest builds the manifest whose URLs are built by urlFunc from /c!/ params.
+func NewSrcsetManifest(template string, so SrcsetOptions, urlFunc func(params string) string, o ServerOptions) (SrcsetManifest, error) {
+	formats := so.Formats
+	if len(formats) == 0 {
+		formats = []string{""}
+	}
+
+	var manifest SrcsetManifest
+	for _, format := range formats {
+		variants, descriptors, err := SrcsetVariants(template, so, format)
+		if err != nil {
+			return manifest, err
+		}
+
+		source := SrcsetSource{}
+		if format != "" {
+			source.Type = processing.GetImageMimeType(processing.ImageType(format))
+		}
+		srcset := make([]string, len(variants))
+		for i, params := range variants {
+			if err := validateImageOptions(processing.ReadParams(params, nil), o); err != nil {
+				return manifest, err
+			}
+			c := SrcsetCandidate{URL: urlFunc(params), Descriptor: descriptors[i]}
+			source.Candidates = append(source.Candidates, c)
+			srcset[i] = c.URL + " " + c.Descriptor
+		}
+		source.Srcset = strings.Join(srcset, ", ")
+		manifest.Sources = append(manifest.Sources, source)
+	}
+
+	fallback := manifest.Sources[len(manifest.Sources)-1]
+	manifest.Src = fallback.Candidates[0].URL
+	return manifest, nil
+}
+
+// JSON returns the manifest as JSON
+func (m SrcsetManifest) JSON() []byte {
+	buf, _ := json.Marshal(m)
+	return buf
+}
+
+// HTML returns the <picture> element of the manifest
+func (m SrcsetManifest) HTML(sizes, alt string) []byte {
+	var b strings.Builder
+	sizesAttr := ""
+	if sizes != "" {
+		sizesAttr = ` sizes="` + html.EscapeString(sizes) + `"`
+	}
+
+	b.WriteString("<picture>")
+	for i, s := range m.Sources {
+		if i == len(m.Sources)-1 {
+			break
+		}
+		fmt.Fprintf(&b, `<source type="%s" srcset="%s"%s>`, html.EscapeString(s.Type), html.EscapeString(s.Srcset), sizesAttr)
+	}
+	fallback := m.Sources[len(m.Sources)-1]
+	fmt.Fprintf(&b, `<img src="%s" srcset="%s"%s alt="%s">`, html.EscapeString(m.Src), html.EscapeString(fallback.Srcset), sizesAttr, html.EscapeString(alt))
+	b.WriteString("</picture>")
+	return []byte(b.String())
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSrcsetOptions(t *testing.T) {
+	cases := []struct {
+		params string
+		so     SrcsetOptions
+		err    bool
+	}{
+		{"widths=320:640", SrcsetOptions{Widths: []int{320, 640}}, false},
+		{"dprs=1:1.5:2,formats=webp:jpeg", SrcsetOptions{DPRs: []float64{1, 1.5, 2}, Formats: []string{"webp", "jpeg"}}, false},
+		{"widths=320,dprs=2", SrcsetOptions{Widths: []int{320}, DPRs: []float64{2}}, false},
+		{"formats=webp", SrcsetOptions{}, true},
+		{"widths=0", SrcsetOptions{}, true},
+		{"dprs=5", SrcsetOptions{}, true},
+		{"widths=320,formats=bmp", SrcsetOptions{}, true},
+		{"widths=320,x=1", SrcsetOptions{}, true},
+		{"widths", SrcsetOptions{}, true},
+	}
+
+	for _, c := range cases {
+		so, err := parseSrcsetOptions(c.params)
+		if (err != nil) != c.err {
+			t.Errorf("Unexpected error: (params=%s) (err=%v)", c.params, err)
+			continue
+		}
+		if !c.err && !reflect.DeepEqual(so, c.so) {
+			t.Errorf("Invalid options: (params=%s) (got=%+v)", c.params, so)
+		}
+	}
+}
+
+func TestSrcsetVariants(t *testing.T) {
+	cases := []struct {
+		template    string
+		so          SrcsetOptions
+		format      string
+		variants    []string
+		descriptors []string
+	}{
+		{
+			"w=300,h=200,m=crop", SrcsetOptions{Widths: []int{150, 600}}, "",
+			[]string{"w=150,h=100,m=crop", "w=600,h=400,m=crop"}, []string{"150w", "600w"},
+		},
+		{
+			"q=80", SrcsetOptions{Widths: []int{320}, DPRs: []float64{1, 2}}, "webp",
+			[]string{"q=80,w=320,f=webp", "q=80,w=640,f=webp"}, []string{"320w", "640w"},
+		},
+		{
+			"w=100,f=auto", SrcsetOptions{DPRs: []float64{1, 1.5, 2}}, "png",
+			[]string{"w=100,f=png", "w=150,f=png", "w=200,f=png"}, []string{"1x", "1.5x", "2x"},
+		},
+		{
+			"w=320", SrcsetOptions{Widths: []int{320, 160}, DPRs: []float64{1, 2}}, "",
+			[]string{"w=320", "w=640", "w=160"}, []string{"320w", "640w", "160w"},
+		},
+	}
+
+	for _, c := range cases {
+		variants, descriptors, err := SrcsetVariants(c.template, c.so, c.format)
+		if err != nil {
+			t.Errorf("Unexpected error: (template=%s) (err=%v)", c.template, err)
+			continue
+		}
+		if !reflect.DeepEqual(variants, c.variants) || !reflect.DeepEqual(descriptors, c.descriptors) {
+			t.Errorf("Invalid variants: (template=%s) (got=%v %v)", c.template, variants, descriptors)
+		}
+	}
+
+	if _, _, err := SrcsetVariants("q=80", SrcsetOptions{DPRs: []float64{2}}, ""); err == nil {
+		t.Error("Expected error for dprs without size")
+	}
+}
+
+func TestSrcsetManifestHTML(t *testing.T) {
+	so := SrcsetOptions{Widths: []int{100, 200}, Formats: []string{"webp", "jpeg"}}
+	manifest, err := NewSrcsetManifest("q=80", so, func(params string) string {
+		return "/c!/" + params + "/a.jpg?x=1&y=2"
+	}, ServerOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(manifest.Sources) != 2 || manifest.Sources[0].Type != "image/webp" || manifest.Src != "/c!/q=80,w=100,f=jpeg/a.jpg?x=1&y=2" {
+		t.Fatalf("Invalid manifest: %+v", manifest)
+	}
+
+	expected := `<picture><source type="image/webp" srcset="/c!/q=80,w=100,f=webp/a.jpg?x=1&amp;y=2 100w, /c!/q=80,w=200,f=webp/a.jpg?x=1&amp;y=2 200w" sizes="50vw">` +
+		`<img src="/c!/q=80,w=100,f=jpeg/a.jpg?x=1&amp;y=2" srcset="/c!/q=80,w=100,f=jpeg/a.jpg?x=1&amp;y=2 100w, /c!/q=80,w=200,f=jpeg/a.jpg?x=1&amp;y=2 200w" sizes="50vw" alt="&#34;a&#34;"></picture>`
+	if html := string(manifest.HTML("50vw", `"a"`)); html != expected {
+		t.Errorf("Invalid HTML:\n%s", strings.Replace(html, "><", ">\n<", -1))
+	}
+
+	if _, err := NewSrcsetManifest("h=4000", SrcsetOptions{Widths: []int{4000}}, func(string) string { return "" }, ServerOptions{MaxOutputMP: 1}); err == nil {
+		t.Error("Expected error for the output area")
+	}
+}
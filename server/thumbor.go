@@ -0,0 +1,371 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+var (
+	thumborCropPattern = regexp.MustCompile(`^(\d+)x(\d+):(\d+)x(\d+)$`)
+	thumborSizePattern = regexp.MustCompile(`^(-?)(\d+|orig)?x(-?)(\d+|orig)?$`)
+)
+
+var thumborHAligns = map[string]bool{"left": true, "center": true, "right": true}
+var thumborVAligns = map[string]bool{"top": true, "middle": true, "bottom": true}
+
+var thumborFormats = map[string]string{
+	"jpeg": "jpeg",
+	"jpg":  "jpeg",
+	"png":  "png",
+	"webp": "webp",
+	"gif":  "gif",
+}
+
+var thumborColors = map[string]string{
+	"white": "ffffff",
+	"black": "000000",
+}
+
+// ThumborRequest represents the parsed Thumbor URL:
+// /unsafe|<hmac>/meta/trim/AxB:CxD/fit-in/-WxH/halign/valign/smart/filters:.../<image>
+type ThumborRequest struct {
+	Signature  string // HMAC or "unsafe"
+	SignedPath string // The part of the path after the signature
+	Meta       bool
+	Trim       bool
+	Crop       []int  // left, top, right, bottom
+	FitIn      string // "fit-in", "adaptive-fit-in" or "full-fit-in"
+	Width      string // Pixels, "orig" or empty
+	Height     string
+	FlipH      bool
+	FlipV      bool
+	HAlign     string
+	VAlign     string
+	Smart      bool
+	Filters    []ThumborFilter
+	Image      string
+}
+
+// ThumborFilter represents the filter like "quality(80)"
+type ThumborFilter struct {
+	Name string
+	Args []string
+}
+
+// parseThumborPath parses the Thumbor URL path which follows the route marker.
+func parseThumborPath(path string) (ThumborRequest, error) {
+	r := ThumborRequest{}
+
+	i := strings.Index(path, "/")
+	if i <= 0 {
+		return r, processing.NewError("Invalid Thumbor URL: "+path, processing.BadRequest)
+	}
+	r.Signature = path[:i]
+	r.SignedPath = path[i+1:]
+
+	rest := r.SignedPath
+	next := func() string {
+		if i := strings.Index(rest, "/"); i >= 0 {
+			return rest[:i]
+		}
+		return ""
+	}
+	consume := func() {
+		rest = rest[strings.Index(rest, "/")+1:]
+	}
+
+	if next() == "meta" {
+		r.Meta = true
+		consume()
+	}
+	if s := next(); s == "trim" || strings.HasPrefix(s, "trim:") {
+		r.Trim = true
+		consume()
+	}
+	if m := thumborCropPattern.FindStringSubmatch(next()); m != nil {
+		r.Crop = make([]int, 4)
+		for j := range r.Crop {
+			r.Crop[j], _ = strconv.Atoi(m[j+1])
+		}
+		consume()
+	}
+	if s := next(); s == "fit-in" || s == "adaptive-fit-in" || s == "full-fit-in" {
+		r.FitIn = s
+		consume()
+	}
+	if m := thumborSizePattern.FindStringSubmatch(next()); m != nil {
+		r.FlipH, r.Width = m[1] == "-", m[2]
+		r.FlipV, r.Height = m[3] == "-", m[4]
+		consume()
+	}
+	if s := next(); thumborHAligns[s] {
+		r.HAlign = s
+		consume()
+	}
+	if s := next(); thumborVAligns[s] {
+		r.VAlign = s
+		consume()
+	}
+	if next() == "smart" {
+		r.Smart = true
+		consume()
+	}
+
+	// The arguments of the filters may contain slashes (e.g. the URL of the watermark)
+	if strings.HasPrefix(rest, "filters:") {
+		end := strings.Index(rest, ")/")
+		if end < 0 {
+			return r, processing.NewError("Invalid Thumbor filters: "+rest, processing.BadRequest)
+		}
+		filters, err := parseThumborFilters(rest[len("filters:") : end+1])
+		if err != nil {
+			return r, err
+		}
+		r.Filters = filters
+		rest = rest[end+2:]
+	}
+
+	if rest == "" {
+		return r, processing.NewError("Missing Thumbor image path: "+path, processing.BadRequest)
+	}
+	r.Image = rest
+
+	return r, nil
+}
+
+// parseThumborFilters parses the filters like "quality(80):format(webp)".
+func parseThumborFilters(s string) ([]ThumborFilter, error) {
+	var filters []ThumborFilter
+	for s != "" {
+		open := strings.Index(s, "(")
+		close := strings.Index(s, ")")
+		if open <= 0 || close < open {
+			return nil, processing.NewError("Invalid Thumbor filters: "+s, processing.BadRequest)
+		}
+
+		f := ThumborFilter{Name: s[:open]}
+		if args := s[open+1 : close]; args != "" {
+			f.Args = strings.Split(args, ",")
+		}
+		filters = append(filters, f)
+
+		s = s[close+1:]
+		if s != "" && s[0] != ':' {
+			return nil, processing.NewError("Invalid Thumbor filters: "+s, processing.BadRequest)
+		}
+		s = strings.TrimPrefix(s, ":")
+	}
+	return filters, nil
+}
+
+// validateThumborSignature checks the HMAC-SHA1 signature of the path with the key of the origin.
+// Unsafe URL is accepted only if the origin does not require the URL signature.
+func validateThumborSignature(r ThumborRequest, origin *origin.Origin) *processing.Error {
+	if r.Signature == "unsafe" {
+		if origin.URLSignatureEnabled {
+			return &ErrInvalidURLSignature
+		}
+		return nil
+	}
+
+	for _, key := range []string{origin.URLSignatureKey, origin.URLSignatureKey_Previous} {
+		if key != "" && hmac.Equal([]byte(r.Signature), []byte(CalcThumborSignatureValue(r.SignedPath, key))) {
+			return nil
+		}
+	}
+	return &ErrURLSignatureMismatch
+}
+
+// CalcThumborSignatureValue returns the Thumbor signature (URL-safe base64 of HMAC-SHA1) of the path.
+func CalcThumborSignatureValue(path string, key string) string {
+	mac := hmac.New(sha1.New, []byte(key))
+	mac.Write([]byte(path))
+	return base64.URLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ThumborImageOptions maps the Thumbor request onto ImageOptions for the image which has the given size.
+func ThumborImageOptions(r ThumborRequest, width, height int) (processing.ImageOptions, error) {
+	opts := processing.ImageOptions{
+		ResizeMode:     processing.ResizeModeScale,
+		Gravity:        processing.Gravity9MiddleCenter,
+		OverlayGravity: processing.Gravity9MiddleCenter,
+		Upscale:        r.FitIn == "",
+	}
+
+	if r.Trim {
+		return opts, processing.NewError("Thumbor trim is not supported", processing.NotImplemented)
+	}
+	// The JSON of the image is not in the schema of Thumbor meta
+	if r.Meta {
+		return opts, processing.NewError("Thumbor meta is not supported", processing.NotImplemented)
+	}
+
+	if r.Crop != nil {
+		left, top, right, bottom := r.Crop[0], r.Crop[1], processing.MinInt(r.Crop[2], width), processing.MinInt(r.Crop[3], height)
+		if right <= left || bottom <= top {
+			return opts, processing.NewError("Invalid Thumbor crop area", processing.BadRequest)
+		}
+		opts.Clip = []int{left, top, right, bottom}
+		width, height = right-left, bottom-top
+	}
+
+	w, h := thumborDimension(r.Width, width), thumborDimension(r.Height, height)
+	orig := w == 0 && h == 0
+	if orig {
+		w, h = width, height
+	}
+	if r.FitIn == "adaptive-fit-in" && w > 0 && h > 0 && (w > h) != (width > height) {
+		w, h = h, w
+	}
+
+	switch {
+	case orig || w == 0 || h == 0:
+		// Keep the aspect ratio
+	case r.FitIn == "full-fit-in":
+		// The image covers the box without cropping
+		scale := math.Max(float64(w)/float64(width), float64(h)/float64(height))
+		w, h = processing.Round(float64(width)*scale), processing.Round(float64(height)*scale)
+	case r.FitIn != "":
+		opts.ResizeMode = processing.ResizeModeFit
+	default:
+		opts.ResizeMode = processing.ResizeModeCrop
+	}
+	opts.Width, opts.Height = w, h
+
+	if r.Smart {
+		opts.Gravity = processing.Gravity9Smart
+	} else if r.HAlign != "" || r.VAlign != "" {
+		halign, valign := r.HAlign, r.VAlign
+		if halign == "" {
+			halign = "center"
+		}
+		if valign == "" {
+			valign = "middle"
+		}
+		opts.Gravity = processing.AlignGravities[valign+"/"+halign]
+	}
+
+	rotate := 0
+	for _, f := range r.Filters {
+		var err error
+		rotate, err = applyThumborFilter(&opts, f, rotate)
+		if err != nil {
+			return opts, err
+		}
+	}
+
+	// Thumbor flips, then rotates counter-clockwise,
+	// but libvips rotates clockwise, then mirrors horizontally.
+	switch {
+	case r.FlipH && r.FlipV:
+		opts.Rotate = (540 - rotate) % 360
+	case r.FlipH:
+		opts.Rotate = rotate
+		opts.Flop = true
+	case r.FlipV:
+		opts.Rotate = (rotate + 180) % 360
+		opts.Flop = true
+	default:
+		opts.Rotate = (360 - rotate) % 360
+	}
+	if rotate == 90 || rotate == 270 {
+		// libvips resizes after the rotation
+		opts.Width, opts.Height = opts.Height, opts.Width
+	}
+
+	return opts, nil
+}
+
+// applyThumborFilter applies the filter to the options. The rotation is returned,
+// since it must be combined with the flips.
+func applyThumborFilter(opts *processing.ImageOptions, f ThumborFilter, rotate int) (int, error) {
+	invalid := processing.NewError("Invalid Thumbor filter: "+f.Name+"("+strings.Join(f.Args, ",")+")", processing.BadRequest)
+	arg := func(i int) string {
+		if i < len(f.Args) {
+			return strings.TrimSpace(f.Args[i])
+		}
+		return ""
+	}
+
+	switch f.Name {
+	case "quality":
+		q, err := strconv.Atoi(arg(0))
+		if err != nil || q < 1 || q > 100 {
+			return rotate, invalid
+		}
+		opts.Quality = q
+	case "format":
+		format, ok := thumborFormats[strings.ToLower(arg(0))]
+		if !ok {
+			return rotate, processing.NewError("Thumbor format is not supported: "+arg(0), processing.NotImplemented)
+		}
+		opts.OutputFormat = format
+	case "grayscale":
+		opts.Monochrome = true
+	case "strip_exif", "strip_icc":
+		opts.MetadataMode = processing.MetadataModeStrip
+	case "upscale":
+		opts.Upscale = true
+	case "no_upscale":
+		opts.Upscale = false
+	case "blur":
+		radius, err := strconv.ParseFloat(arg(0), 64)
+		if err != nil || radius < 0 {
+			return rotate, invalid
+		}
+		opts.BlurSigma = radius
+		if sigma, err := strconv.ParseFloat(arg(1), 64); err == nil && sigma > 0 {
+			opts.BlurSigma = sigma
+		}
+	case "rotate":
+		degree, err := strconv.Atoi(arg(0))
+		if err != nil || degree%90 != 0 {
+			return rotate, processing.NewError("Arbitrary Thumbor rotation is not supported: "+arg(0), processing.NotImplemented)
+		}
+		rotate = ((rotate+degree)%360 + 360) % 360
+	case "fill", "background_color":
+		color := strings.ToLower(arg(0))
+		if c, ok := thumborColors[color]; ok {
+			color = c
+		}
+		if !processing.HexColorPattern.MatchString(color) {
+			return rotate, processing.NewError("Thumbor fill color is not supported: "+arg(0), processing.NotImplemented)
+		}
+		opts.Background = processing.ParseHexColor(color)
+		if f.Name == "fill" && opts.ResizeMode == processing.ResizeModeFit {
+			// The fitted image is padded to the box
+			opts.ResizeMode = processing.ResizeModePad
+		}
+	case "watermark":
+		x, errX := strconv.Atoi(arg(1))
+		y, errY := strconv.Atoi(arg(2))
+		alpha, errA := strconv.Atoi(arg(3))
+		if arg(0) == "" || errX != nil || errY != nil || errA != nil || x < 0 || y < 0 || alpha < 0 || alpha > 100 {
+			return rotate, invalid
+		}
+		opts.OverlayURL = arg(0)
+		opts.OverlayX, opts.OverlayY = x, y
+		// The alpha of Thumbor is the transparency
+		opts.OverlayOpacity = float32(100-alpha) / 100
+	default:
+		return rotate, processing.NewError("Thumbor filter is not supported: "+f.Name, processing.NotImplemented)
+	}
+	return rotate, nil
+}
+
+// thumborDimension returns the pixels of the width or height: "orig" is the size of the image.
+func thumborDimension(value string, orig int) int {
+	if value == "orig" {
+		return orig
+	}
+	n, _ := strconv.Atoi(value)
+	return n
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tsu1980/thumbnary/origin"
+	"github.com/tsu1980/thumbnary/processing"
+)
+
+func TestParseThumborPath(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected ThumborRequest
+	}{
+		{
+			"unsafe/300x200/image.jpg",
+			ThumborRequest{Signature: "unsafe", SignedPath: "300x200/image.jpg", Width: "300", Height: "200", Image: "image.jpg"},
+		},
+		{
+			"unsafe/meta/10x20:110x220/fit-in/-300x-200/left/top/smart/path/to/image.jpg",
+			ThumborRequest{
+				Signature: "unsafe", SignedPath: "meta/10x20:110x220/fit-in/-300x-200/left/top/smart/path/to/image.jpg",
+				Meta: true, Crop: []int{10, 20, 110, 220}, FitIn: "fit-in", Width: "300", Height: "200", FlipH: true, FlipV: true,
+				HAlign: "left", VAlign: "top", Smart: true, Image: "path/to/image.jpg",
+			},
+		},
+		{
+			"abc=/x200/bottom/filters:quality(80):watermark(http://example.com/a.png,10,20,50)/image.jpg",
+			ThumborRequest{
+				Signature: "abc=", SignedPath: "x200/bottom/filters:quality(80):watermark(http://example.com/a.png,10,20,50)/image.jpg",
+				Height: "200", VAlign: "bottom", Image: "image.jpg",
+				Filters: []ThumborFilter{
+					{Name: "quality", Args: []string{"80"}},
+					{Name: "watermark", Args: []string{"http://example.com/a.png", "10", "20", "50"}},
+				},
+			},
+		},
+		{
+			"unsafe/filters:grayscale()/image.jpg",
+			ThumborRequest{Signature: "unsafe", SignedPath: "filters:grayscale()/image.jpg", Filters: []ThumborFilter{{Name: "grayscale"}}, Image: "image.jpg"},
+		},
+	}
+
+	for _, c := range cases {
+		actual, err := parseThumborPath(c.path)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", c.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Invalid Thumbor request of %s: expected %+v, but actual %+v", c.path, c.expected, actual)
+		}
+	}
+
+	for _, path := range []string{"unsafe", "unsafe/300x200/", "unsafe/filters:quality(80/image.jpg", "unsafe/filters:quality(80)grayscale()/image.jpg"} {
+		if _, err := parseThumborPath(path); err == nil {
+			t.Errorf("Invalid Thumbor URL must be rejected: %s", path)
+		}
+	}
+}
+
+func TestThumborImageOptions(t *testing.T) {
+	cases := []struct {
+		path     string
+		expected processing.ImageOptions
+	}{
+		{"unsafe/300x200/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true}},
+		{"unsafe/300x0/a.jpg", processing.ImageOptions{Width: 300, ResizeMode: processing.ResizeModeScale, Upscale: true}},
+		{"unsafe/a.jpg", processing.ImageOptions{Width: 1000, Height: 500, ResizeMode: processing.ResizeModeScale, Upscale: true}},
+		{"unsafe/origx100/a.jpg", processing.ImageOptions{Width: 1000, Height: 100, ResizeMode: processing.ResizeModeCrop, Upscale: true}},
+		{"unsafe/fit-in/300x200/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeFit}},
+		{"unsafe/adaptive-fit-in/200x300/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeFit}},
+		{"unsafe/full-fit-in/300x300/a.jpg", processing.ImageOptions{Width: 600, Height: 300, ResizeMode: processing.ResizeModeScale}},
+		{"unsafe/fit-in/300x200/filters:fill(white)/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModePad, Background: []uint8{255, 255, 255}}},
+		{"unsafe/100x200:300x400/a.jpg", processing.ImageOptions{Width: 200, Height: 200, ResizeMode: processing.ResizeModeScale, Upscale: true, Clip: []int{100, 200, 300, 400}}},
+		{"unsafe/300x200/right/bottom/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Gravity: processing.Gravity9BottomRight}},
+		{"unsafe/300x200/top/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Gravity: processing.Gravity9TopCenter}},
+		{"unsafe/300x200/smart/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Gravity: processing.Gravity9Smart}},
+		{"unsafe/-300x200/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Flop: true}},
+		{"unsafe/300x-200/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Flop: true, Rotate: 180}},
+		{"unsafe/-300x-200/a.jpg", processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, Rotate: 180}},
+		{"unsafe/300x200/filters:rotate(90)/a.jpg", processing.ImageOptions{Width: 200, Height: 300, ResizeMode: processing.ResizeModeCrop, Upscale: true, Rotate: 270}},
+		{
+			"unsafe/300x200/filters:quality(60):format(webp):grayscale():strip_exif():no_upscale():blur(3)/a.jpg",
+			processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Quality: 60, OutputFormat: "webp", Monochrome: true, MetadataMode: processing.MetadataModeStrip, BlurSigma: 3},
+		},
+		{
+			"unsafe/300x200/filters:watermark(logo.png,10,20,25)/a.jpg",
+			processing.ImageOptions{Width: 300, Height: 200, ResizeMode: processing.ResizeModeCrop, Upscale: true, OverlayURL: "logo.png", OverlayX: 10, OverlayY: 20, OverlayOpacity: 0.75},
+		},
+	}
+
+	for _, c := range cases {
+		r, err := parseThumborPath(c.path)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", c.path, err)
+			continue
+		}
+		actual, err := ThumborImageOptions(r, 1000, 500)
+		if err != nil {
+			t.Errorf("Cannot map %s: %s", c.path, err)
+			continue
+		}
+		if c.expected.Gravity == 0 {
+			c.expected.Gravity = processing.Gravity9MiddleCenter
+		}
+		c.expected.OverlayGravity = processing.Gravity9MiddleCenter
+		if !reflect.DeepEqual(actual, c.expected) {
+			t.Errorf("Invalid options of %s: expected %+v, but actual %+v", c.path, c.expected, actual)
+		}
+	}
+
+	for _, path := range []string{
+		"unsafe/trim/300x200/a.jpg",
+		"unsafe/meta/300x200/a.jpg",
+		"unsafe/300x200/filters:quality(0)/a.jpg",
+		"unsafe/300x200/filters:format(avif)/a.jpg",
+		"unsafe/300x200/filters:rotate(45)/a.jpg",
+		"unsafe/300x200/filters:sharpen(1,1,true)/a.jpg",
+		"unsafe/300x200/filters:fill(blur)/a.jpg",
+		"unsafe/2000x0:3000x100/a.jpg",
+	} {
+		r, err := parseThumborPath(path)
+		if err != nil {
+			t.Errorf("Cannot parse %s: %s", path, err)
+			continue
+		}
+		if _, err := ThumborImageOptions(r, 1000, 500); err == nil {
+			t.Errorf("Unsupported Thumbor request must be rejected: %s", path)
+		}
+	}
+}
+
+func TestValidateThumborSignature(t *testing.T) {
+	signed := &origin.Origin{URLSignatureEnabled: true, URLSignatureKey: "secretnew", URLSignatureKey_Previous: "secret"}
+	unsigned := &origin.Origin{}
+	path := "300x200/smart/image.jpg"
+
+	cases := []struct {
+		signature string
+		origin    *origin.Origin
+		valid     bool
+	}{
+		{"unsafe", unsigned, true},
+		{"unsafe", signed, false},
+		{CalcThumborSignatureValue(path, "secretnew"), signed, true},
+		{CalcThumborSignatureValue(path, "secret"), signed, true},
+		{CalcThumborSignatureValue(path, "other"), signed, false},
+		{CalcThumborSignatureValue(path, "secret"), unsigned, false},
+	}
+
+	for _, c := range cases {
+		err := validateThumborSignature(ThumborRequest{Signature: c.signature, SignedPath: path}, c.origin)
+		if (err == nil) != c.valid {
+			t.Errorf("Invalid signature validation of %s: expected valid=%t, but actual %v", c.signature, c.valid, err)
+		}
+	}
+
+	// The signature of libthumbor: HMAC-SHA1 of the path, URL safe base64 encoded
+	expected := "n1hVkIqU8w2apwY94UEROBEikFo="
+	if actual := CalcThumborSignatureValue("300x200/image.jpg", "MY_SECURE_KEY"); actual != expected {
+		t.Errorf("Invalid signature: expected %s, but actual %s", expected, actual)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/golang-lru"
+	"github.com/tsu1980/thumbnary/origin"
+)
+
+const (
+	tileSourceCacheBytes = 256 * 1024 * 1024
+	tileSourceCacheTTL   = 5 * time.Minute
+)
+
+// Source images are reused across the tile requests of the same image
+var tileSourceCache = newSourceCache(tileSourceCacheBytes, tileSourceCacheTTL)
+
+func init() {
+	// Expire the source images of the changed origin
+	origin.OnChange(func(originSlug origin.OriginSlug) {
+		if originSlug == "" {
+			tileSourceCache.Purge()
+		} else {
+			tileSourceCache.RemoveOrigin(originSlug)
+		}
+	})
+}
+
+// sourceCache is the LRU cache of the source images which is bounded by the total bytes.
+// The images expire by the TTL, so that the changed source image is fetched again.
+type sourceCache struct {
+	mu       sync.Mutex
+	lru      *lru.Cache
+	bytes    int
+	maxBytes int
+	ttl      time.Duration
+}
+
+type sourceCacheEntry struct {
+	buf     []byte
+	expires time.Time
+}
+
+func newSourceCache(maxBytes int, ttl time.Duration) *sourceCache {
+	c := &sourceCache{maxBytes: maxBytes, ttl: ttl}
+	// The number of the images is bounded by the bytes
+	c.lru, _ = lru.NewWithEvict(4096, func(key interface{}, value interface{}) {
+		c.bytes -= len(value.(sourceCacheEntry).buf)
+	})
+	return c
+}
+
+// Get returns the image of the key unless it is expired
+func (c *sourceCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.lru.Get(key)
+	if !ok {
+		return nil, false
+	}
+	entry := value.(sourceCacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(key)
+		return nil, false
+	}
+	return entry.buf, true
+}
+
+// Add adds the image, and evicts the least recently used images which exceed the bytes
+func (c *sourceCache) Add(key string, buf []byte) {
+	if len(buf) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Remove(key)
+	c.lru.Add(key, sourceCacheEntry{buf: buf, expires: time.Now().Add(c.ttl)})
+	c.bytes += len(buf)
+	for c.bytes > c.maxBytes {
+		c.lru.RemoveOldest()
+	}
+}
+
+// RemoveOrigin removes the images of the origin, since its source may be changed
+func (c *sourceCache) RemoveOrigin(originSlug origin.OriginSlug) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, key := range c.lru.Keys() {
+		if strings.HasPrefix(key.(string), string(originSlug)+":") {
+			c.lru.Remove(key)
+		}
+	}
+}
+
+func (c *sourceCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.lru.Purge()
+	c.bytes = 0
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSourceCache(t *testing.T) {
+	c := newSourceCache(10, time.Minute)
+	c.Add("a:1.jpg", make([]byte, 4))
+	c.Add("a:2.jpg", make([]byte, 4))
+	c.Add("b:1.jpg", make([]byte, 4))
+
+	// The least recently used image is evicted by the bytes
+	if _, ok := c.Get("a:1.jpg"); ok {
+		t.Error("The oldest image must be evicted")
+	}
+	if _, ok := c.Get("b:1.jpg"); !ok || c.bytes != 8 {
+		t.Errorf("Invalid cached bytes: %d", c.bytes)
+	}
+	c.Add("c:1.jpg", make([]byte, 11))
+	if _, ok := c.Get("c:1.jpg"); ok {
+		t.Error("The image which exceeds the bytes must not be cached")
+	}
+
+	c.RemoveOrigin("a")
+	if _, ok := c.Get("a:2.jpg"); ok {
+		t.Error("The image of the changed origin must be removed")
+	}
+	if _, ok := c.Get("b:1.jpg"); !ok || c.bytes != 4 {
+		t.Errorf("The image of the other origin must be kept: %d bytes", c.bytes)
+	}
+
+	c = newSourceCache(10, -time.Second)
+	c.Add("a:1.jpg", make([]byte, 4))
+	if _, ok := c.Get("a:1.jpg"); ok || c.bytes != 0 {
+		t.Error("The expired image must be removed")
+	}
+}
@@ -0,0 +1,45 @@
+package source
+
+import (
+	"net/http"
+
+	"github.com/tsu1980/thumbnary/origin"
+)
+
+type ImageSourceFactoryFunction func(*SourceConfig) ImageSource
+
+type SourceConfig struct {
+	AuthForwarding bool
+	Authorization  string
+	Type           origin.SourceType
+	MaxAllowedSize int
+}
+
+var imageSourceMap = make(map[origin.SourceType]ImageSource)
+var imageSourceFactoryMap = make(map[origin.SourceType]ImageSourceFactoryFunction)
+
+type ImageSource interface {
+	GetImage(*http.Request, *origin.Origin, string, bool) ([]byte, error)
+}
+
+func RegisterSource(sourceType origin.SourceType, factory ImageSourceFactoryFunction) {
+	imageSourceFactoryMap[sourceType] = factory
+}
+
+// LoadSources creates the registered image sources by the config, whose type is set for each source.
+func LoadSources(config SourceConfig) {
+	for name, factory := range imageSourceFactoryMap {
+		c := config
+		c.Type = name
+		imageSourceMap[name] = factory(&c)
+	}
+}
+
+// GetSource returns the loaded image source of the type, or nil if it is not loaded.
+func GetSource(sourceType origin.SourceType) ImageSource {
+	return imageSourceMap[sourceType]
+}
+
+func GetHttpSource() *HttpImageSource {
+	return imageSourceMap[origin.SourceTypeHTTP].(*HttpImageSource)
+}
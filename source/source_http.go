@@ -1,4 +1,4 @@
-package main
+package source
 
 import (
 	"fmt"
@@ -7,6 +7,9 @@ import (
 	"net/url"
 	"path"
 	"strconv"
+
+	"github.com/tsu1980/thumbnary"
+	"github.com/tsu1980/thumbnary/origin"
 )
 
 type HttpImageSource struct {
@@ -17,7 +20,7 @@ func NewHttpImageSource(config *SourceConfig) ImageSource {
 	return &HttpImageSource{config}
 }
 
-func (s *HttpImageSource) GetImage(ireq *http.Request, origin *Origin, filePath string, isExternalHTTPSource bool) ([]byte, error) {
+func (s *HttpImageSource) GetImage(ireq *http.Request, origin *origin.Origin, filePath string, isExternalHTTPSource bool) ([]byte, error) {
 	var uri *url.URL
 	var err error
 	if isExternalHTTPSource {
@@ -41,10 +44,11 @@ func (s *HttpImageSource) GetImage(ireq *http.Request, origin *Origin, filePath
 		}
 	}
 
-	return s.fetchImage(uri, ireq)
+	return s.FetchImage(uri, ireq)
 }
 
-func (s *HttpImageSource) fetchImage(url *url.URL, ireq *http.Request) ([]byte, error) {
+// FetchImage fetches the image of the URL, the headers of the request are forwarded if configured
+func (s *HttpImageSource) FetchImage(url *url.URL, ireq *http.Request) ([]byte, error) {
 	// Check remote image size by fetching HTTP Headers
 	if s.Config.MaxAllowedSize > 0 {
 		req := newHTTPRequest(s, ireq, "HEAD", url)
@@ -97,7 +101,7 @@ func (s *HttpImageSource) setAuthorizationHeader(req *http.Request, ireq *http.R
 
 func newHTTPRequest(s *HttpImageSource, ireq *http.Request, method string, url *url.URL) *http.Request {
 	req, _ := http.NewRequest(method, url.String(), nil)
-	req.Header.Set("User-Agent", "thumbnary/"+Version)
+	req.Header.Set("User-Agent", "thumbnary/"+thumbnary.Version)
 	req.URL = url
 
 	// Forward auth header to the target server, if necessary
@@ -109,5 +113,5 @@ func newHTTPRequest(s *HttpImageSource, ireq *http.Request, method string, url *
 }
 
 func init() {
-	RegisterSource(ImageSourceTypeHttp, NewHttpImageSource)
+	RegisterSource(origin.SourceTypeHTTP, NewHttpImageSource)
 }
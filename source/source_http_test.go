@@ -1,4 +1,4 @@
-package main
+package source
 
 import (
 	"io/ioutil"
@@ -6,10 +6,12 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"testing"
+
+	"github.com/tsu1980/thumbnary/origin"
 )
 
-const fixtureImage = "testdata/large.jpg"
-const fixture1024Bytes = "testdata/1024bytes"
+const fixtureImage = "../testdata/large.jpg"
+const fixture1024Bytes = "../testdata/1024bytes"
 
 func TestHttpImageSource(t *testing.T) {
 	var body []byte
@@ -24,9 +26,9 @@ func TestHttpImageSource(t *testing.T) {
 	source := NewHttpImageSource(&SourceConfig{})
 	fakeHandler := func(w http.ResponseWriter, r *http.Request) {
 		tsURL, _ := url.Parse(ts.URL)
-		origin := &Origin{
+		origin := &origin.Origin{
 			Slug:            "qic0bfzg",
-			SourceType:      ImageSourceTypeHttp,
+			SourceType:      origin.SourceTypeHTTP,
 			Scheme:          tsURL.Scheme,
 			Host:            tsURL.Host,
 			PathPrefix:      "/",
@@ -82,9 +84,9 @@ func TestHttpImageSourceError(t *testing.T) {
 	source := NewHttpImageSource(&SourceConfig{})
 	fakeHandler := func(w http.ResponseWriter, r *http.Request) {
 		tsURL, _ := url.Parse(ts.URL)
-		origin := &Origin{
+		origin := &origin.Origin{
 			Slug:            "qic0bfzg",
-			SourceType:      ImageSourceTypeHttp,
+			SourceType:      origin.SourceTypeHTTP,
 			Scheme:          tsURL.Scheme,
 			Host:            tsURL.Host,
 			PathPrefix:      "/",
@@ -117,9 +119,9 @@ func TestHttpImageSourceExceedsMaximumAllowedLength(t *testing.T) {
 	})
 	fakeHandler := func(w http.ResponseWriter, r *http.Request) {
 		tsURL, _ := url.Parse(ts.URL)
-		origin := &Origin{
+		origin := &origin.Origin{
 			Slug:            "qic0bfzg",
-			SourceType:      ImageSourceTypeHttp,
+			SourceType:      origin.SourceTypeHTTP,
 			Scheme:          tsURL.Scheme,
 			Host:            tsURL.Host,
 			PathPrefix:      "/",
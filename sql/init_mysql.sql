@@ -17,9 +17,32 @@ CREATE TABLE `origin` (
   `URLSignatureKey_Previous` char(43) NOT NULL COMMENT 'Previous URL signature key',
   `URLSignatureKey_Version` int(11) unsigned NOT NULL COMMENT 'URL signature key version(1 or larger)',
   `AllowExternalHTTPSource` tinyint(1) NOT NULL COMMENT 'Allow external http source. must be used with URL signature',
+  `MaxAnimationFrames` int(11) unsigned NOT NULL DEFAULT 0 COMMENT 'Maximum number of animation frames(0=unlimited)',
+  `MaxAnimationMP` int(11) unsigned NOT NULL DEFAULT 0 COMMENT 'Maximum total area of all animation frames in megapixel(0=unlimited)',
+  `OutputICC` varchar(255) NOT NULL DEFAULT '' COMMENT 'Absolute path to the output ICC profile(empty=server default)',
+  `MetadataMode` char(16) NOT NULL DEFAULT 'strip' COMMENT 'Default metadata mode(strip, keep or copyright)',
+  `ExposeGPSMetadata` tinyint(1) NOT NULL DEFAULT 0 COMMENT 'Expose GPS location in image metadata endpoint',
+  `PresetsOnly` tinyint(1) NOT NULL DEFAULT 0 COMMENT 'Forbid params other than presets',
+  `StrictParams` char(8) NOT NULL DEFAULT '' COMMENT 'Params validation(strict, lenient or empty=server default)',
   `CreatedDateJST` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
   `LastUpdatedDateJST` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
   PRIMARY KEY (`ID`),
   UNIQUE KEY `idx_Slug` (`Slug`),
   KEY `idx_LastUpdatedDateJST` (`LastUpdatedDateJST`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
+
+--
+-- Table structure for table `origin_preset`
+--
+
+DROP TABLE IF EXISTS `origin_preset`;
+CREATE TABLE `origin_preset` (
+  `ID` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `OriginSlug` char(8) NOT NULL COMMENT 'Slug of the origin',
+  `Name` char(32) NOT NULL COMMENT 'Preset name(e.g. card)',
+  `Params` varchar(1024) NOT NULL COMMENT 'Params of the preset(e.g. w=300,h=200,m=crop)',
+  `CreatedDateJST` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  `LastUpdatedDateJST` datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (`ID`),
+  UNIQUE KEY `idx_OriginSlug_Name` (`OriginSlug`, `Name`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_bin;
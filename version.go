@@ -1,4 +1,4 @@
-package main
+package thumbnary
 
 import "gopkg.in/h2non/bimg.v1"
 